@@ -40,8 +40,53 @@ const (
 	defaultSolaceStreamingQueueGroup                  = "usage-telemetry-publisher"
 	defaultSolaceChannels                             = ""
 	defaultEventsFilePath                             = "/etc/config/events.yaml"
+	defaultScrubPolicyFilePath                        = "/etc/config/scrub.yaml"
+	defaultScrubPolicyOverlaysFilePath                = "/etc/config/scrub-overlays.yaml"
+	defaultEventSchemasFilePath                       = "/etc/config/event-schemas.yaml"
+	defaultPseudonymKey                               = ""
+	defaultPseudonymKeyID                             = ""
+	defaultTenantHashSecret                           = ""
+	defaultSecretScanEnabled                          = true
+	defaultTokenVaultEnabled                          = false
+	defaultTokenVaultPath                             = "/var/lib/usage-telemetry-publisher/token-vault/tokens"
+	defaultTokenVaultKey                              = ""
+	defaultDetokenizeScope                            = "telemetry:detokenize"
+	defaultDeadLettersScope                           = "telemetry:dead-letters"
 	defaultSkipPurgeEvents                            = true
 	defaultFeatureFlagsEnabled                        = false
+	defaultSinkType                                   = "stdout"
+	defaultFileSinkDirectory                          = "/var/lib/usage-telemetry-publisher/events"
+	defaultFileSinkMaxSizeBytes                       = 64 * 1024 * 1024
+	defaultFileSinkMaxAgeSeconds                      = 300
+	defaultFileSinkCompressionEnabled                 = true
+	defaultFileSinkFormat                             = "ndjson"
+	defaultHTTPSinkURL                                = ""
+	defaultHTTPSinkBatchSize                          = 500
+	defaultHTTPSinkLingerMilliseconds                 = 1000
+	defaultHTTPSinkMaxRetries                         = 5
+	defaultHTTPSinkInitialBackoffMilliseconds         = 200
+	defaultHTTPSinkMaxBackoffMilliseconds             = 30000
+	defaultHTTPSinkTimeoutMilliseconds                = 10000
+	defaultHTTPSinkFormat                             = "ndjson"
+	defaultHTTPSinkCompression                        = "none"
+	defaultFlattenArrayStrategy                       = "json"
+	defaultFlattenMaxDepth                            = 10
+	defaultFlattenMaxKeys                             = 250
+	defaultParquetCompression                         = "snappy"
+	defaultAvroSchemaRegistryDirectory                = "/var/lib/usage-telemetry-publisher/avro-schemas"
+	defaultIntermediateStorageDirectory               = "/var/lib/usage-telemetry-publisher/intermediate-storage"
+	defaultIntermediateStorageSegmentMaxSizeBytes     = 16 * 1024 * 1024
+	defaultIntermediateStorageMaxSizeBytes            = 1024 * 1024 * 1024
+	defaultIntermediateStorageSyncPolicy              = "interval"
+	defaultIntermediateStorageSyncIntervalMs          = 1000
+	defaultIntermediateStorageForwardBatchSize        = 500
+	defaultDeadLetterStoreType                        = "log"
+	defaultDeadLetterDirectory                        = "/var/lib/usage-telemetry-publisher/dead-letters"
+	defaultDeadLetterTopic                            = "system-events.usage-telemetry-publisher/dead-letters"
+	defaultDedupEnabled                               = true
+	defaultDedupTTLSeconds                            = 600
+	defaultDedupMaxEntries                            = 100000
+	defaultDedupPersistenceEnabled                    = false
 )
 
 // Spec defines the schema for configurations
@@ -67,6 +112,16 @@ type Spec struct {
 	SolaceChannels             string `mapstructure:"solace_channels"`
 	MessagingEnabled           bool   `mapstructure:"messaging_enabled"`
 	IntermediateStorageEnabled bool   `mapstructure:"intermediate_storage_enabled"`
+	// IntermediateStorageDirectory is the directory of the write-ahead log events are stored in before they are forwarded to the sink
+	IntermediateStorageDirectory string `mapstructure:"intermediate_storage_directory"`
+	// IntermediateStorageSegmentMaxSizeBytes is the size at which a new write-ahead log segment is started
+	IntermediateStorageSegmentMaxSizeBytes int64 `mapstructure:"intermediate_storage_segment_max_size_bytes" validate:"gte=0"`
+	// IntermediateStorageMaxSizeBytes is the size at which the write-ahead log stops accepting events, 0 disables the limit
+	IntermediateStorageMaxSizeBytes int64 `mapstructure:"intermediate_storage_max_size_bytes" validate:"gte=0"`
+	// IntermediateStorageSyncPolicy controls when the write-ahead log is fsynced: always, interval or never
+	IntermediateStorageSyncPolicy       string `mapstructure:"intermediate_storage_sync_policy" validate:"oneof=always interval never"`
+	IntermediateStorageSyncIntervalMs   int    `mapstructure:"intermediate_storage_sync_interval_ms" validate:"gte=0"`
+	IntermediateStorageForwardBatchSize int    `mapstructure:"intermediate_storage_forward_batch_size" validate:"gt=0"`
 	// MessagingConnectionCheckIntervalSeconds is the interval of checking the connection to messaging
 	MessagingConnectionCheckIntervalSeconds int `mapstructure:"messaging_connection_check_interval_seconds" validate:"gte=0"`
 	// MessagingPublishBufferSize is the maximum number of async published msgs to be buffered
@@ -85,12 +140,104 @@ type Spec struct {
 	AuthS2SJwtAud  string `mapstructure:"auth_s2s_jwt_Aud"`
 	AuthJwtIss     string `mapstructure:"auth_jwt_iss"`
 	EventsFilePath string `mapstructure:"events_file_path"`
+	// ScrubPolicyFilePath is the YAML file assigning scrub actions to event attributes and data paths,
+	// a built-in policy pseudonymizing user, session and owner ids is used when it does not exist
+	ScrubPolicyFilePath string `mapstructure:"scrub_policy_file_path"`
+	// ScrubPolicyOverlaysFilePath is the YAML file of the overlays changing the scrub policy for specific tenants,
+	// all tenants get the scrub policy as it is when it does not exist
+	ScrubPolicyOverlaysFilePath string `mapstructure:"scrub_policy_overlays_file_path"`
+	// EventSchemasFilePath is the YAML file of the JSON Schema fragments events of specific types must conform to,
+	// no event is validated against a schema when it does not exist
+	EventSchemasFilePath string `mapstructure:"event_schemas_file_path"`
+	// PseudonymKey is the secret pseudonymize scrub rules derive HMAC-SHA256 pseudonyms from.
+	// A random key is generated at startup when it is empty, so pseudonyms are only stable until the next restart.
+	PseudonymKey     string `mapstructure:"pseudonym_key"`
+	PseudonymKeyFile string `mapstructure:"pseudonym_key_file"`
+	// PseudonymKeyID identifies PseudonymKey in the output, it defaults to a fingerprint of the key.
+	// Rotating the key changes every pseudonym, so the id must change with it.
+	PseudonymKeyID string `mapstructure:"pseudonym_key_id"`
+	// TenantHashSecret is the master secret tenant_hash scrub rules derive per tenant salts from.
+	// A random secret is generated at startup when it is empty, so hashes are only stable until the next restart.
+	TenantHashSecret     string `mapstructure:"tenant_hash_secret"`
+	TenantHashSecretFile string `mapstructure:"tenant_hash_secret_file"`
+	// TokenVaultEnabled stores the values behind pseudonyms in an encrypted vault at TokenVaultPath,
+	// so they can be reversed through the detokenize endpoint. Pseudonyms are one-way when it is disabled.
+	// The vault is local to a replica and must not be shared by replicas, as its file has a single writer:
+	// a token can only be detokenized by the replica that issued it, so run a single replica to detokenize every token.
+	TokenVaultEnabled bool   `mapstructure:"token_vault_enabled"`
+	TokenVaultPath    string `mapstructure:"token_vault_path"`
+	// TokenVaultKey is the secret the values in the vault are encrypted with, it is required when the vault is enabled
+	TokenVaultKey     string `mapstructure:"token_vault_key"`
+	TokenVaultKeyFile string `mapstructure:"token_vault_key_file"`
+	// DetokenizeScope is the JWT scope callers of the detokenize endpoint must be granted
+	DetokenizeScope string `mapstructure:"detokenize_scope"`
+	// DeadLettersScope is the JWT scope callers of the dead letter endpoints must be granted
+	DeadLettersScope string `mapstructure:"dead_letters_scope"`
+	// SecretScanEnabled redacts credentials and high entropy tokens left in scrubbed events before they are published
+	SecretScanEnabled bool `mapstructure:"secret_scan_enabled"`
 
 	// SkipPurgeEvents if true event types that end with '.purged' are not written to mongo
 	SkipPurgeEvents bool `mapstructure:"skip_purge_events"`
 
 	// FeatureFlagsEnabled enables or disables the feature flags use
 	FeatureFlagsEnabled bool `mapstructure:"feature_flags_enabled"`
+
+	// SinkType selects the output scrubbed events are published to
+	SinkType string `mapstructure:"sink_type" validate:"oneof=stdout file http"`
+	// FileSinkDirectory is the directory the file sink writes finalized files to
+	FileSinkDirectory string `mapstructure:"file_sink_directory"`
+	// FileSinkMaxSizeBytes rotates the current file sink file once it reaches this size, 0 disables size based rotation
+	FileSinkMaxSizeBytes int64 `mapstructure:"file_sink_max_size_bytes" validate:"gte=0"`
+	// FileSinkMaxAgeSeconds rotates the current file sink file once it is this old, 0 disables age based rotation
+	FileSinkMaxAgeSeconds int `mapstructure:"file_sink_max_age_seconds" validate:"gte=0"`
+	// FileSinkCompressionEnabled gzips file sink files when they are rotated
+	FileSinkCompressionEnabled bool `mapstructure:"file_sink_compression_enabled"`
+	// FileSinkFormat is the format of file sink files, csv, parquet and avro files hold a single batch each
+	FileSinkFormat string `mapstructure:"file_sink_format" validate:"oneof=ndjson csv parquet avro"`
+	// HTTPSinkURL is the webhook the http sink posts batches to
+	HTTPSinkURL string `mapstructure:"http_sink_url" validate:"required_if=SinkType http"`
+	// HTTPSinkBatchSize is the number of events the http sink sends per request
+	HTTPSinkBatchSize int `mapstructure:"http_sink_batch_size" validate:"gt=0"`
+	// HTTPSinkLingerMilliseconds is how long the http sink waits for a batch to fill up before sending it
+	HTTPSinkLingerMilliseconds int `mapstructure:"http_sink_linger_milliseconds" validate:"gte=0"`
+	// HTTPSinkMaxRetries is the number of retries of a batch that failed with a retryable error
+	HTTPSinkMaxRetries                 int `mapstructure:"http_sink_max_retries" validate:"gte=0"`
+	HTTPSinkInitialBackoffMilliseconds int `mapstructure:"http_sink_initial_backoff_milliseconds" validate:"gte=0"`
+	HTTPSinkMaxBackoffMilliseconds     int `mapstructure:"http_sink_max_backoff_milliseconds" validate:"gte=0"`
+	HTTPSinkTimeoutMilliseconds        int `mapstructure:"http_sink_timeout_milliseconds" validate:"gte=0"`
+	// HTTPSinkFormat is the format of the http sink request bodies
+	HTTPSinkFormat string `mapstructure:"http_sink_format" validate:"oneof=ndjson csv parquet avro"`
+	// HTTPSinkCompression compresses the http sink request bodies, announced with their Content-Encoding
+	HTTPSinkCompression string `mapstructure:"http_sink_compression" validate:"oneof=none gzip zstd"`
+	// FlattenArrayStrategy selects how sinks flatten arrays in event data: json encoded strings, indexed keys
+	// or one record per element of the first non-empty array
+	FlattenArrayStrategy string `mapstructure:"flatten_array_strategy" validate:"oneof=json indexed explode"`
+	// FlattenMaxDepth caps the path segments of flattened data keys, deeper values are JSON encoded, 0 disables the cap
+	FlattenMaxDepth int `mapstructure:"flatten_max_depth" validate:"gte=0"`
+	// FlattenMaxKeys caps the flattened dimensions of a record, the remaining ones go to the overflow dimension, 0 disables the cap
+	FlattenMaxKeys int `mapstructure:"flatten_max_keys" validate:"gte=0"`
+	// ParquetCompression is the codec of the column chunks of parquet files
+	ParquetCompression string `mapstructure:"parquet_compression" validate:"oneof=snappy zstd"`
+	// AvroSchemaRegistryDirectory persists the schemas of avro files by their fingerprint, empty keeps them in memory only.
+	// Replicas may share it, schemas registered by one replica are then served and extended by the others.
+	AvroSchemaRegistryDirectory string `mapstructure:"avro_schema_registry_directory"`
+
+	// DeadLetterStoreType selects where rejected events are kept: log only logs their metadata,
+	// file keeps them in DeadLetterDirectory and topic publishes them to DeadLetterTopic
+	DeadLetterStoreType string `mapstructure:"dead_letter_store_type" validate:"oneof=log file topic"`
+	// DeadLetterDirectory is the directory of the file dead letter store
+	DeadLetterDirectory string `mapstructure:"dead_letter_directory"`
+	// DeadLetterTopic is the messaging topic of the topic dead letter store
+	DeadLetterTopic string `mapstructure:"dead_letter_topic"`
+
+	// DedupEnabled drops events whose tenant and id were already published within DedupTTLSeconds
+	DedupEnabled    bool `mapstructure:"dedup_enabled"`
+	DedupTTLSeconds int  `mapstructure:"dedup_ttl_seconds" validate:"gt=0"`
+	// DedupMaxEntries bounds the number of remembered event ids, the oldest ones are evicted first
+	DedupMaxEntries int `mapstructure:"dedup_max_entries" validate:"gt=0"`
+	// DedupPersistenceEnabled snapshots the remembered event ids to the intermediate storage directory,
+	// it only applies when intermediate storage is enabled
+	DedupPersistenceEnabled bool `mapstructure:"dedup_persistence_enabled"`
 }
 
 // Global is a struct variable, holding global configuration values.
@@ -123,8 +270,54 @@ func newSpec() *Spec {
 		MessagingPublishBufferSize:              defaultMessagingPublishBufferSize,
 		MessagingConnectionCheckIntervalSeconds: defaultMessagingConnectionCheckIntervalSeconds,
 		EventsFilePath:                          defaultEventsFilePath,
+		ScrubPolicyFilePath:                     defaultScrubPolicyFilePath,
+		ScrubPolicyOverlaysFilePath:             defaultScrubPolicyOverlaysFilePath,
+		EventSchemasFilePath:                    defaultEventSchemasFilePath,
+		PseudonymKey:                            defaultPseudonymKey,
+		PseudonymKeyID:                          defaultPseudonymKeyID,
+		TenantHashSecret:                        defaultTenantHashSecret,
+		SecretScanEnabled:                       defaultSecretScanEnabled,
+		TokenVaultEnabled:                       defaultTokenVaultEnabled,
+		TokenVaultPath:                          defaultTokenVaultPath,
+		TokenVaultKey:                           defaultTokenVaultKey,
+		DetokenizeScope:                         defaultDetokenizeScope,
+		DeadLettersScope:                        defaultDeadLettersScope,
 		SkipPurgeEvents:                         defaultSkipPurgeEvents,
 		FeatureFlagsEnabled:                     defaultFeatureFlagsEnabled,
+		SinkType:                                defaultSinkType,
+		FileSinkDirectory:                       defaultFileSinkDirectory,
+		FileSinkMaxSizeBytes:                    defaultFileSinkMaxSizeBytes,
+		FileSinkMaxAgeSeconds:                   defaultFileSinkMaxAgeSeconds,
+		FileSinkCompressionEnabled:              defaultFileSinkCompressionEnabled,
+		FileSinkFormat:                          defaultFileSinkFormat,
+		HTTPSinkURL:                             defaultHTTPSinkURL,
+		HTTPSinkBatchSize:                       defaultHTTPSinkBatchSize,
+		HTTPSinkLingerMilliseconds:              defaultHTTPSinkLingerMilliseconds,
+		HTTPSinkMaxRetries:                      defaultHTTPSinkMaxRetries,
+		HTTPSinkInitialBackoffMilliseconds:      defaultHTTPSinkInitialBackoffMilliseconds,
+		HTTPSinkMaxBackoffMilliseconds:          defaultHTTPSinkMaxBackoffMilliseconds,
+		HTTPSinkTimeoutMilliseconds:             defaultHTTPSinkTimeoutMilliseconds,
+		HTTPSinkFormat:                          defaultHTTPSinkFormat,
+		HTTPSinkCompression:                     defaultHTTPSinkCompression,
+		FlattenArrayStrategy:                    defaultFlattenArrayStrategy,
+		FlattenMaxDepth:                         defaultFlattenMaxDepth,
+		FlattenMaxKeys:                          defaultFlattenMaxKeys,
+		ParquetCompression:                      defaultParquetCompression,
+		AvroSchemaRegistryDirectory:             defaultAvroSchemaRegistryDirectory,
+		IntermediateStorageEnabled:              defaultIntermediateStorageEnabled,
+		IntermediateStorageDirectory:            defaultIntermediateStorageDirectory,
+		IntermediateStorageSegmentMaxSizeBytes:  defaultIntermediateStorageSegmentMaxSizeBytes,
+		IntermediateStorageMaxSizeBytes:         defaultIntermediateStorageMaxSizeBytes,
+		IntermediateStorageSyncPolicy:           defaultIntermediateStorageSyncPolicy,
+		IntermediateStorageSyncIntervalMs:       defaultIntermediateStorageSyncIntervalMs,
+		IntermediateStorageForwardBatchSize:     defaultIntermediateStorageForwardBatchSize,
+		DeadLetterStoreType:                     defaultDeadLetterStoreType,
+		DeadLetterDirectory:                     defaultDeadLetterDirectory,
+		DeadLetterTopic:                         defaultDeadLetterTopic,
+		DedupEnabled:                            defaultDedupEnabled,
+		DedupTTLSeconds:                         defaultDedupTTLSeconds,
+		DedupMaxEntries:                         defaultDedupMaxEntries,
+		DedupPersistenceEnabled:                 defaultDedupPersistenceEnabled,
 	}
 }
 
@@ -162,6 +355,9 @@ func loadConfig(configSpec *Spec) {
 
 func loadSecrets(v *viper.Viper) {
 	v.SetDefault("launchdarkly_sdk_key", getFromEnvFile("LAUNCHDARKLY_SDK_KEY_FILE", defaultLaunchDarklySdkKey))
+	v.SetDefault("pseudonym_key", getFromEnvFile("PSEUDONYM_KEY_FILE", defaultPseudonymKey))
+	v.SetDefault("tenant_hash_secret", getFromEnvFile("TENANT_HASH_SECRET_FILE", defaultTenantHashSecret))
+	v.SetDefault("token_vault_key", getFromEnvFile("TOKEN_VAULT_KEY_FILE", defaultTokenVaultKey))
 }
 
 func getFromEnvFile(envFileVariable, defaultValue string) string {
@@ -29,4 +29,44 @@ func TestGlobal(t *testing.T) {
 	assert.Equal(t, Global.SecretKeyFile, defaultSecretKeyFile)
 	assert.Equal(t, Global.TokenURI, defaultTokenURI)
 	assert.Equal(t, Global.SkipPurgeEvents, defaultSkipPurgeEvents)
+	assert.Equal(t, Global.ScrubPolicyFilePath, defaultScrubPolicyFilePath)
+	assert.Equal(t, Global.ScrubPolicyOverlaysFilePath, defaultScrubPolicyOverlaysFilePath)
+	assert.Equal(t, Global.EventSchemasFilePath, defaultEventSchemasFilePath)
+	assert.Equal(t, Global.PseudonymKey, defaultPseudonymKey)
+	assert.Equal(t, Global.PseudonymKeyID, defaultPseudonymKeyID)
+	assert.Equal(t, Global.TenantHashSecret, defaultTenantHashSecret)
+	assert.Equal(t, Global.SecretScanEnabled, defaultSecretScanEnabled)
+	assert.Equal(t, Global.TokenVaultEnabled, defaultTokenVaultEnabled)
+	assert.Equal(t, Global.TokenVaultPath, defaultTokenVaultPath)
+	assert.Equal(t, Global.TokenVaultKey, defaultTokenVaultKey)
+	assert.Equal(t, Global.DetokenizeScope, defaultDetokenizeScope)
+	assert.Equal(t, Global.DeadLettersScope, defaultDeadLettersScope)
+	assert.Equal(t, Global.SinkType, defaultSinkType)
+	assert.Equal(t, Global.FileSinkDirectory, defaultFileSinkDirectory)
+	assert.Equal(t, Global.FileSinkMaxSizeBytes, int64(defaultFileSinkMaxSizeBytes))
+	assert.Equal(t, Global.FileSinkMaxAgeSeconds, defaultFileSinkMaxAgeSeconds)
+	assert.Equal(t, Global.FileSinkCompressionEnabled, defaultFileSinkCompressionEnabled)
+	assert.Equal(t, Global.FileSinkFormat, defaultFileSinkFormat)
+	assert.Equal(t, Global.HTTPSinkURL, defaultHTTPSinkURL)
+	assert.Equal(t, Global.HTTPSinkBatchSize, defaultHTTPSinkBatchSize)
+	assert.Equal(t, Global.HTTPSinkLingerMilliseconds, defaultHTTPSinkLingerMilliseconds)
+	assert.Equal(t, Global.HTTPSinkMaxRetries, defaultHTTPSinkMaxRetries)
+	assert.Equal(t, Global.HTTPSinkFormat, defaultHTTPSinkFormat)
+	assert.Equal(t, Global.HTTPSinkCompression, defaultHTTPSinkCompression)
+	assert.Equal(t, Global.FlattenArrayStrategy, defaultFlattenArrayStrategy)
+	assert.Equal(t, Global.FlattenMaxDepth, defaultFlattenMaxDepth)
+	assert.Equal(t, Global.FlattenMaxKeys, defaultFlattenMaxKeys)
+	assert.Equal(t, Global.ParquetCompression, defaultParquetCompression)
+	assert.Equal(t, Global.AvroSchemaRegistryDirectory, defaultAvroSchemaRegistryDirectory)
+	assert.Equal(t, Global.IntermediateStorageEnabled, defaultIntermediateStorageEnabled)
+	assert.Equal(t, Global.IntermediateStorageDirectory, defaultIntermediateStorageDirectory)
+	assert.Equal(t, Global.IntermediateStorageSyncPolicy, defaultIntermediateStorageSyncPolicy)
+	assert.Equal(t, Global.IntermediateStorageForwardBatchSize, defaultIntermediateStorageForwardBatchSize)
+	assert.Equal(t, Global.DeadLetterStoreType, defaultDeadLetterStoreType)
+	assert.Equal(t, Global.DeadLetterDirectory, defaultDeadLetterDirectory)
+	assert.Equal(t, Global.DeadLetterTopic, defaultDeadLetterTopic)
+	assert.Equal(t, Global.DedupEnabled, defaultDedupEnabled)
+	assert.Equal(t, Global.DedupTTLSeconds, defaultDedupTTLSeconds)
+	assert.Equal(t, Global.DedupMaxEntries, defaultDedupMaxEntries)
+	assert.Equal(t, Global.DedupPersistenceEnabled, defaultDedupPersistenceEnabled)
 }
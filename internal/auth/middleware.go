@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/qlik-trial/go-service-kit/v29/operation"
+)
+
+type claimsKey struct{}
+
+// ClaimsFromContext returns the claims of the token the request was authorized with by RequireScope
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(*Claims)
+	return claims, ok
+}
+
+// RequireScope returns a middleware only passing on requests with a valid bearer token granted scope.
+// Requests without a valid token are rejected with 401, requests with a token lacking the scope with 403.
+func RequireScope(verifier *Verifier, scope string) mux.MiddlewareFunc {
+	label := "auth/RequireScope"
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || token == "" {
+				http.Error(w, "missing bearer token", http.StatusUnauthorized)
+				return
+			}
+
+			claims, err := verifier.Verify(r.Context(), token)
+			if errors.Is(err, ErrInvalidToken) {
+				operation.Logger(r.Context()).Info("label", label, "message", "rejected invalid token", "error", err, "path", r.URL.Path)
+				http.Error(w, "invalid token", http.StatusUnauthorized)
+				return
+			}
+			if err != nil {
+				operation.Logger(r.Context()).Error("label", label, "message", "failed to verify token", "error", err)
+				http.Error(w, "failed to verify token", http.StatusServiceUnavailable)
+				return
+			}
+			if !claims.HasScope(scope) {
+				operation.Logger(r.Context()).Info("label", label, "message", "token lacks required scope", "subject", claims.Subject, "scope", scope, "path", r.URL.Path)
+				http.Error(w, "insufficient scope", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), claimsKey{}, claims)))
+		})
+	}
+}
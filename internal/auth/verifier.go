@@ -0,0 +1,227 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/lestrrat-go/jwx/jws"
+	"github.com/lestrrat-go/jwx/jwt"
+)
+
+const (
+	// keysRefreshInterval bounds how often the keys are fetched again, in the background and for unknown keys
+	keysRefreshInterval = time.Minute
+	// keysRetryInterval is how long tokens are rejected without fetching the keys again after fetching them failed
+	keysRetryInterval = 10 * time.Second
+	// clockSkew is tolerated when checking the expiry and not before times of tokens
+	clockSkew = 30 * time.Second
+	// minRSAKeyBits is the smallest RSA modulus tokens are accepted for
+	minRSAKeyBits = 2048
+	// maxRSAExponent bounds the public exponent of RSA keys, so it fits an int on every platform
+	maxRSAExponent = 1<<31 - 1
+)
+
+// ErrInvalidToken is wrapped by the errors of tokens that are malformed, not signed by a known key, expired,
+// or issued by or for someone else
+var ErrInvalidToken = errors.New("invalid token")
+
+// Claims are the claims of a verified token
+type Claims struct {
+	Subject  string
+	Issuer   string
+	Audience []string
+	TenantID string
+	Scope    []string
+}
+
+// HasScope reports whether the token was granted scope
+func (c *Claims) HasScope(scope string) bool {
+	return slices.Contains(c.Scope, scope)
+}
+
+// Verifier verifies JWTs against the keys published as a JSON Web Key Set. The keys are cached and refreshed in the
+// background. The signature algorithm is taken from the key, so a token cannot choose a weaker one than its key.
+type Verifier struct {
+	keysURL  string
+	issuer   string
+	audience string
+	keys     *jwk.AutoRefresh
+	now      func() time.Time
+
+	mu          sync.Mutex
+	fetchErr    error
+	failedAt    time.Time
+	refreshedAt time.Time
+}
+
+// NewVerifier creates a Verifier accepting tokens issued by issuer for audience, signed with a key from keysURL.
+// The keys are refreshed until ctx is done.
+func NewVerifier(ctx context.Context, keysURL, issuer, audience string) *Verifier {
+	keys := jwk.NewAutoRefresh(ctx)
+	keys.Configure(keysURL,
+		jwk.WithMinRefreshInterval(keysRefreshInterval),
+		jwk.WithHTTPClient(&http.Client{Timeout: 10 * time.Second}))
+	return &Verifier{keysURL: keysURL, issuer: issuer, audience: audience, keys: keys, now: time.Now}
+}
+
+// Verify checks the signature, issuer, audience and validity period of token and returns its claims
+func (v *Verifier) Verify(ctx context.Context, token string) (*Claims, error) {
+	msg, err := jws.ParseString(token)
+	if err != nil || len(msg.Signatures()) != 1 {
+		return nil, fmt.Errorf("%w: malformed token", ErrInvalidToken)
+	}
+	headers := msg.Signatures()[0].ProtectedHeaders()
+
+	key, err := v.key(ctx, headers.KeyID())
+	if err != nil {
+		return nil, err
+	}
+	alg, raw, err := verificationKey(key, headers.Algorithm())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	parsed, err := jwt.ParseString(token,
+		jwt.WithVerify(alg, raw),
+		jwt.WithValidate(true),
+		jwt.WithIssuer(v.issuer),
+		jwt.WithAudience(v.audience),
+		jwt.WithRequiredClaim(jwt.ExpirationKey),
+		jwt.WithAcceptableSkew(clockSkew),
+		jwt.WithClock(jwt.ClockFunc(v.now)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	claims := &Claims{Subject: parsed.Subject(), Issuer: parsed.Issuer(), Audience: parsed.Audience()}
+	if tenantID, ok := parsed.PrivateClaims()["tenantId"].(string); ok {
+		claims.TenantID = tenantID
+	}
+	claims.Scope = scopes(parsed.PrivateClaims()["scope"])
+	return claims, nil
+}
+
+// key returns the key with id kid. Unknown keys fetch the keys again at most every keysRefreshInterval,
+// and after a failed fetch tokens are rejected for keysRetryInterval without fetching them again.
+func (v *Verifier) key(ctx context.Context, kid string) (jwk.Key, error) {
+	v.mu.Lock()
+	if v.fetchErr != nil && v.now().Sub(v.failedAt) < keysRetryInterval {
+		err := v.fetchErr
+		v.mu.Unlock()
+		return nil, err
+	}
+	v.mu.Unlock()
+
+	set, err := v.keys.Fetch(ctx, v.keysURL)
+	if err != nil {
+		return nil, v.fetchFailed(ctx, err)
+	}
+	if key, ok := set.LookupKeyID(kid); ok {
+		return key, nil
+	}
+
+	v.mu.Lock()
+	refresh := v.now().Sub(v.refreshedAt) >= keysRefreshInterval
+	if refresh {
+		v.refreshedAt = v.now()
+	}
+	v.mu.Unlock()
+	if refresh {
+		if set, err = v.keys.Refresh(ctx, v.keysURL); err != nil {
+			return nil, v.fetchFailed(ctx, err)
+		}
+		if key, ok := set.LookupKeyID(kid); ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+}
+
+// fetchFailed records a failed fetch of the keys, unless the request it was made for was canceled
+func (v *Verifier) fetchFailed(ctx context.Context, err error) error {
+	err = fmt.Errorf("failed to fetch keys: %w", err)
+	if ctx.Err() != nil {
+		return err
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.fetchErr, v.failedAt = err, v.now()
+	return err
+}
+
+// verificationKey returns the algorithm and raw public key to verify a signature with key. EC keys only verify the
+// algorithm of their curve, RSA keys the algorithm of the key or, if it has none, the RS algorithm of the token.
+func verificationKey(key jwk.Key, tokenAlg jwa.SignatureAlgorithm) (jwa.SignatureAlgorithm, any, error) {
+	switch key := key.(type) {
+	case jwk.ECDSAPublicKey:
+		var alg jwa.SignatureAlgorithm
+		switch key.Crv() {
+		case jwa.P256:
+			alg = jwa.ES256
+		case jwa.P384:
+			alg = jwa.ES384
+		case jwa.P521:
+			alg = jwa.ES512
+		default:
+			return "", nil, fmt.Errorf("unsupported curve %q", key.Crv())
+		}
+		if key.Algorithm() != "" && key.Algorithm() != alg.String() {
+			return "", nil, fmt.Errorf("key algorithm %q does not match its curve", key.Algorithm())
+		}
+		var raw ecdsa.PublicKey
+		if err := key.Raw(&raw); err != nil {
+			return "", nil, fmt.Errorf("malformed key: %w", err)
+		}
+		return alg, &raw, nil
+	case jwk.RSAPublicKey:
+		alg := tokenAlg
+		if key.Algorithm() != "" {
+			alg = jwa.SignatureAlgorithm(key.Algorithm())
+		}
+		if !slices.Contains([]jwa.SignatureAlgorithm{jwa.RS256, jwa.RS384, jwa.RS512}, alg) {
+			return "", nil, fmt.Errorf("unsupported algorithm %q for an RSA key", alg)
+		}
+		if e := new(big.Int).SetBytes(key.E()); e.Cmp(big.NewInt(3)) < 0 || e.Cmp(big.NewInt(maxRSAExponent)) > 0 {
+			return "", nil, errors.New("unsupported RSA public exponent")
+		}
+		var raw rsa.PublicKey
+		if err := key.Raw(&raw); err != nil {
+			return "", nil, fmt.Errorf("malformed key: %w", err)
+		}
+		if raw.N.BitLen() < minRSAKeyBits {
+			return "", nil, fmt.Errorf("RSA key of %d bits is too small", raw.N.BitLen())
+		}
+		return alg, &raw, nil
+	default:
+		return "", nil, fmt.Errorf("unsupported key type %q", key.KeyType())
+	}
+}
+
+// scopes returns the scopes of a space separated list or a list of them
+func scopes(value any) []string {
+	switch value := value.(type) {
+	case string:
+		return strings.Fields(value)
+	case []any:
+		var result []string
+		for _, scope := range value {
+			if scope, ok := scope.(string); ok {
+				result = append(result, scope)
+			}
+		}
+		return result
+	default:
+		return nil
+	}
+}
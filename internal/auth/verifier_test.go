@@ -0,0 +1,292 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/lestrrat-go/jwx/jws"
+	"github.com/lestrrat-go/jwx/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// keyServer publishes the public keys of its private keys as a JSON Web Key Set
+type keyServer struct {
+	*httptest.Server
+	requests atomic.Int32
+
+	mu      sync.Mutex
+	private map[string]any
+	keys    []any
+	status  int
+}
+
+func newKeyServer(t *testing.T) *keyServer {
+	t.Helper()
+	s := &keyServer{private: map[string]any{}, status: http.StatusOK}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		s.requests.Add(1)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.status != http.StatusOK {
+			w.WriteHeader(s.status)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": s.keys})
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// add publishes the public key of private with id kid
+func (s *keyServer) add(t *testing.T, kid string, private any) {
+	t.Helper()
+	key, err := jwk.PublicKeyOf(private)
+	require.NoError(t, err)
+	require.NoError(t, key.Set(jwk.KeyIDKey, kid))
+	s.publish(kid, private, key)
+}
+
+// publish publishes key as the key with id kid, signing tokens of kid with private
+func (s *keyServer) publish(kid string, private, key any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.private[kid] = private
+	s.keys = append(s.keys, key)
+}
+
+func (s *keyServer) sign(t *testing.T, kid string, alg jwa.SignatureAlgorithm, claims map[string]any) string {
+	t.Helper()
+	token := jwt.New()
+	for name, value := range claims {
+		require.NoError(t, token.Set(name, value))
+	}
+	headers := jws.NewHeaders()
+	require.NoError(t, headers.Set(jws.KeyIDKey, kid))
+	s.mu.Lock()
+	private := s.private[kid]
+	s.mu.Unlock()
+	signed, err := jwt.Sign(token, alg, private, jwt.WithHeaders(headers))
+	require.NoError(t, err)
+	return string(signed)
+}
+
+func newTestKeys(t *testing.T) *keyServer {
+	t.Helper()
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	s := newKeyServer(t)
+	s.add(t, "ec", ecKey)
+	s.add(t, "rsa", rsaKey)
+	s.publish("oct", []byte("secret"), map[string]string{"kid": "oct", "kty": "oct", "k": base64.RawURLEncoding.EncodeToString([]byte("secret"))})
+	return s
+}
+
+func newTestVerifier(t *testing.T, keysURL string) *Verifier {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return NewVerifier(ctx, keysURL, "qlik.api.internal", "qlik.api.internal")
+}
+
+func validClaims() map[string]any {
+	return map[string]any{
+		"sub":      "support-user",
+		"iss":      "qlik.api.internal",
+		"aud":      []string{"qlik.api.internal"},
+		"exp":      time.Now().Add(time.Minute),
+		"tenantId": "tenant_123",
+		"scope":    "telemetry:read telemetry:detokenize",
+	}
+}
+
+func TestVerifier_Verify(t *testing.T) {
+	keys := newTestKeys(t)
+	verifier := newTestVerifier(t, keys.URL)
+
+	for kid, alg := range map[string]jwa.SignatureAlgorithm{"ec": jwa.ES384, "rsa": jwa.RS256} {
+		t.Run(kid, func(t *testing.T) {
+			claims, err := verifier.Verify(context.Background(), keys.sign(t, kid, alg, validClaims()))
+			require.NoError(t, err)
+			assert.Equal(t, "support-user", claims.Subject)
+			assert.Equal(t, "tenant_123", claims.TenantID)
+			assert.True(t, claims.HasScope("telemetry:detokenize"))
+			assert.False(t, claims.HasScope("telemetry:admin"))
+		})
+	}
+
+	claims := validClaims()
+	claims["scope"] = []string{"telemetry:read"}
+	verified, err := verifier.Verify(context.Background(), keys.sign(t, "rsa", jwa.RS256, claims))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"telemetry:read"}, verified.Scope)
+}
+
+func TestVerifier_Verify_Invalid(t *testing.T) {
+	keys := newTestKeys(t)
+	smallKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	keys.add(t, "small", smallKey)
+	hugeExponent, err := jwk.PublicKeyOf(&keys.private["rsa"].(*rsa.PrivateKey).PublicKey)
+	require.NoError(t, err)
+	require.NoError(t, hugeExponent.Set(jwk.KeyIDKey, "exponent"))
+	require.NoError(t, hugeExponent.Set(jwk.RSAEKey, []byte{1, 0, 0, 0, 0, 0, 0, 1}))
+	keys.publish("exponent", keys.private["rsa"], hugeExponent)
+	verifier := newTestVerifier(t, keys.URL)
+
+	with := func(name string, value any) map[string]any {
+		claims := validClaims()
+		claims[name] = value
+		return claims
+	}
+	without := func(name string) map[string]any {
+		claims := validClaims()
+		delete(claims, name)
+		return claims
+	}
+	tampered := keys.sign(t, "ec", jwa.ES384, validClaims())
+	tampered = tampered[:len(tampered)-4] + "AAAA"
+
+	tests := map[string]string{
+		"malformed":                "not-a-token",
+		"tampered":                 tampered,
+		"unknown key":              unknownKeyToken(t),
+		"wrong issuer":             keys.sign(t, "ec", jwa.ES384, with("iss", "someone-else")),
+		"wrong audience":           keys.sign(t, "rsa", jwa.RS256, with("aud", []string{"other.api"})),
+		"expired":                  keys.sign(t, "ec", jwa.ES384, with("exp", time.Now().Add(-time.Hour))),
+		"no expiry":                keys.sign(t, "ec", jwa.ES384, without("exp")),
+		"not yet valid":            keys.sign(t, "rsa", jwa.RS256, with("nbf", time.Now().Add(time.Hour))),
+		"symmetric key":            keys.sign(t, "oct", jwa.HS256, validClaims()),
+		"algorithm of other curve": keys.sign(t, "ec", jwa.ES256, validClaims()),
+		"non RSA algorithm":        keys.sign(t, "rsa", jwa.PS256, validClaims()),
+		"small RSA key":            keys.sign(t, "small", jwa.RS256, validClaims()),
+		"oversized RSA exponent":   keys.sign(t, "exponent", jwa.RS256, validClaims()),
+		"unsigned":                 unsignedToken(t),
+	}
+
+	// the key set parses, each token is rejected for its own reason
+	_, err = verifier.Verify(context.Background(), keys.sign(t, "rsa", jwa.RS256, validClaims()))
+	require.NoError(t, err)
+
+	for name, token := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := verifier.Verify(context.Background(), token)
+			require.ErrorIs(t, err, ErrInvalidToken)
+		})
+	}
+}
+
+// unknownKeyToken returns a token signed with a key the key server does not publish
+func unknownKeyToken(t *testing.T) string {
+	t.Helper()
+	other := newKeyServer(t)
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	other.add(t, "other", key)
+	return other.sign(t, "other", jwa.ES384, validClaims())
+}
+
+// unsignedToken returns a token of a published key with the none algorithm
+func unsignedToken(t *testing.T) string {
+	t.Helper()
+	header, err := json.Marshal(map[string]string{"alg": "none", "kid": "ec"})
+	require.NoError(t, err)
+	claims := validClaims()
+	claims["exp"] = time.Now().Add(time.Minute).Unix()
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+	return base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload) + "."
+}
+
+func TestVerifier_FetchesKeysSparingly(t *testing.T) {
+	keys := newTestKeys(t)
+	verifier := newTestVerifier(t, keys.URL)
+	now := time.Now()
+	verifier.now = func() time.Time { return now }
+
+	token := keys.sign(t, "ec", jwa.ES384, validClaims())
+	_, err := verifier.Verify(context.Background(), token)
+	require.NoError(t, err)
+	_, err = verifier.Verify(context.Background(), token)
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, keys.requests.Load(), "keys are cached")
+
+	// a new key is fetched, but unknown keys only fetch the keys again once per refresh interval
+	rotated, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	keys.add(t, "rotated", rotated)
+	_, err = verifier.Verify(context.Background(), keys.sign(t, "rotated", jwa.ES256, validClaims()))
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, keys.requests.Load())
+	_, err = verifier.Verify(context.Background(), unknownKeyToken(t))
+	require.ErrorIs(t, err, ErrInvalidToken)
+	assert.EqualValues(t, 2, keys.requests.Load())
+}
+
+func TestVerifier_ThrottlesFailedFetches(t *testing.T) {
+	keys := newTestKeys(t)
+	keys.status = http.StatusInternalServerError
+	verifier := newTestVerifier(t, keys.URL)
+	now := time.Now()
+	verifier.now = func() time.Time { return now }
+
+	token := keys.sign(t, "ec", jwa.ES384, validClaims())
+	for range 3 {
+		_, err := verifier.Verify(context.Background(), token)
+		require.Error(t, err)
+		require.NotErrorIs(t, err, ErrInvalidToken)
+	}
+	assert.EqualValues(t, 1, keys.requests.Load(), "a failed fetch is not retried for every request")
+
+	keys.mu.Lock()
+	keys.status = http.StatusOK
+	keys.mu.Unlock()
+	now = now.Add(keysRetryInterval)
+	_, err := verifier.Verify(context.Background(), token)
+	require.NoError(t, err)
+}
+
+func TestRequireScope(t *testing.T) {
+	keys := newTestKeys(t)
+	verifier := newTestVerifier(t, keys.URL)
+	handler := RequireScope(verifier, "telemetry:detokenize")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := ClaimsFromContext(r.Context())
+		require.True(t, ok)
+		_, _ = w.Write([]byte(claims.Subject))
+	}))
+	serve := func(authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		return res
+	}
+
+	res := serve("Bearer " + keys.sign(t, "ec", jwa.ES384, validClaims()))
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "support-user", res.Body.String())
+
+	claims := validClaims()
+	claims["scope"] = []string{"telemetry:read"}
+	assert.Equal(t, http.StatusForbidden, serve("Bearer "+keys.sign(t, "ec", jwa.ES384, claims)).Code)
+	assert.Equal(t, http.StatusUnauthorized, serve("Bearer not-a-token").Code)
+	assert.Equal(t, http.StatusUnauthorized, serve("").Code)
+}
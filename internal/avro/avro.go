@@ -0,0 +1,86 @@
+// Package avro writes Apache Avro Object Container Files in pure Go and keeps their schemas by fingerprint.
+// Records are encoded by the caller with the Append functions of the binary encoding.
+package avro
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"maps"
+	"math"
+	"slices"
+)
+
+const (
+	magic = "Obj\x01"
+	// SchemaFingerprintKey is the file metadata key holding the registry fingerprint of the schema
+	SchemaFingerprintKey = "usage-telemetry-publisher.schema.fingerprint"
+	// maxBlockRecords is the number of records of a data block
+	maxBlockRecords = 1000
+)
+
+// AppendLong appends a zigzag encoded int or long
+func AppendLong(buf []byte, value int64) []byte {
+	return binary.AppendVarint(buf, value)
+}
+
+// AppendString appends a string or bytes value, prefixed with its length
+func AppendString(buf []byte, value string) []byte {
+	buf = AppendLong(buf, int64(len(value)))
+	return append(buf, value...)
+}
+
+// AppendBoolean appends a boolean as a single byte
+func AppendBoolean(buf []byte, value bool) []byte {
+	if value {
+		return append(buf, 1)
+	}
+	return append(buf, 0)
+}
+
+// AppendDouble appends a double in little endian IEEE 754 format
+func AppendDouble(buf []byte, value float64) []byte {
+	return binary.LittleEndian.AppendUint64(buf, math.Float64bits(value))
+}
+
+// WriteFile writes an uncompressed Object Container File of the encoded records to w.
+// The schema and metadata are stored in the header of the file.
+func WriteFile(w io.Writer, schema []byte, metadata map[string]string, records [][]byte) error {
+	var sync [16]byte
+	if _, err := rand.Read(sync[:]); err != nil {
+		return err
+	}
+
+	header := []byte(magic)
+	header = AppendLong(header, int64(len(metadata)+2))
+	header = AppendString(header, "avro.schema")
+	header = AppendString(header, string(schema))
+	header = AppendString(header, "avro.codec")
+	header = AppendString(header, "null")
+	for _, key := range slices.Sorted(maps.Keys(metadata)) {
+		header = AppendString(header, key)
+		header = AppendString(header, metadata[key])
+	}
+	header = AppendLong(header, 0)
+	header = append(header, sync[:]...)
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	for block := range slices.Chunk(records, maxBlockRecords) {
+		size := 0
+		for _, record := range block {
+			size += len(record)
+		}
+		buf := AppendLong(nil, int64(len(block)))
+		buf = AppendLong(buf, int64(size))
+		for _, record := range block {
+			buf = append(buf, record...)
+		}
+		buf = append(buf, sync[:]...)
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package avro
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// reader decodes the binary encoding
+type reader struct {
+	buf []byte
+	pos int
+}
+
+func (r *reader) long() int64 {
+	v, n := binary.Varint(r.buf[r.pos:])
+	r.pos += n
+	return v
+}
+
+func (r *reader) string() string {
+	n := int(r.long())
+	r.pos += n
+	return string(r.buf[r.pos-n : r.pos])
+}
+
+func (r *reader) fixed(n int) []byte {
+	r.pos += n
+	return r.buf[r.pos-n : r.pos]
+}
+
+func TestAppend(t *testing.T) {
+	var buf []byte
+	buf = AppendLong(buf, -64)
+	buf = AppendString(buf, "foo")
+	buf = AppendBoolean(buf, true)
+	buf = AppendDouble(buf, 1.5)
+	assert.Equal(t, []byte{0x7f, 0x06, 'f', 'o', 'o', 0x01}, buf[:6])
+	assert.Equal(t, 1.5, math.Float64frombits(binary.LittleEndian.Uint64(buf[6:])))
+}
+
+func TestWriteFile(t *testing.T) {
+	schema := `{"type":"string"}`
+	records := make([][]byte, maxBlockRecords+1)
+	for i := range records {
+		records[i] = AppendString(nil, "record")
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteFile(&buf, []byte(schema), map[string]string{SchemaFingerprintKey: "fingerprint"}, records))
+
+	r := &reader{buf: buf.Bytes()}
+	require.Equal(t, magic, string(r.fixed(4)))
+	metadata := map[string]string{}
+	for n := r.long(); n != 0; n = r.long() {
+		for range n {
+			metadata[r.string()] = r.string()
+		}
+	}
+	assert.Equal(t, map[string]string{"avro.schema": schema, "avro.codec": "null", SchemaFingerprintKey: "fingerprint"}, metadata)
+	sync := r.fixed(16)
+
+	var count int
+	for r.pos < len(r.buf) {
+		n := int(r.long())
+		size := int(r.long())
+		block := &reader{buf: r.fixed(size)}
+		for range n {
+			assert.Equal(t, "record", block.string())
+		}
+		assert.Equal(t, size, block.pos)
+		assert.Equal(t, sync, r.fixed(16))
+		count += n
+	}
+	assert.Equal(t, len(records), count)
+}
@@ -0,0 +1,47 @@
+package avro
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// RegisterRoutes adds the endpoints serving the schemas of registry to router. The latest schema is the one
+// the replica answering writes its files with, files name theirs by fingerprint in their metadata.
+func RegisterRoutes(router *mux.Router, registry *Registry) {
+	router.Methods(http.MethodGet).Path("/avro/schemas/latest").Name("getLatestAvroSchema").HandlerFunc(latestHandler(registry))
+	router.Methods(http.MethodGet).Path("/avro/schemas/{fingerprint:[0-9a-f]{64}}").Name("getAvroSchema").HandlerFunc(schemaHandler(registry))
+}
+
+func latestHandler(registry *Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		schema, ok := registry.Latest()
+		if !ok {
+			writeJSON(w, http.StatusNotFound, errorResponse{Error: "no schema was registered yet"})
+			return
+		}
+		writeJSON(w, http.StatusOK, schema)
+	}
+}
+
+func schemaHandler(registry *Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		schema, ok := registry.Get(mux.Vars(r)["fingerprint"])
+		if !ok {
+			writeJSON(w, http.StatusNotFound, errorResponse{Error: "schema not found"})
+			return
+		}
+		writeJSON(w, http.StatusOK, schema)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
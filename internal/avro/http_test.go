@@ -0,0 +1,45 @@
+package avro
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoutes(t *testing.T) {
+	registry, err := OpenRegistry("")
+	require.NoError(t, err)
+	router := mux.NewRouter()
+	RegisterRoutes(router, registry)
+
+	serve := func(target string) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, httptest.NewRequest(http.MethodGet, target, nil))
+		return res
+	}
+
+	assert.Equal(t, http.StatusNotFound, serve("/avro/schemas/latest").Code)
+
+	_, err = registry.Register([]byte(`"string"`))
+	require.NoError(t, err)
+	_, err = registry.Register([]byte(`"long"`))
+	require.NoError(t, err)
+
+	res := serve("/avro/schemas/latest")
+	require.Equal(t, http.StatusOK, res.Code)
+	var latest Schema
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &latest))
+	assert.Equal(t, Schema{Fingerprint: fingerprintOf([]byte(`"long"`)), Schema: json.RawMessage(`"long"`)}, latest)
+
+	res = serve("/avro/schemas/" + fingerprintOf([]byte(`"string"`)))
+	require.Equal(t, http.StatusOK, res.Code)
+	assert.JSONEq(t, `{"fingerprint":"`+fingerprintOf([]byte(`"string"`))+`","schema":"string"}`, res.Body.String())
+
+	assert.Equal(t, http.StatusNotFound, serve("/avro/schemas/"+fingerprintOf([]byte(`"double"`))).Code)
+	assert.Equal(t, http.StatusNotFound, serve("/avro/schemas/1").Code)
+}
@@ -0,0 +1,192 @@
+package avro
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// schemaExtension is the extension of the files of the schemas in the registry directory
+const schemaExtension = ".json"
+
+// fingerprintPattern matches the fingerprints of schemas
+var fingerprintPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// Schema is a registered schema
+type Schema struct {
+	// Fingerprint is the hex encoded SHA-256 of the compacted JSON of the schema
+	Fingerprint string          `json:"fingerprint"`
+	Schema      json.RawMessage `json:"schema"`
+}
+
+// Registry keeps schemas by their fingerprint, in a directory with a file per schema named by its fingerprint.
+// As the fingerprint only depends on the schema, replicas sharing the directory agree on the schemas of each other's
+// files, and schemas registered by other replicas are read from the directory when they are looked up.
+// Callers only register schemas that readers of the schemas they registered before can be resolved against.
+type Registry struct {
+	dir string
+
+	mu      sync.RWMutex
+	schemas map[string]Schema
+	latest  *Schema
+}
+
+// OpenRegistry creates a Registry persisted to dir, restoring the schemas of previous runs.
+// An empty dir keeps the schemas in memory only.
+func OpenRegistry(dir string) (*Registry, error) {
+	r := &Registry{dir: dir, schemas: map[string]Schema{}}
+	if dir == "" {
+		return r, nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema registry: %w", err)
+	}
+	for _, entry := range entries {
+		fingerprint, ok := strings.CutSuffix(entry.Name(), schemaExtension)
+		if !ok || !fingerprintPattern.MatchString(fingerprint) {
+			continue // temporary files of writes that did not complete
+		}
+		schema, err := r.read(fingerprint)
+		if err != nil {
+			return nil, err
+		}
+		r.schemas[fingerprint] = schema
+	}
+	return r, nil
+}
+
+// Register persists the schema unless it is registered already, and makes it the latest schema
+func (r *Registry) Register(schema []byte) (Schema, error) {
+	compacted := &bytes.Buffer{}
+	if err := json.Compact(compacted, schema); err != nil {
+		return Schema{}, fmt.Errorf("invalid schema: %w", err)
+	}
+	registered := Schema{Fingerprint: fingerprintOf(compacted.Bytes()), Schema: compacted.Bytes()}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.schemas[registered.Fingerprint]; !ok {
+		if err := r.persist(registered); err != nil {
+			return Schema{}, err
+		}
+		r.schemas[registered.Fingerprint] = registered
+	}
+	r.latest = &registered
+	return registered, nil
+}
+
+// Latest returns the schema registered last by this process, false if no schema was registered yet
+func (r *Registry) Latest() (Schema, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.latest == nil {
+		return Schema{}, false
+	}
+	return *r.latest, true
+}
+
+// Schemas returns the schemas of the registry ordered by fingerprint
+func (r *Registry) Schemas() []Schema {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	schemas := make([]Schema, 0, len(r.schemas))
+	for _, fingerprint := range slices.Sorted(maps.Keys(r.schemas)) {
+		schemas = append(schemas, r.schemas[fingerprint])
+	}
+	return schemas
+}
+
+// Get returns the schema with a fingerprint, false if it does not exist
+func (r *Registry) Get(fingerprint string) (Schema, bool) {
+	r.mu.RLock()
+	schema, ok := r.schemas[fingerprint]
+	r.mu.RUnlock()
+	if ok || r.dir == "" || !fingerprintPattern.MatchString(fingerprint) {
+		return schema, ok
+	}
+
+	// the schema may have been registered by another replica sharing the directory
+	schema, err := r.read(fingerprint)
+	if err != nil {
+		return Schema{}, false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.schemas[fingerprint] = schema
+	return schema, true
+}
+
+// read reads the schema with a fingerprint from the registry directory
+func (r *Registry) read(fingerprint string) (Schema, error) {
+	data, err := os.ReadFile(filepath.Join(r.dir, fingerprint+schemaExtension))
+	if err != nil {
+		return Schema{}, fmt.Errorf("failed to read schema %s: %w", fingerprint, err)
+	}
+	schema := Schema{Fingerprint: fingerprint, Schema: data}
+	if schema.Fingerprint != fingerprintOf(data) {
+		return Schema{}, fmt.Errorf("schema %s does not match its fingerprint", fingerprint)
+	}
+	return schema, nil
+}
+
+// persist atomically writes a schema to its file in the registry directory, it does nothing without persistence
+func (r *Registry) persist(schema Schema) error {
+	if r.dir == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(r.dir, 0o750); err != nil {
+		return fmt.Errorf("failed to create schema registry directory: %w", err)
+	}
+	// other replicas sharing the directory may write the same schema at the same time, so the temporary file is unique
+	temp, err := os.CreateTemp(r.dir, "."+schema.Fingerprint+"-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create schema file: %w", err)
+	}
+	_, err = temp.Write(schema.Schema)
+	if err == nil {
+		err = temp.Sync()
+	}
+	if err = errors.Join(err, temp.Close()); err != nil {
+		_ = os.Remove(temp.Name())
+		return fmt.Errorf("failed to write schema file: %w", err)
+	}
+	if err := os.Rename(temp.Name(), filepath.Join(r.dir, schema.Fingerprint+schemaExtension)); err != nil {
+		_ = os.Remove(temp.Name())
+		return fmt.Errorf("failed to replace schema file: %w", err)
+	}
+	if err := syncDir(r.dir); err != nil {
+		return fmt.Errorf("failed to sync schema registry directory: %w", err)
+	}
+	return nil
+}
+
+// fingerprintOf returns the fingerprint of a compacted schema
+func fingerprintOf(schema []byte) string {
+	sum := sha256.Sum256(schema)
+	return hex.EncodeToString(sum[:])
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close() //revive:disable:unhandled-error
+	return d.Sync()
+}
@@ -0,0 +1,100 @@
+package avro
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "avro-schemas")
+	registry, err := OpenRegistry(dir)
+	require.NoError(t, err)
+	_, ok := registry.Latest()
+	assert.False(t, ok)
+
+	first, err := registry.Register([]byte(`{"type": "string"}`))
+	require.NoError(t, err)
+	assert.Equal(t, "00404e686415370f1711c4d7acfa2905444d3cf23cef2e10c47d445ebe690f96", first.Fingerprint)
+	assert.JSONEq(t, `{"type":"string"}`, string(first.Schema))
+
+	same, err := registry.Register([]byte(`{"type":"string"}`))
+	require.NoError(t, err)
+	assert.Equal(t, first, same)
+
+	second, err := registry.Register([]byte(`["null","string"]`))
+	require.NoError(t, err)
+	assert.NotEqual(t, first.Fingerprint, second.Fingerprint)
+	latest, ok := registry.Latest()
+	require.True(t, ok)
+	assert.Equal(t, second, latest)
+
+	_, err = registry.Register([]byte(`{`))
+	require.Error(t, err)
+
+	reopened, err := OpenRegistry(dir)
+	require.NoError(t, err)
+	_, ok = reopened.Latest()
+	assert.False(t, ok, "the latest schema is the one registered by the process")
+	assert.ElementsMatch(t, []Schema{first, second}, reopened.Schemas())
+	schema, ok := reopened.Get(first.Fingerprint)
+	require.True(t, ok)
+	assert.Equal(t, first, schema)
+	_, ok = reopened.Get(fingerprintOf([]byte(`"long"`)))
+	assert.False(t, ok)
+	_, ok = reopened.Get("../avro-schemas/" + first.Fingerprint)
+	assert.False(t, ok)
+}
+
+func TestRegistry_SharedDirectory(t *testing.T) {
+	dir := t.TempDir()
+	replica, err := OpenRegistry(dir)
+	require.NoError(t, err)
+	other, err := OpenRegistry(dir)
+	require.NoError(t, err)
+
+	// replicas agree on the fingerprint of a schema, and serve the schemas registered by each other
+	registered, err := replica.Register([]byte(`"string"`))
+	require.NoError(t, err)
+	same, err := other.Register([]byte(`"string"`))
+	require.NoError(t, err)
+	assert.Equal(t, registered, same)
+
+	registered, err = replica.Register([]byte(`"long"`))
+	require.NoError(t, err)
+	schema, ok := other.Get(registered.Fingerprint)
+	require.True(t, ok)
+	assert.Equal(t, registered, schema)
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2, "no temporary files are left")
+}
+
+func TestOpenRegistry_Invalid(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, fingerprintOf([]byte(`"string"`))+".json"), []byte(`"long"`), 0o600))
+	_, err := OpenRegistry(dir)
+	require.Error(t, err)
+}
+
+func TestOpenRegistry_SkipsTemporaryFiles(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "."+fingerprintOf([]byte(`"string"`))+"-1.tmp"), []byte(`"str`), 0o600))
+	registry, err := OpenRegistry(dir)
+	require.NoError(t, err)
+	assert.Empty(t, registry.Schemas())
+}
+
+func TestRegistry_InMemory(t *testing.T) {
+	registry, err := OpenRegistry("")
+	require.NoError(t, err)
+	schema, err := registry.Register([]byte(`"string"`))
+	require.NoError(t, err)
+	assert.Equal(t, fingerprintOf([]byte(`"string"`)), schema.Fingerprint)
+	_, ok := registry.Get(schema.Fingerprint)
+	assert.True(t, ok)
+}
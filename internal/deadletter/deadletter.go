@@ -0,0 +1,183 @@
+package deadletter
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/qlik-trial/usage-telemetry-publisher/cmd/config"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/model"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/sink"
+)
+
+// Reason codes of dead letters
+const (
+	ReasonUnmarshalError         = "unmarshal_error"
+	ReasonMissingSpecVersion     = "missing_specversion"
+	ReasonUnsupportedSpecVersion = "unsupported_specversion"
+	ReasonMissingID              = "missing_id"
+	ReasonMissingSource          = "missing_source"
+	// ReasonInvalidSource rejects events whose source is not a URI-reference
+	ReasonInvalidSource        = "invalid_source"
+	ReasonMissingTenantID      = "missing_tenantid"
+	ReasonMissingType          = "missing_type"
+	ReasonMissingTime          = "missing_time"
+	ReasonSinkPermanentFailure = "sink_permanent_failure"
+	// ReasonUnsupportedContentType rejects events whose datacontenttype is neither JSON nor plain text
+	ReasonUnsupportedContentType = "unsupported_content_type"
+	// ReasonInvalidData rejects events whose data does not match their datacontenttype, or that set both data and data_base64
+	ReasonInvalidData = "invalid_data"
+	// ReasonSchemaViolation rejects events not conforming to the schemas of their type, the detail lists the violations
+	ReasonSchemaViolation = "schema_violation"
+	// ReasonEncodingError rejects events the sink could not encode, such as events whose data holds NaN
+	ReasonEncodingError = "encoding_error"
+)
+
+// Store types selectable with config.Spec.DeadLetterStoreType
+const (
+	// StoreTypeLog only logs the metadata of rejected events
+	StoreTypeLog = "log"
+	// StoreTypeFile keeps rejected events in a local directory, from which they can be listed and re-driven
+	StoreTypeFile = "file"
+	// StoreTypeTopic publishes rejected events to a messaging topic acting as dead letter queue
+	StoreTypeTopic = "topic"
+)
+
+// maxPayloadBytes caps the raw payload kept for messages that could not be parsed, and therefore not scrubbed
+const maxPayloadBytes = 256
+
+var (
+	// ErrNotFound is returned for dead letters that do not exist
+	ErrNotFound = errors.New("dead letter not found")
+	// ErrUnsupported is returned by stores that cannot list or remove dead letters
+	ErrUnsupported = errors.New("operation not supported by dead letter store")
+	// ErrNotRedrivable is returned when re-driving a dead letter that holds no parsed event
+	ErrNotRedrivable = errors.New("dead letter has no event to re-drive")
+)
+
+// Letter is a rejected message. It holds either the scrubbed event, or for messages that could not be
+// parsed, a truncated prefix of the raw payload.
+type Letter struct {
+	ID        string               `json:"id"`
+	Reason    string               `json:"reason"`
+	Detail    string               `json:"detail,omitempty"`
+	Time      time.Time            `json:"time"`
+	Event     *model.ScrubbedEvent `json:"event,omitempty"`
+	Payload   string               `json:"payload,omitempty"`
+	Truncated bool                 `json:"truncated,omitempty"`
+}
+
+// Store persists dead letters
+type Store interface {
+	Put(ctx context.Context, letter *Letter) error
+	// List returns up to limit dead letters, oldest first
+	List(ctx context.Context, limit int) ([]*Letter, error)
+	Get(ctx context.Context, id string) (*Letter, error)
+	Delete(ctx context.Context, id string) error
+}
+
+// NewStore creates the store selected by cfg.DeadLetterStoreType. publisher is only used by the topic store.
+func NewStore(cfg *config.Spec, publisher Publisher) (Store, error) {
+	switch cfg.DeadLetterStoreType {
+	case StoreTypeLog, "":
+		return LogStore{}, nil
+	case StoreTypeFile:
+		return NewFileStore(cfg.DeadLetterDirectory)
+	case StoreTypeTopic:
+		if publisher == nil {
+			return nil, errors.New("topic dead letter store requires messaging to be enabled")
+		}
+		return NewTopicStore(publisher, cfg.DeadLetterTopic), nil
+	default:
+		return nil, fmt.Errorf("unknown dead letter store type %q", cfg.DeadLetterStoreType)
+	}
+}
+
+// Queue records rejected messages in a Store and re-drives them to the event output
+type Queue struct {
+	store  Store
+	output sink.Sink
+}
+
+// NewQueue creates a Queue storing dead letters in store and re-driving them to output
+func NewQueue(store Store, output sink.Sink) *Queue {
+	return &Queue{store: store, output: output}
+}
+
+// Reject dead-letters a scrubbed event
+func (q *Queue) Reject(ctx context.Context, reason string, cause error, event *model.ScrubbedEvent) error {
+	letter := newLetter(reason, cause)
+	letter.Event = event
+	return q.put(ctx, letter)
+}
+
+// RejectPayload dead-letters a message that could not be parsed, keeping only a prefix of its payload
+func (q *Queue) RejectPayload(ctx context.Context, reason string, cause error, payload []byte) error {
+	letter := newLetter(reason, cause)
+	letter.Payload, letter.Truncated = truncate(payload)
+	return q.put(ctx, letter)
+}
+
+// List returns up to limit dead letters, oldest first
+func (q *Queue) List(ctx context.Context, limit int) ([]*Letter, error) {
+	return q.store.List(ctx, limit)
+}
+
+// Redrive writes the event of the dead letter to the event output and removes it from the store
+func (q *Queue) Redrive(ctx context.Context, id string) error {
+	letter, err := q.store.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if letter.Event == nil {
+		return ErrNotRedrivable
+	}
+	if err := q.output.Write(ctx, []*model.ScrubbedEvent{letter.Event}); err != nil {
+		return fmt.Errorf("failed to re-drive dead letter: %w", err)
+	}
+	redrivenCounter.WithLabelValues(letter.Reason).Inc()
+	return q.store.Delete(ctx, id)
+}
+
+func (q *Queue) put(ctx context.Context, letter *Letter) error {
+	deadLettersCounter.WithLabelValues(letter.Reason).Inc()
+	if err := q.store.Put(ctx, letter); err != nil {
+		return fmt.Errorf("failed to store dead letter: %w", err)
+	}
+	return nil
+}
+
+func newLetter(reason string, cause error) *Letter {
+	letter := &Letter{
+		ID:     newID(),
+		Reason: reason,
+		Time:   time.Now().UTC(),
+	}
+	if cause != nil {
+		letter.Detail = cause.Error()
+	}
+	return letter
+}
+
+// newID returns a random id prefixed by the current time, so ids sort in the order letters were created
+func newID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b) // never returns an error
+	return fmt.Sprintf("%d-%s", time.Now().UnixNano(), hex.EncodeToString(b))
+}
+
+func truncate(payload []byte) (string, bool) {
+	if len(payload) <= maxPayloadBytes {
+		return string(payload), false
+	}
+	prefix := payload[:maxPayloadBytes]
+	// do not cut a multi-byte character in half
+	for len(prefix) > 0 && !utf8.Valid(prefix) {
+		prefix = prefix[:len(prefix)-1]
+	}
+	return string(prefix), true
+}
@@ -0,0 +1,167 @@
+package deadletter
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/qlik-trial/usage-telemetry-publisher/cmd/config"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSink struct {
+	events []*model.ScrubbedEvent
+	err    error
+}
+
+func (s *fakeSink) Write(_ context.Context, events []*model.ScrubbedEvent) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.events = append(s.events, events...)
+	return nil
+}
+
+func (s *fakeSink) Flush(_ context.Context) error { return nil }
+
+func (s *fakeSink) Close() error { return nil }
+
+func (s *fakeSink) Healthy() error { return nil }
+
+func newTestQueue(t *testing.T, output *fakeSink) (*Queue, *FileStore) {
+	t.Helper()
+	store, err := NewFileStore(t.TempDir())
+	require.NoError(t, err)
+	return NewQueue(store, output), store
+}
+
+func TestQueue_Reject(t *testing.T) {
+	queue, store := newTestQueue(t, &fakeSink{})
+	event := &model.ScrubbedEvent{Id: "event-1", Type: "com.qlik.v1.analytics.sheet.viewed", TenantId: "tenant_id"}
+
+	require.NoError(t, queue.Reject(context.Background(), ReasonSinkPermanentFailure, errors.New("bad request"), event))
+
+	letters, err := store.List(context.Background(), 0)
+	require.NoError(t, err)
+	require.Len(t, letters, 1)
+	assert.NotEmpty(t, letters[0].ID)
+	assert.Equal(t, ReasonSinkPermanentFailure, letters[0].Reason)
+	assert.Equal(t, "bad request", letters[0].Detail)
+	assert.Equal(t, event, letters[0].Event)
+	assert.Empty(t, letters[0].Payload)
+}
+
+func TestQueue_RejectPayload(t *testing.T) {
+	tests := []struct {
+		name      string
+		payload   string
+		expected  string
+		truncated bool
+	}{
+		{"short payload is kept", `{"id":`, `{"id":`, false},
+		{"long payload is truncated", strings.Repeat("a", maxPayloadBytes+10), strings.Repeat("a", maxPayloadBytes), true},
+		{"multi-byte characters are not split", strings.Repeat("a", maxPayloadBytes-1) + "é", strings.Repeat("a", maxPayloadBytes-1), true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			queue, store := newTestQueue(t, &fakeSink{})
+
+			require.NoError(t, queue.RejectPayload(context.Background(), ReasonUnmarshalError, errors.New("unexpected end of JSON input"), []byte(test.payload)))
+
+			letters, err := store.List(context.Background(), 0)
+			require.NoError(t, err)
+			require.Len(t, letters, 1)
+			assert.Equal(t, ReasonUnmarshalError, letters[0].Reason)
+			assert.Equal(t, test.expected, letters[0].Payload)
+			assert.Equal(t, test.truncated, letters[0].Truncated)
+			assert.True(t, utf8.ValidString(letters[0].Payload))
+			assert.Nil(t, letters[0].Event)
+		})
+	}
+}
+
+func TestQueue_Redrive(t *testing.T) {
+	t.Run("writes event to output and removes letter", func(t *testing.T) {
+		output := &fakeSink{}
+		queue, store := newTestQueue(t, output)
+		require.NoError(t, queue.Reject(context.Background(), ReasonSchemaViolation, nil, &model.ScrubbedEvent{Id: "event-1"}))
+		letters, err := store.List(context.Background(), 0)
+		require.NoError(t, err)
+
+		require.NoError(t, queue.Redrive(context.Background(), letters[0].ID))
+
+		require.Len(t, output.events, 1)
+		assert.Equal(t, "event-1", output.events[0].Id)
+		_, err = store.Get(context.Background(), letters[0].ID)
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("keeps letter when output fails", func(t *testing.T) {
+		queue, store := newTestQueue(t, &fakeSink{err: errors.New("sink unavailable")})
+		require.NoError(t, queue.Reject(context.Background(), ReasonSchemaViolation, nil, &model.ScrubbedEvent{Id: "event-1"}))
+		letters, err := store.List(context.Background(), 0)
+		require.NoError(t, err)
+
+		require.Error(t, queue.Redrive(context.Background(), letters[0].ID))
+
+		_, err = store.Get(context.Background(), letters[0].ID)
+		assert.NoError(t, err)
+	})
+
+	t.Run("rejects letters without event", func(t *testing.T) {
+		queue, store := newTestQueue(t, &fakeSink{})
+		require.NoError(t, queue.RejectPayload(context.Background(), ReasonUnmarshalError, nil, []byte("{")))
+		letters, err := store.List(context.Background(), 0)
+		require.NoError(t, err)
+
+		assert.ErrorIs(t, queue.Redrive(context.Background(), letters[0].ID), ErrNotRedrivable)
+	})
+
+	t.Run("returns not found for unknown letters", func(t *testing.T) {
+		queue, _ := newTestQueue(t, &fakeSink{})
+		assert.ErrorIs(t, queue.Redrive(context.Background(), "unknown"), ErrNotFound)
+	})
+}
+
+type fakePublisher struct {
+	subject string
+	data    []byte
+}
+
+func (p *fakePublisher) Publish(_ context.Context, subject string, data []byte) error {
+	p.subject, p.data = subject, data
+	return nil
+}
+
+func TestNewStore(t *testing.T) {
+	cfg := &config.Spec{DeadLetterStoreType: StoreTypeLog}
+	store, err := NewStore(cfg, nil)
+	require.NoError(t, err)
+	assert.IsType(t, LogStore{}, store)
+
+	cfg = &config.Spec{DeadLetterStoreType: StoreTypeFile, DeadLetterDirectory: t.TempDir()}
+	store, err = NewStore(cfg, nil)
+	require.NoError(t, err)
+	assert.IsType(t, &FileStore{}, store)
+
+	cfg = &config.Spec{DeadLetterStoreType: StoreTypeTopic, DeadLetterTopic: "dead-letters"}
+	_, err = NewStore(cfg, nil)
+	require.Error(t, err)
+
+	publisher := &fakePublisher{}
+	store, err = NewStore(cfg, publisher)
+	require.NoError(t, err)
+	require.NoError(t, store.Put(context.Background(), &Letter{ID: "1", Reason: ReasonMissingTime}))
+	assert.Equal(t, "dead-letters", publisher.subject)
+	assert.Contains(t, string(publisher.data), ReasonMissingTime)
+	_, err = store.List(context.Background(), 10)
+	assert.ErrorIs(t, err, ErrUnsupported)
+
+	_, err = NewStore(&config.Spec{DeadLetterStoreType: "unknown"}, nil)
+	require.Error(t, err)
+}
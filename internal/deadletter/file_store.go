@@ -0,0 +1,115 @@
+package deadletter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const letterExtension = ".json"
+
+// FileStore keeps every dead letter as a JSON file in a local directory
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore creates a FileStore in dir
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create dead letter directory: %w", err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// Put writes the letter to a temp file and renames it, so partially written letters are never listed
+func (s *FileStore) Put(_ context.Context, letter *Letter) error {
+	data, err := json.Marshal(letter)
+	if err != nil {
+		return fmt.Errorf("failed to encode dead letter: %w", err)
+	}
+	tempPath := filepath.Join(s.dir, "."+letter.ID+letterExtension)
+	if err := os.WriteFile(tempPath, data, 0o640); err != nil {
+		return fmt.Errorf("failed to write dead letter: %w", err)
+	}
+	return os.Rename(tempPath, s.path(letter.ID))
+}
+
+// List implements Store
+func (s *FileStore) List(ctx context.Context, limit int) ([]*Letter, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dead letter directory: %w", err)
+	}
+
+	var ids []string
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), letterExtension)
+		if ok && !entry.IsDir() && !strings.HasPrefix(id, ".") {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	if limit > 0 && len(ids) > limit {
+		ids = ids[:limit]
+	}
+
+	letters := make([]*Letter, 0, len(ids))
+	for _, id := range ids {
+		letter, err := s.Get(ctx, id)
+		if errors.Is(err, ErrNotFound) {
+			// re-driven since the directory was read
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		letters = append(letters, letter)
+	}
+	return letters, nil
+}
+
+// Get implements Store
+func (s *FileStore) Get(_ context.Context, id string) (*Letter, error) {
+	if !validID(id) {
+		return nil, ErrNotFound
+	}
+	data, err := os.ReadFile(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dead letter: %w", err)
+	}
+
+	var letter Letter
+	if err := json.Unmarshal(data, &letter); err != nil {
+		return nil, fmt.Errorf("failed to decode dead letter %s: %w", id, err)
+	}
+	return &letter, nil
+}
+
+// Delete implements Store
+func (s *FileStore) Delete(_ context.Context, id string) error {
+	if !validID(id) {
+		return ErrNotFound
+	}
+	err := os.Remove(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *FileStore) path(id string) string {
+	return filepath.Join(s.dir, id+letterExtension)
+}
+
+// validID rejects ids that could escape the dead letter directory
+func validID(id string) bool {
+	return id != "" && !strings.HasPrefix(id, ".") && !strings.ContainsAny(id, `/\`)
+}
@@ -0,0 +1,50 @@
+package deadletter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStore_ListOrderAndLimit(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	require.NoError(t, err)
+	for _, id := range []string{"3", "1", "2"} {
+		require.NoError(t, store.Put(context.Background(), &Letter{ID: id, Reason: ReasonMissingType}))
+	}
+
+	letters, err := store.List(context.Background(), 2)
+	require.NoError(t, err)
+	require.Len(t, letters, 2)
+	assert.Equal(t, "1", letters[0].ID)
+	assert.Equal(t, "2", letters[1].ID)
+}
+
+func TestFileStore_IgnoresTempFiles(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".1.json"), []byte("{"), 0o640))
+
+	letters, err := store.List(context.Background(), 0)
+	require.NoError(t, err)
+	assert.Empty(t, letters)
+}
+
+func TestFileStore_RejectsPathTraversal(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(filepath.Join(dir, "letters"))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "secret.json"), []byte("{}"), 0o640))
+
+	for _, id := range []string{"../secret", "", ".hidden", `..\secret`} {
+		_, err := store.Get(context.Background(), id)
+		assert.ErrorIs(t, err, ErrNotFound, id)
+		assert.ErrorIs(t, store.Delete(context.Background(), id), ErrNotFound, id)
+	}
+	assert.FileExists(t, filepath.Join(dir, "secret.json"))
+}
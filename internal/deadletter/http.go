@@ -0,0 +1,87 @@
+package deadletter
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/qlik-trial/go-service-kit/v29/operation"
+)
+
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+type listResponse struct {
+	Data []*Letter `json:"data"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// RegisterRoutes adds the endpoints listing and re-driving dead letters to router behind middleware,
+// which must authorize callers since dead letters hold tenant event payloads
+func RegisterRoutes(router *mux.Router, queue *Queue, middleware mux.MiddlewareFunc) {
+	router.Methods(http.MethodGet).Path("/dead-letters").Name("listDeadLetters").Handler(middleware(listHandler(queue)))
+	router.Methods(http.MethodPost).Path("/dead-letters/{id}/actions/redrive").Name("redriveDeadLetter").Handler(middleware(redriveHandler(queue)))
+}
+
+func listHandler(queue *Queue) http.HandlerFunc {
+	label := "deadletter/listHandler"
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := defaultListLimit
+		if value := r.URL.Query().Get("limit"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed <= 0 {
+				writeJSON(w, http.StatusBadRequest, errorResponse{Error: "limit must be a positive integer"})
+				return
+			}
+			limit = min(parsed, maxListLimit)
+		}
+
+		letters, err := queue.List(r.Context(), limit)
+		if err != nil {
+			operation.Logger(r.Context()).Error("label", label, "message", "failed to list dead letters", "error", err)
+			writeJSON(w, statusFor(err), errorResponse{Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, listResponse{Data: letters})
+	}
+}
+
+func redriveHandler(queue *Queue) http.HandlerFunc {
+	label := "deadletter/redriveHandler"
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		if err := queue.Redrive(r.Context(), id); err != nil {
+			operation.Logger(r.Context()).Warn("label", label, "message", "failed to re-drive dead letter", "error", err, "id", id)
+			writeJSON(w, statusFor(err), errorResponse{Error: err.Error()})
+			return
+		}
+		operation.Logger(r.Context()).Info("label", label, "message", "dead letter re-driven", "id", id)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func statusFor(err error) int {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrUnsupported):
+		return http.StatusNotImplemented
+	case errors.Is(err, ErrNotRedrivable):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,66 @@
+package deadletter
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoutes(t *testing.T) {
+	output := &fakeSink{}
+	queue, store := newTestQueue(t, output)
+	require.NoError(t, queue.Reject(context.Background(), ReasonSchemaViolation, nil, &model.ScrubbedEvent{Id: "event-1"}))
+	require.NoError(t, queue.RejectPayload(context.Background(), ReasonUnmarshalError, nil, []byte("{")))
+	authorized := true
+	middleware := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !authorized {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	router := mux.NewRouter()
+	RegisterRoutes(router, queue, middleware)
+
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, httptest.NewRequest(method, target, nil))
+		return res
+	}
+
+	res := serve(http.MethodGet, "/dead-letters?limit=10")
+	require.Equal(t, http.StatusOK, res.Code)
+	var list listResponse
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &list))
+	require.Len(t, list.Data, 2)
+
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodGet, "/dead-letters?limit=-1").Code)
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodPost, "/dead-letters/unknown/actions/redrive").Code)
+
+	for _, letter := range list.Data {
+		res := serve(http.MethodPost, "/dead-letters/"+letter.ID+"/actions/redrive")
+		if letter.Event != nil {
+			assert.Equal(t, http.StatusNoContent, res.Code)
+		} else {
+			assert.Equal(t, http.StatusConflict, res.Code)
+		}
+	}
+	require.Len(t, output.events, 1)
+
+	letters, err := store.List(context.Background(), 0)
+	require.NoError(t, err)
+	assert.Len(t, letters, 1)
+
+	authorized = false
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodGet, "/dead-letters").Code)
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodPost, "/dead-letters/"+letters[0].ID+"/actions/redrive").Code)
+}
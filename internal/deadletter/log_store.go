@@ -0,0 +1,36 @@
+package deadletter
+
+import (
+	"context"
+
+	"github.com/qlik-trial/go-service-kit/v29/operation"
+)
+
+// LogStore only logs the metadata of dead letters, never their payload
+type LogStore struct{}
+
+// Put implements Store
+func (LogStore) Put(ctx context.Context, letter *Letter) error {
+	label := "deadletter/LogStore/Put"
+	keyvals := []any{"label", label, "message", "event dead-lettered", "reason", letter.Reason, "detail", letter.Detail, "id", letter.ID}
+	if letter.Event != nil {
+		keyvals = append(keyvals, "eventId", letter.Event.Id, "eventType", letter.Event.Type, "tenantId", letter.Event.TenantId)
+	}
+	operation.Logger(ctx).Info(keyvals...)
+	return nil
+}
+
+// List is not supported
+func (LogStore) List(_ context.Context, _ int) ([]*Letter, error) {
+	return nil, ErrUnsupported
+}
+
+// Get is not supported
+func (LogStore) Get(_ context.Context, _ string) (*Letter, error) {
+	return nil, ErrUnsupported
+}
+
+// Delete is not supported
+func (LogStore) Delete(_ context.Context, _ string) error {
+	return ErrUnsupported
+}
@@ -0,0 +1,22 @@
+package deadletter
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// deadLettersCounter counts dead-lettered messages by reason
+	deadLettersCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "usage_telemetry_publisher",
+		Name:      "dead_letters_total",
+		Help:      "Number of messages dead-lettered, by reason",
+	}, []string{"reason"})
+
+	// redrivenCounter counts dead letters re-driven to the event output by reason
+	redrivenCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "usage_telemetry_publisher",
+		Name:      "dead_letters_redriven_total",
+		Help:      "Number of dead letters re-driven to the event output, by reason",
+	}, []string{"reason"})
+)
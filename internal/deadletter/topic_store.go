@@ -0,0 +1,48 @@
+package deadletter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// Publisher publishes messages to a messaging topic
+type Publisher interface {
+	Publish(ctx context.Context, subject string, data []byte) error
+}
+
+// TopicStore publishes dead letters to a messaging topic acting as dead letter queue.
+// Listing and re-driving happens through the queue's consumers, so only Put is supported.
+type TopicStore struct {
+	publisher Publisher
+	topic     string
+}
+
+// NewTopicStore creates a TopicStore publishing to topic
+func NewTopicStore(publisher Publisher, topic string) *TopicStore {
+	return &TopicStore{publisher: publisher, topic: topic}
+}
+
+// Put implements Store
+func (s *TopicStore) Put(ctx context.Context, letter *Letter) error {
+	data, err := json.Marshal(letter)
+	if err != nil {
+		return fmt.Errorf("failed to encode dead letter: %w", err)
+	}
+	return s.publisher.Publish(ctx, s.topic, data)
+}
+
+// List is not supported
+func (s *TopicStore) List(_ context.Context, _ int) ([]*Letter, error) {
+	return nil, ErrUnsupported
+}
+
+// Get is not supported
+func (s *TopicStore) Get(_ context.Context, _ string) (*Letter, error) {
+	return nil, ErrUnsupported
+}
+
+// Delete is not supported
+func (s *TopicStore) Delete(_ context.Context, _ string) error {
+	return ErrUnsupported
+}
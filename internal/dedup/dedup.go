@@ -0,0 +1,208 @@
+package dedup
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/qlik-trial/go-service-kit/v29/operation"
+)
+
+// SnapshotFile is the name of the snapshot written to the intermediate storage directory when persistence is enabled
+const SnapshotFile = "dedup.snapshot"
+
+// Options configures a Filter
+type Options struct {
+	// TTL is how long an event id is remembered
+	TTL time.Duration
+	// MaxEntries bounds the number of remembered ids, the oldest ones are evicted first
+	MaxEntries int
+	// SnapshotPath persists the remembered ids across restarts, empty disables persistence
+	SnapshotPath string
+	// SnapshotInterval is how often the snapshot is written while running
+	SnapshotInterval time.Duration
+}
+
+// Filter detects events that were already published, keyed by tenant and CloudEvent id.
+// Ids are only remembered once the event was accepted by the sink, so a failed write is not mistaken for a duplicate on redelivery.
+type Filter struct {
+	opts Options
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // front is the most recently remembered entry
+	now     func() time.Time
+}
+
+type entry struct {
+	Key     string    `json:"key"`
+	Expires time.Time `json:"expires"`
+}
+
+// New creates a Filter, restoring the snapshot of a previous run if persistence is enabled
+func New(opts Options) (*Filter, error) {
+	opts.MaxEntries = max(opts.MaxEntries, 1)
+	f := &Filter{
+		opts:    opts,
+		entries: map[string]*list.Element{},
+		order:   list.New(),
+		now:     time.Now,
+	}
+	if opts.SnapshotPath != "" {
+		if err := f.restore(); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// IsDuplicate reports whether the event was published within the TTL, counting the result for eventType.
+// Events without an id cannot be deduplicated and are never duplicates.
+func (f *Filter) IsDuplicate(tenantID, id, eventType string) bool {
+	if id == "" {
+		return false
+	}
+
+	f.mu.Lock()
+	elem, ok := f.entries[key(tenantID, id)]
+	if ok && !f.now().Before(elem.Value.(*entry).Expires) {
+		f.remove(elem)
+		ok = false
+	}
+	f.mu.Unlock()
+
+	if ok {
+		eventsCounter.WithLabelValues(eventType, resultDuplicate).Inc()
+	} else {
+		eventsCounter.WithLabelValues(eventType, resultUnique).Inc()
+	}
+	return ok
+}
+
+// Remember records that the event was published
+func (f *Filter) Remember(tenantID, id string) {
+	if id == "" {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.add(key(tenantID, id), f.now().Add(f.opts.TTL))
+}
+
+// Len returns the number of remembered ids
+func (f *Filter) Len() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.order.Len()
+}
+
+// Start periodically writes the snapshot until ctx is done, then writes it a last time.
+// Without persistence it only waits for ctx.
+func (f *Filter) Start(ctx context.Context) error {
+	label := "dedup/Filter/Start"
+	if f.opts.SnapshotPath == "" || f.opts.SnapshotInterval <= 0 {
+		<-ctx.Done()
+		return f.Snapshot()
+	}
+
+	ticker := time.NewTicker(f.opts.SnapshotInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return f.Snapshot()
+		case <-ticker.C:
+			if err := f.Snapshot(); err != nil {
+				operation.Logger(ctx).Warn("label", label, "message", "failed to write dedup snapshot", "error", err)
+			}
+		}
+	}
+}
+
+// Snapshot atomically writes the unexpired ids to the snapshot file, it does nothing without persistence
+func (f *Filter) Snapshot() error {
+	if f.opts.SnapshotPath == "" {
+		return nil
+	}
+
+	f.mu.Lock()
+	now := f.now()
+	entries := make([]*entry, 0, f.order.Len())
+	// oldest first, so restoring the snapshot rebuilds the same order
+	for elem := f.order.Back(); elem != nil; elem = elem.Prev() {
+		if e := elem.Value.(*entry); now.Before(e.Expires) {
+			entries = append(entries, e)
+		}
+	}
+	data, err := json.Marshal(entries)
+	f.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode dedup snapshot: %w", err)
+	}
+
+	tempPath := filepath.Join(filepath.Dir(f.opts.SnapshotPath), "."+filepath.Base(f.opts.SnapshotPath)+".tmp")
+	if err := os.WriteFile(tempPath, data, 0o640); err != nil {
+		return fmt.Errorf("failed to write dedup snapshot: %w", err)
+	}
+	if err := os.Rename(tempPath, f.opts.SnapshotPath); err != nil {
+		return fmt.Errorf("failed to replace dedup snapshot: %w", err)
+	}
+	return nil
+}
+
+func (f *Filter) restore() error {
+	data, err := os.ReadFile(f.opts.SnapshotPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read dedup snapshot: %w", err)
+	}
+
+	var entries []*entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		// the filter only prevents duplicates, starting empty is better than not starting
+		operation.Logger(context.Background()).Warn("label", "dedup/Filter/restore", "message", "ignoring malformed dedup snapshot", "error", err)
+		return nil
+	}
+
+	now := f.now()
+	for _, e := range entries {
+		if now.Before(e.Expires) {
+			f.add(e.Key, e.Expires)
+		}
+	}
+	return nil
+}
+
+// add remembers key until expires, evicting the oldest entries beyond MaxEntries. f.mu must be held.
+func (f *Filter) add(key string, expires time.Time) {
+	if elem, ok := f.entries[key]; ok {
+		elem.Value.(*entry).Expires = expires
+		f.order.MoveToFront(elem)
+		return
+	}
+
+	f.entries[key] = f.order.PushFront(&entry{Key: key, Expires: expires})
+	for f.order.Len() > f.opts.MaxEntries {
+		f.remove(f.order.Back())
+		evictionsCounter.Inc()
+	}
+}
+
+// remove forgets the entry of elem. f.mu must be held.
+func (f *Filter) remove(elem *list.Element) {
+	f.order.Remove(elem)
+	delete(f.entries, elem.Value.(*entry).Key)
+}
+
+func key(tenantID, id string) string {
+	return tenantID + "/" + id
+}
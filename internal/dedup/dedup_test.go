@@ -0,0 +1,114 @@
+package dedup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const eventType = "com.qlik.v1.analytics.sheet.viewed"
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func newTestFilter(t *testing.T, opts Options) (*Filter, *fakeClock) {
+	t.Helper()
+	clock := &fakeClock{now: time.Date(2025, 8, 21, 10, 0, 0, 0, time.UTC)}
+	f, err := New(opts)
+	require.NoError(t, err)
+	f.now = clock.Now
+	return f, clock
+}
+
+func TestFilter_IsDuplicate(t *testing.T) {
+	f, _ := newTestFilter(t, Options{TTL: time.Minute, MaxEntries: 10})
+
+	assert.False(t, f.IsDuplicate("tenant-1", "event-1", eventType))
+	f.Remember("tenant-1", "event-1")
+
+	assert.True(t, f.IsDuplicate("tenant-1", "event-1", eventType))
+	assert.False(t, f.IsDuplicate("tenant-2", "event-1", eventType), "ids are scoped by tenant")
+	assert.False(t, f.IsDuplicate("tenant-1", "event-2", eventType))
+}
+
+func TestFilter_IgnoresEventsWithoutID(t *testing.T) {
+	f, _ := newTestFilter(t, Options{TTL: time.Minute, MaxEntries: 10})
+
+	f.Remember("tenant-1", "")
+	assert.False(t, f.IsDuplicate("tenant-1", "", eventType))
+	assert.Zero(t, f.Len())
+}
+
+func TestFilter_ExpiresAfterTTL(t *testing.T) {
+	f, clock := newTestFilter(t, Options{TTL: time.Minute, MaxEntries: 10})
+	f.Remember("tenant-1", "event-1")
+
+	clock.now = clock.now.Add(59 * time.Second)
+	assert.True(t, f.IsDuplicate("tenant-1", "event-1", eventType))
+
+	clock.now = clock.now.Add(time.Second)
+	assert.False(t, f.IsDuplicate("tenant-1", "event-1", eventType))
+	assert.Zero(t, f.Len())
+}
+
+func TestFilter_EvictsOldestBeyondMaxEntries(t *testing.T) {
+	f, _ := newTestFilter(t, Options{TTL: time.Minute, MaxEntries: 2})
+	f.Remember("tenant-1", "event-1")
+	f.Remember("tenant-1", "event-2")
+	f.Remember("tenant-1", "event-1") // refreshes event-1
+	f.Remember("tenant-1", "event-3")
+
+	assert.Equal(t, 2, f.Len())
+	assert.True(t, f.IsDuplicate("tenant-1", "event-1", eventType))
+	assert.False(t, f.IsDuplicate("tenant-1", "event-2", eventType))
+	assert.True(t, f.IsDuplicate("tenant-1", "event-3", eventType))
+}
+
+func TestFilter_Snapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), SnapshotFile)
+	f, clock := newTestFilter(t, Options{TTL: time.Minute, MaxEntries: 10, SnapshotPath: path})
+	// New restores with the real clock, so the snapshot must not be expired by then
+	clock.now = time.Now()
+	f.Remember("tenant-1", "event-1")
+	clock.now = clock.now.Add(30 * time.Second)
+	f.Remember("tenant-1", "event-2")
+	require.NoError(t, f.Snapshot())
+
+	restored, err := New(Options{TTL: time.Minute, MaxEntries: 10, SnapshotPath: path})
+	require.NoError(t, err)
+	restored.now = func() time.Time { return clock.now.Add(45 * time.Second) }
+	assert.False(t, restored.IsDuplicate("tenant-1", "event-1", eventType), "expired entries are dropped")
+	assert.True(t, restored.IsDuplicate("tenant-1", "event-2", eventType))
+}
+
+func TestFilter_IgnoresMalformedSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), SnapshotFile)
+	require.NoError(t, os.WriteFile(path, []byte("{"), 0o640))
+
+	f, err := New(Options{TTL: time.Minute, MaxEntries: 10, SnapshotPath: path})
+	require.NoError(t, err)
+	assert.Zero(t, f.Len())
+}
+
+func TestFilter_StartWritesSnapshotOnStop(t *testing.T) {
+	path := filepath.Join(t.TempDir(), SnapshotFile)
+	f, err := New(Options{TTL: time.Minute, MaxEntries: 10, SnapshotPath: path, SnapshotInterval: time.Hour})
+	require.NoError(t, err)
+	f.Remember("tenant-1", "event-1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.NoError(t, f.Start(ctx))
+
+	restored, err := New(Options{TTL: time.Minute, MaxEntries: 10, SnapshotPath: path})
+	require.NoError(t, err)
+	assert.True(t, restored.IsDuplicate("tenant-1", "event-1", eventType))
+}
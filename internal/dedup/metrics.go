@@ -0,0 +1,27 @@
+package dedup
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	resultUnique    = "unique"
+	resultDuplicate = "duplicate"
+)
+
+var (
+	// eventsCounter counts checked events by type and result, the duplicate rate of a type is its duplicate share
+	eventsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "usage_telemetry_publisher",
+		Name:      "dedup_events_total",
+		Help:      "Number of events checked for duplicates, by event type and result (unique or duplicate)",
+	}, []string{"event_type", "result"})
+
+	// evictionsCounter counts ids forgotten before their TTL because the filter was full
+	evictionsCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "usage_telemetry_publisher",
+		Name:      "dedup_evictions_total",
+		Help:      "Number of event ids evicted from the dedup filter before their TTL expired",
+	})
+)
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -14,17 +15,49 @@ import (
 	"github.com/qlik-trial/usage-telemetry-publisher/cmd/config"
 	"github.com/qlik-trial/usage-telemetry-publisher/cmd/version"
 	"github.com/qlik-trial/usage-telemetry-publisher/internal/auth"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/avro"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/deadletter"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/dedup"
 	"github.com/qlik-trial/usage-telemetry-publisher/internal/events"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/eventschema"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/eventspolicy"
 	"github.com/qlik-trial/usage-telemetry-publisher/internal/features"
 	"github.com/qlik-trial/usage-telemetry-publisher/internal/messaging"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/scrubber"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/secrets"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/sink"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/vault"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/wal"
 )
 
+// dedupSnapshotInterval is how often the dedup filter is persisted when dedup persistence is enabled
+const dedupSnapshotInterval = 30 * time.Second
+
 type (
 	// ApplicationContext is a struct that holds references to resources that are used by multiple runnables
 	ApplicationContext struct {
 		TokenGenerator  auth.TokenGenerator
 		MessagingClient messaging.EventListener
 		FeaturesClient  features.FeaturesClient
+		EventsPolicy    *eventspolicy.Watcher
+		// EventSchemas validate events against the schemas of their type
+		EventSchemas *eventschema.Rules
+		ScrubPolicy  *scrubber.Policy
+		// ScrubOverlays resolve the ScrubPolicy of tenants with an overlay
+		ScrubOverlays *scrubber.Overlays
+		// TokenVault keeps the values behind pseudonyms, it is nil when tokenization is disabled
+		TokenVault *vault.Vault
+		// SecretScanner redacts the secrets left in scrubbed events, it is nil when secret scanning is disabled
+		SecretScanner *secrets.Scanner
+		Sink          sink.Sink
+		// AvroSchemas keeps the versions of the schema of avro sink output
+		AvroSchemas *avro.Registry
+		// IntermediateStorage holds events between the event handler and the sink when intermediate storage is enabled
+		IntermediateStorage *wal.Log
+		// DeadLetters keeps the events that were rejected instead of being published
+		DeadLetters *deadletter.Queue
+		// DedupFilter drops already published events, it is nil when deduplication is disabled
+		DedupFilter *dedup.Filter
 	}
 )
 
@@ -37,15 +70,48 @@ var CreateAppContext = func(ctx context.Context, stopChan <-chan struct{}) *Appl
 		appCtx.initFeaturesClient(ctx)
 	}
 
+	appCtx.initEventsPolicy(ctx)
+	appCtx.initEventSchemas(ctx)
+	appCtx.initScrubPolicy(ctx)
+
+	if config.Global.TokenVaultEnabled {
+		appCtx.initTokenVault(ctx)
+	}
+	// overlays take the keys and vault of the scrub policy, so they are loaded once it is complete
+	appCtx.initScrubOverlays(ctx)
+
+	if config.Global.SecretScanEnabled {
+		appCtx.SecretScanner = secrets.New(secrets.Options{})
+	}
+
+	appCtx.initAvroSchemas(ctx)
+	appCtx.initSink(ctx)
+
+	if config.Global.IntermediateStorageEnabled {
+		appCtx.initIntermediateStorage(ctx)
+	}
+
+	// the topic dead letter store publishes through the messaging client, so it is created before the dead letter queue,
+	// while subscribing waits until the dead letter queue exists
 	if config.Global.MessagingEnabled {
-		appCtx.initAndSubscribeMessagingClient(ctx, stopChan)
+		appCtx.initMessagingClient(ctx, stopChan)
+	}
+
+	appCtx.initDeadLetters(ctx)
+
+	if config.Global.DedupEnabled {
+		appCtx.initDedupFilter(ctx)
+	}
+
+	if config.Global.MessagingEnabled {
+		appCtx.subscribeToChannels(ctx)
 	}
 
 	return &appCtx
 }
 
-func (appCtx *ApplicationContext) initAndSubscribeMessagingClient(ctx context.Context, stopChan <-chan struct{}) {
-	label := "application_context/initAndSubscribeMessagingClient"
+func (appCtx *ApplicationContext) initMessagingClient(ctx context.Context, stopChan <-chan struct{}) {
+	label := "application_context/initMessagingClient"
 	hostname, err := os.Hostname()
 	if err != nil {
 		panic(fmt.Errorf("failed to get hostname: %w", err))
@@ -61,8 +127,6 @@ func (appCtx *ApplicationContext) initAndSubscribeMessagingClient(ctx context.Co
 	if err != nil {
 		operation.Logger(ctx).Error("label", label, "message", "failed to connect to solace", "error", err)
 	}
-	appCtx.subscribeToChannels(ctx)
-
 }
 
 func (appCtx *ApplicationContext) subscribeToChannels(ctx context.Context) {
@@ -72,7 +136,7 @@ func (appCtx *ApplicationContext) subscribeToChannels(ctx context.Context) {
 		channel := strings.TrimSpace(pfx)
 		if err := appCtx.MessagingClient.SubscribeEvent(channel,
 			config.Global.SolaceStreamingQueueGroup,
-			events.EventHandler(ctx, appCtx.FeaturesClient)); err != nil {
+			events.EventHandler(ctx, appCtx.Pipeline())); err != nil {
 			operation.Logger(ctx).Error(
 				"label", label, "message", "error subscribing to message queue", "error", err, "channel", channel,
 			)
@@ -81,6 +145,170 @@ func (appCtx *ApplicationContext) subscribeToChannels(ctx context.Context) {
 	}
 }
 
+func (appCtx *ApplicationContext) initEventsPolicy(ctx context.Context) {
+	label := "application_context/initEventsPolicy"
+	watcher, err := eventspolicy.NewWatcher(config.Global.EventsFilePath)
+	if err != nil {
+		operation.Logger(ctx).Error("label", label, "message", "failed to load events policy", "error", err, "eventsFilePath", config.Global.EventsFilePath)
+		panic(fmt.Errorf("failed to load events policy: %w", err))
+	}
+	operation.Logger(ctx).Info("label", label, "message", "events policy loaded", "eventsFilePath", config.Global.EventsFilePath, "version", watcher.Version())
+	appCtx.EventsPolicy = watcher
+}
+
+func (appCtx *ApplicationContext) initEventSchemas(ctx context.Context) {
+	label := "application_context/initEventSchemas"
+	rules, err := eventschema.LoadOrNone(config.Global.EventSchemasFilePath)
+	if err != nil {
+		operation.Logger(ctx).Error("label", label, "message", "failed to load event schemas", "error", err, "eventSchemasFilePath", config.Global.EventSchemasFilePath)
+		panic(fmt.Errorf("failed to load event schemas: %w", err))
+	}
+	operation.Logger(ctx).Info("label", label, "message", "event schemas loaded", "eventSchemasFilePath", config.Global.EventSchemasFilePath, "eventTypes", rules.Len())
+	appCtx.EventSchemas = rules
+}
+
+func (appCtx *ApplicationContext) initScrubPolicy(ctx context.Context) {
+	label := "application_context/initScrubPolicy"
+	policy, err := scrubber.LoadOrDefault(config.Global.ScrubPolicyFilePath)
+	if err != nil {
+		operation.Logger(ctx).Error("label", label, "message", "failed to load scrub policy", "error", err, "scrubPolicyFilePath", config.Global.ScrubPolicyFilePath)
+		panic(fmt.Errorf("failed to load scrub policy: %w", err))
+	}
+
+	key := scrubber.NewKey(config.Global.PseudonymKeyID, []byte(config.Global.PseudonymKey))
+	if config.Global.PseudonymKey == "" {
+		key = scrubber.EphemeralKey()
+		operation.Logger(ctx).Warn("label", label, "message", "no pseudonym key configured, pseudonyms change with every restart", "pseudonymKeyId", key.ID)
+	}
+
+	tenantHashSecret := []byte(config.Global.TenantHashSecret)
+	if len(tenantHashSecret) == 0 {
+		tenantHashSecret = scrubber.EphemeralKey().Secret
+		operation.Logger(ctx).Warn("label", label, "message", "no tenant hash secret configured, tenant hashes change with every restart")
+	}
+	operation.Logger(ctx).Info("label", label, "message", "scrub policy loaded", "scrubPolicyFilePath", config.Global.ScrubPolicyFilePath, "version", policy.Version(), "pseudonymKeyId", key.ID)
+	appCtx.ScrubPolicy = policy.WithKey(key).WithTenantSecret(tenantHashSecret)
+}
+
+func (appCtx *ApplicationContext) initScrubOverlays(ctx context.Context) {
+	label := "application_context/initScrubOverlays"
+	overlays, err := scrubber.LoadOverlays(appCtx.ScrubPolicy, config.Global.ScrubPolicyOverlaysFilePath)
+	if err != nil {
+		operation.Logger(ctx).Error("label", label, "message", "failed to load scrub policy overlays", "error", err, "scrubPolicyOverlaysFilePath", config.Global.ScrubPolicyOverlaysFilePath)
+		panic(fmt.Errorf("failed to load scrub policy overlays: %w", err))
+	}
+	operation.Logger(ctx).Info("label", label, "message", "scrub policy overlays loaded", "scrubPolicyOverlaysFilePath", config.Global.ScrubPolicyOverlaysFilePath, "overlays", overlays.Len())
+	appCtx.ScrubOverlays = overlays
+}
+
+func (appCtx *ApplicationContext) initTokenVault(ctx context.Context) {
+	label := "application_context/initTokenVault"
+	tokenVault, err := vault.Open(config.Global.TokenVaultPath, []byte(config.Global.TokenVaultKey))
+	if err != nil {
+		operation.Logger(ctx).Error("label", label, "message", "failed to open token vault", "error", err, "tokenVaultPath", config.Global.TokenVaultPath)
+		panic(fmt.Errorf("failed to open token vault: %w", err))
+	}
+	operation.Logger(ctx).Info("label", label, "message", "token vault opened, pseudonyms are reversible", "tokenVaultPath", config.Global.TokenVaultPath, "tokens", tokenVault.Len())
+	appCtx.TokenVault = tokenVault
+	appCtx.ScrubPolicy = appCtx.ScrubPolicy.WithVault(tokenVault)
+}
+
+func (appCtx *ApplicationContext) initAvroSchemas(ctx context.Context) {
+	label := "application_context/initAvroSchemas"
+	registry, err := avro.OpenRegistry(config.Global.AvroSchemaRegistryDirectory)
+	if err != nil {
+		operation.Logger(ctx).Error("label", label, "message", "failed to open avro schema registry", "error", err, "avroSchemaRegistryDirectory", config.Global.AvroSchemaRegistryDirectory)
+		panic(fmt.Errorf("failed to open avro schema registry: %w", err))
+	}
+	operation.Logger(ctx).Info("label", label, "message", "avro schema registry opened", "avroSchemaRegistryDirectory", config.Global.AvroSchemaRegistryDirectory, "schemas", len(registry.Schemas()))
+	appCtx.AvroSchemas = registry
+}
+
+func (appCtx *ApplicationContext) initSink(ctx context.Context) {
+	label := "application_context/initSink"
+	output, err := sink.New(config.Global, appCtx.AvroSchemas)
+	if err != nil {
+		operation.Logger(ctx).Error("label", label, "message", "failed to create sink", "error", err, "sinkType", config.Global.SinkType)
+		panic(fmt.Errorf("failed to create sink: %w", err))
+	}
+	operation.Logger(ctx).Info("label", label, "message", "sink initialized", "sinkType", config.Global.SinkType)
+	appCtx.Sink = output
+}
+
+func (appCtx *ApplicationContext) initIntermediateStorage(ctx context.Context) {
+	label := "application_context/initIntermediateStorage"
+	log, err := wal.Open(wal.Options{
+		Directory:       config.Global.IntermediateStorageDirectory,
+		SegmentMaxBytes: config.Global.IntermediateStorageSegmentMaxSizeBytes,
+		MaxBytes:        config.Global.IntermediateStorageMaxSizeBytes,
+		SyncPolicy:      config.Global.IntermediateStorageSyncPolicy,
+		SyncInterval:    time.Duration(config.Global.IntermediateStorageSyncIntervalMs) * time.Millisecond,
+	})
+	if err != nil {
+		operation.Logger(ctx).Error("label", label, "message", "failed to open intermediate storage", "error", err, "directory", config.Global.IntermediateStorageDirectory)
+		panic(fmt.Errorf("failed to open intermediate storage: %w", err))
+	}
+	operation.Logger(ctx).Info("label", label, "message", "intermediate storage opened", "directory", config.Global.IntermediateStorageDirectory, "committed", log.Committed())
+	appCtx.IntermediateStorage = log
+}
+
+func (appCtx *ApplicationContext) initDeadLetters(ctx context.Context) {
+	label := "application_context/initDeadLetters"
+	var publisher deadletter.Publisher
+	if appCtx.MessagingClient != nil {
+		publisher = appCtx.MessagingClient
+	}
+	store, err := deadletter.NewStore(config.Global, publisher)
+	if err != nil {
+		operation.Logger(ctx).Error("label", label, "message", "failed to create dead letter store", "error", err, "storeType", config.Global.DeadLetterStoreType)
+		panic(fmt.Errorf("failed to create dead letter store: %w", err))
+	}
+	operation.Logger(ctx).Info("label", label, "message", "dead letter store initialized", "storeType", config.Global.DeadLetterStoreType)
+	appCtx.DeadLetters = deadletter.NewQueue(store, appCtx.EventOutput())
+}
+
+func (appCtx *ApplicationContext) initDedupFilter(ctx context.Context) {
+	label := "application_context/initDedupFilter"
+	opts := dedup.Options{
+		TTL:        time.Duration(config.Global.DedupTTLSeconds) * time.Second,
+		MaxEntries: config.Global.DedupMaxEntries,
+	}
+	if config.Global.DedupPersistenceEnabled && config.Global.IntermediateStorageEnabled {
+		opts.SnapshotPath = filepath.Join(config.Global.IntermediateStorageDirectory, dedup.SnapshotFile)
+		opts.SnapshotInterval = dedupSnapshotInterval
+	}
+	filter, err := dedup.New(opts)
+	if err != nil {
+		operation.Logger(ctx).Error("label", label, "message", "failed to create dedup filter", "error", err)
+		panic(fmt.Errorf("failed to create dedup filter: %w", err))
+	}
+	operation.Logger(ctx).Info("label", label, "message", "dedup filter initialized", "restored", filter.Len(), "snapshotPath", opts.SnapshotPath)
+	appCtx.DedupFilter = filter
+}
+
+// Pipeline returns the stages of the ingest pipeline run for every received event
+func (appCtx *ApplicationContext) Pipeline() events.Pipeline {
+	return events.Pipeline{
+		FeaturesClient: appCtx.FeaturesClient,
+		EventsPolicy:   appCtx.EventsPolicy,
+		EventSchemas:   appCtx.EventSchemas,
+		DedupFilter:    appCtx.DedupFilter,
+		ScrubPolicy:    appCtx.ScrubPolicy,
+		ScrubOverlays:  appCtx.ScrubOverlays,
+		SecretScanner:  appCtx.SecretScanner,
+		Output:         appCtx.EventOutput(),
+		DeadLetters:    appCtx.DeadLetters,
+	}
+}
+
+// EventOutput returns the sink the event handler writes to: intermediate storage when it is enabled, otherwise the sink itself
+func (appCtx *ApplicationContext) EventOutput() sink.Sink {
+	if appCtx.IntermediateStorage != nil {
+		return appCtx.IntermediateStorage
+	}
+	return appCtx.Sink
+}
+
 func (appCtx *ApplicationContext) initTokenGenerator(ctx context.Context) {
 	tokenURI := gskJWT.WithTokenURL(config.Global.TokenURI)
 	label := "application_context/initTokenGenerator"
@@ -114,6 +342,21 @@ func (appCtx *ApplicationContext) Dispose(ctx context.Context) (err error) {
 	label := "application_context/Dispose"
 	operation.Logger(ctx).Info("label", label, "message", "disposing ApplicationContext resources...")
 	allErrors := []error{}
+	if appCtx.IntermediateStorage != nil {
+		if err := appCtx.IntermediateStorage.Close(); err != nil {
+			allErrors = append(allErrors, fmt.Errorf("failed to close intermediate storage: %w", err))
+		}
+	}
+	if appCtx.Sink != nil {
+		if err := appCtx.Sink.Close(); err != nil {
+			allErrors = append(allErrors, fmt.Errorf("failed to close sink: %w", err))
+		}
+	}
+	if appCtx.TokenVault != nil {
+		if err := appCtx.TokenVault.Close(); err != nil {
+			allErrors = append(allErrors, fmt.Errorf("failed to close token vault: %w", err))
+		}
+	}
 	return errors.Join(allErrors...)
 }
 
@@ -0,0 +1,100 @@
+package events
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/deadletter"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/model"
+)
+
+// defaultDataContentType is implied by CloudEvents for events without datacontenttype
+const defaultDataContentType = "application/json"
+
+var (
+	errUnsupportedContentType = errors.New("unsupported datacontenttype")
+	errInvalidData            = errors.New("invalid event data")
+)
+
+// decodeData resolves the data of an event into a JSON value, returning the event with data_base64 decoded into Data.
+// JSON content types (application/json, text/json and +json suffixes) hold any JSON value, in data or base64 encoded in data_base64.
+// text/plain holds a string, in data or as UTF-8 in data_base64. Other content types are rejected with errUnsupportedContentType,
+// data not matching its content type with errInvalidData.
+func decodeData(event model.CloudEvent) (model.CloudEvent, error) {
+	if event.Data == nil && event.DataBase64 == "" {
+		return event, nil
+	}
+	if event.Data != nil && event.DataBase64 != "" {
+		return event, fmt.Errorf("%w: data and data_base64 are mutually exclusive", errInvalidData)
+	}
+
+	contentType := event.DataContentType
+	if contentType == "" {
+		contentType = defaultDataContentType
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return event, fmt.Errorf("%w %q: %w", errUnsupportedContentType, contentType, err)
+	}
+
+	switch {
+	case isJSONMediaType(mediaType):
+		if event.DataBase64 == "" {
+			return event, nil
+		}
+		decoded, err := decodeBase64(event.DataBase64)
+		if err != nil {
+			return event, err
+		}
+		var data any
+		if err := json.Unmarshal(decoded, &data); err != nil {
+			return event, fmt.Errorf("%w: data_base64 is not %s: %w", errInvalidData, mediaType, err)
+		}
+		event.Data, event.DataBase64 = data, ""
+		return event, nil
+	case mediaType == "text/plain":
+		if event.DataBase64 == "" {
+			if _, ok := event.Data.(string); !ok {
+				return event, fmt.Errorf("%w: %s data is not a string", errInvalidData, mediaType)
+			}
+			return event, nil
+		}
+		decoded, err := decodeBase64(event.DataBase64)
+		if err != nil {
+			return event, err
+		}
+		if !utf8.Valid(decoded) {
+			return event, fmt.Errorf("%w: data_base64 is not UTF-8 text", errInvalidData)
+		}
+		event.Data, event.DataBase64 = string(decoded), ""
+		return event, nil
+	default:
+		return event, fmt.Errorf("%w %q", errUnsupportedContentType, mediaType)
+	}
+}
+
+// isJSONMediaType reports whether data of mediaType is JSON
+func isJSONMediaType(mediaType string) bool {
+	return mediaType == "application/json" || mediaType == "text/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+func decodeBase64(encoded string) ([]byte, error) {
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: data_base64 is not base64: %w", errInvalidData, err)
+	}
+	return decoded, nil
+}
+
+// dataRejectReason returns the dead letter reason of an error returned by decodeData
+func dataRejectReason(err error) string {
+	if errors.Is(err, errUnsupportedContentType) {
+		return deadletter.ReasonUnsupportedContentType
+	}
+	return deadletter.ReasonInvalidData
+}
@@ -0,0 +1,63 @@
+package events
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/deadletter"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeData(t *testing.T) {
+	encoded := func(data string) string { return base64.StdEncoding.EncodeToString([]byte(data)) }
+
+	tests := map[string]struct {
+		event    model.CloudEvent
+		expected any
+	}{
+		"no data":           {model.CloudEvent{}, nil},
+		"object":            {model.CloudEvent{Data: map[string]any{"foo": "bar"}}, map[string]any{"foo": "bar"}},
+		"array":             {model.CloudEvent{Data: []any{1.0, 2.0}}, []any{1.0, 2.0}},
+		"scalar":            {model.CloudEvent{DataContentType: "application/json", Data: 42.0}, 42.0},
+		"json suffix":       {model.CloudEvent{DataContentType: "application/vnd.qlik+json; charset=utf-8", Data: "x"}, "x"},
+		"base64 json":       {model.CloudEvent{DataContentType: "application/json", DataBase64: encoded(`{"foo":["bar"]}`)}, map[string]any{"foo": []any{"bar"}}},
+		"text":              {model.CloudEvent{DataContentType: "text/plain", Data: "hello"}, "hello"},
+		"base64 text":       {model.CloudEvent{DataContentType: "text/plain", DataBase64: encoded("hello")}, "hello"},
+		"default to json64": {model.CloudEvent{DataBase64: encoded(`[true]`)}, []any{true}},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			decoded, err := decodeData(test.event)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, decoded.Data)
+			assert.Empty(t, decoded.DataBase64)
+		})
+	}
+}
+
+func TestDecodeData_Rejected(t *testing.T) {
+	tests := map[string]struct {
+		event  model.CloudEvent
+		reason string
+	}{
+		"binary content type":   {model.CloudEvent{DataContentType: "application/octet-stream", DataBase64: "AAEC"}, deadletter.ReasonUnsupportedContentType},
+		"xml content type":      {model.CloudEvent{DataContentType: "application/xml", Data: "<a/>"}, deadletter.ReasonUnsupportedContentType},
+		"malformed type":        {model.CloudEvent{DataContentType: "json;;", Data: "x"}, deadletter.ReasonUnsupportedContentType},
+		"data and data_base64":  {model.CloudEvent{Data: "x", DataBase64: "eA=="}, deadletter.ReasonInvalidData},
+		"malformed base64":      {model.CloudEvent{DataBase64: "not base64!"}, deadletter.ReasonInvalidData},
+		"base64 is not json":    {model.CloudEvent{DataBase64: "eyJmb28i"}, deadletter.ReasonInvalidData},
+		"text is not a string":  {model.CloudEvent{DataContentType: "text/plain", Data: map[string]any{}}, deadletter.ReasonInvalidData},
+		"base64 text not UTF-8": {model.CloudEvent{DataContentType: "text/plain", DataBase64: "/w=="}, deadletter.ReasonInvalidData},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := decodeData(test.event)
+			require.Error(t, err)
+			assert.Equal(t, test.reason, dataRejectReason(err))
+		})
+	}
+}
@@ -3,14 +3,58 @@ package events
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
 
 	"github.com/qlik-trial/go-service-kit/v29/messaging"
 	"github.com/qlik-trial/go-service-kit/v29/operation"
+	"github.com/qlik-trial/usage-telemetry-publisher/cmd/config"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/deadletter"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/dedup"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/eventschema"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/eventspolicy"
 	"github.com/qlik-trial/usage-telemetry-publisher/internal/features"
 	"github.com/qlik-trial/usage-telemetry-publisher/internal/model"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/scrubber"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/secrets"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/sink"
 )
 
-func EventHandler(ctx context.Context, featuresClient features.FeaturesClient) messaging.MsgHandler {
+// errIngestionDisabled is returned when event ingestion is not enabled for the event's tenant
+var errIngestionDisabled = errors.New("event ingestion is disabled for tenant")
+
+// Pipeline holds the stages of the ingest pipeline
+type Pipeline struct {
+	// FeaturesClient gates tenants on features.EventIngestionFlag, no tenant is gated when nil
+	FeaturesClient features.FeaturesClient
+	// EventsPolicy filters events by type
+	EventsPolicy eventspolicy.Evaluator
+	// EventSchemas validate events against the schemas of their type, no event is validated when nil
+	EventSchemas *eventschema.Rules
+	// DedupFilter drops already published events, nothing is deduplicated when nil
+	DedupFilter *dedup.Filter
+	// ScrubPolicy masks the personal data of events before they are written to Output
+	ScrubPolicy *scrubber.Policy
+	// ScrubOverlays resolve the ScrubPolicy of a tenant with the overlay assigned to it, ScrubPolicy applies to all tenants when nil
+	ScrubOverlays *scrubber.Overlays
+	// SecretScanner redacts the secrets left in scrubbed events, nothing is scanned when nil
+	SecretScanner *secrets.Scanner
+	// Output receives the scrubbed events
+	Output sink.Sink
+	// DeadLetters keeps the events that were rejected instead of being published
+	DeadLetters *deadletter.Queue
+}
+
+// EventHandler returns a handler running the ingest pipeline for every received message:
+// the event type is checked against the events policy, the event is validated against the schemas of its type,
+// already published events are dropped by the dedup filter, the tenant is gated on features.EventIngestionFlag,
+// the event is scrubbed with the tenant's scrub policy,
+// secrets left in it are redacted by the secret scanner and it is then written to the output sink, which flattens it into records with its formatter.Options.
+// The message is only acked once the sink has accepted the event, so failed writes are redelivered.
+// Messages that cannot be published are acked and dead-lettered with the reason they were rejected.
+func EventHandler(ctx context.Context, pipeline Pipeline) messaging.MsgHandler {
 	label := "event_handler/EventHandler"
 	return func(msg *messaging.Message) {
 		op, ctx := operation.NewOperation(ctx, "handling_event", operation.RecordMetrics(true))
@@ -19,42 +63,221 @@ func EventHandler(ctx context.Context, featuresClient features.FeaturesClient) m
 			op.Finish(err)
 		}()
 
-		event, unmarshalErr := parseEvent(ctx, msg)
+		event, unmarshalErr := parseEvent(ctx, msg, pipeline.DeadLetters)
 		if unmarshalErr != nil {
 			return
 		}
 
-		if !validateEvent(ctx, msg, event) {
+		if !validateEvent(ctx, msg, pipeline, event) {
+			return
+		}
+
+		event, ok := decodeEventData(ctx, msg, pipeline, event)
+		if !ok {
+			return
+		}
+
+		if !allowEvent(ctx, msg, pipeline, event) {
+			return
+		}
+
+		if !conformsToSchema(ctx, msg, pipeline, event) {
+			return
+		}
+
+		if isDuplicate(pipeline.DedupFilter, event) {
+			operation.Logger(ctx).Debug("label", label, "message", "duplicate event, skipping event", "eventId", event.Id, "eventType", event.EventType)
+			filteredEventsCounter.WithLabelValues(filterReasonDuplicate).Inc()
+			ackWithLog(ctx, msg, label)
+			return
+		}
+
+		scrubbed, err := processEvent(ctx, pipeline, event)
+		if errors.Is(err, errIngestionDisabled) {
+			operation.Logger(ctx).Debug("label", label, "message", "event ingestion disabled, skipping event", "tenantId", event.TenantId)
+			err = nil
+			ackWithLog(ctx, msg, label)
+			return
+		}
+		var rejected *sink.RejectedError
+		if errors.As(err, &rejected) {
+			operation.Logger(ctx).Error("label", label, "message", "sink could not encode event", "error", err, "eventType", event.EventType)
+			filteredEventsCounter.WithLabelValues(filterReasonEncodingError).Inc()
+			rejectWithLog(ctx, pipeline.DeadLetters, deadletter.ReasonEncodingError, rejected.Rejected[0].Err, scrubbed, label)
+			err = nil
+			ackWithLog(ctx, msg, label)
+			return
+		}
+		if errors.Is(err, sink.ErrPermanent) {
+			// redelivering the event would fail again, so it is dead-lettered instead
+			operation.Logger(ctx).Error("label", label, "message", "sink permanently rejected event", "error", err, "eventType", event.EventType)
+			filteredEventsCounter.WithLabelValues(filterReasonSinkPermanentFailure).Inc()
+			rejectWithLog(ctx, pipeline.DeadLetters, deadletter.ReasonSinkPermanentFailure, err, scrubbed, label)
+			err = nil
+			ackWithLog(ctx, msg, label)
+			return
+		}
+		if err != nil {
+			operation.Logger(ctx).Error("label", label, "message", "failed to process event", "error", err, "eventType", event.EventType)
 			return
 		}
 
+		if pipeline.DedupFilter != nil {
+			pipeline.DedupFilter.Remember(event.TenantId, event.Id)
+		}
 		operation.Logger(ctx).Debug("label", label, "event", event.Source, "message", "event handled")
 		ackWithLog(ctx, msg, label)
 	}
 }
 
-func parseEvent(ctx context.Context, msg *messaging.Message) (model.CloudEvent, error) {
+// processEvent runs a valid event through the gate, scrub and publish steps of the pipeline, returning the scrubbed event
+func processEvent(ctx context.Context, pipeline Pipeline, event model.CloudEvent) (*model.ScrubbedEvent, error) {
+	label := "event_handler/processEvent"
+	enabled, err := isIngestionEnabled(ctx, pipeline.FeaturesClient, event.TenantId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate %s: %w", features.EventIngestionFlag, err)
+	}
+	if !enabled {
+		return nil, errIngestionDisabled
+	}
+
+	scrubbed, fired := scrubber.ScrubEvent(event, scrubPolicy(ctx, pipeline, event.TenantId))
+	operation.Logger(ctx).Debug("label", label, "message", "event scrubbed", "eventType", event.EventType, "rules", fired, "scrubPolicyVersion", scrubbed.ScrubPolicyVersion)
+	scanForSecrets(ctx, pipeline.SecretScanner, &scrubbed)
+	if err := pipeline.Output.Write(ctx, []*model.ScrubbedEvent{&scrubbed}); err != nil {
+		return &scrubbed, fmt.Errorf("failed to write event to sink: %w", err)
+	}
+	return &scrubbed, nil
+}
+
+// scrubPolicy resolves the scrub policy of a tenant: the overlay selected by features.ScrubPolicyOverlayFlag,
+// else the overlay assigned to the tenant in the overlays file, else the base policy
+func scrubPolicy(ctx context.Context, pipeline Pipeline, tenantID string) *scrubber.Policy {
+	label := "event_handler/scrubPolicy"
+	if pipeline.ScrubOverlays == nil {
+		return pipeline.ScrubPolicy
+	}
+
+	selected := ""
+	if config.Global.FeatureFlagsEnabled && pipeline.FeaturesClient != nil {
+		var err error
+		selected, err = pipeline.FeaturesClient.GetStringTenantFeature(ctx, features.ScrubPolicyOverlayFlag, tenantID)
+		if err != nil {
+			operation.Logger(ctx).Warn("label", label, "message", "failed to evaluate scrub policy overlay flag", "error", err, "tenantId", tenantID)
+		}
+	}
+
+	policy, err := pipeline.ScrubOverlays.Resolve(tenantID, selected)
+	if err != nil {
+		operation.Logger(ctx).Warn("label", label, "message", "scrub policy overlay flag names an unknown overlay", "error", err, "tenantId", tenantID)
+	}
+	return policy
+}
+
+// scanForSecrets redacts the secrets left in a scrubbed event, logging where they were found but never their values
+func scanForSecrets(ctx context.Context, scanner *secrets.Scanner, event *model.ScrubbedEvent) {
+	label := "event_handler/scanForSecrets"
+	if scanner == nil {
+		return
+	}
+	for _, finding := range scanner.Scan(event) {
+		operation.Logger(ctx).Error(
+			"label", label,
+			"message", "secret found in event, value redacted",
+			"severity", "high",
+			"kind", finding.Kind,
+			"field", finding.Field,
+			"eventId", event.Id,
+			"eventType", event.Type,
+			"tenantId", event.TenantId)
+	}
+}
+
+// isDuplicate checks the event against the dedup filter, nothing is a duplicate when deduplication is disabled
+func isDuplicate(dedupFilter *dedup.Filter, event model.CloudEvent) bool {
+	return dedupFilter != nil && dedupFilter.IsDuplicate(event.TenantId, event.Id, event.EventType)
+}
+
+// isIngestionEnabled evaluates the tenant gate. Ingestion is always enabled when feature flags are not in use.
+func isIngestionEnabled(ctx context.Context, featuresClient features.FeaturesClient, tenantID string) (bool, error) {
+	if !config.Global.FeatureFlagsEnabled || featuresClient == nil {
+		return true, nil
+	}
+	return featuresClient.GetBoolTenantFeature(ctx, features.EventIngestionFlag, tenantID)
+}
+
+func parseEvent(ctx context.Context, msg *messaging.Message, deadLetters *deadletter.Queue) (model.CloudEvent, error) {
 	label := "event_handler/parseEvent"
 	var event model.CloudEvent
 	if err := json.Unmarshal(msg.Data, &event); err != nil {
+		// the payload is not logged, it may contain personal data
 		operation.Logger(ctx).Info(
 			"label", label,
 			"message", "failed to unmarshal event",
 			"error", err,
-			"event", string(msg.Data))
+			"size", len(msg.Data))
+		filteredEventsCounter.WithLabelValues(filterReasonUnmarshalError).Inc()
+		if rejectErr := deadLetters.RejectPayload(ctx, deadletter.ReasonUnmarshalError, err, msg.Data); rejectErr != nil {
+			operation.Logger(ctx).Error("label", label, "message", "failed to dead-letter event", "error", rejectErr)
+		}
 		ackWithLog(ctx, msg, label)
 		return event, err
 	}
 	return event, nil
 }
 
-func validateEvent(ctx context.Context, msg *messaging.Message, event model.CloudEvent) bool {
+func validateEvent(ctx context.Context, msg *messaging.Message, pipeline Pipeline, event model.CloudEvent) bool {
 	label := "event_handler/validateEvent"
-	if !isValidEvent(event) {
+	if reason := invalidEventReason(event); reason != "" {
 		operation.Logger(ctx).Info(
 			"label", label,
 			"message", "event is not valid",
-			"event", event)
+			"reason", reason,
+			"eventId", event.Id,
+			"eventType", event.EventType)
+		filteredEventsCounter.WithLabelValues(filterReasonInvalidEvent).Inc()
+		rejectEventWithLog(ctx, pipeline, reason, nil, event, label)
+		ackWithLog(ctx, msg, label)
+		return false
+	}
+
+	return true
+}
+
+// decodeEventData resolves the data of a valid event with decodeData. Events whose data cannot be decoded are dead-lettered
+// without their data, which cannot be scrubbed.
+func decodeEventData(ctx context.Context, msg *messaging.Message, pipeline Pipeline, event model.CloudEvent) (model.CloudEvent, bool) {
+	label := "event_handler/decodeEventData"
+	decoded, err := decodeData(event)
+	if err != nil {
+		reason := dataRejectReason(err)
+		operation.Logger(ctx).Info(
+			"label", label,
+			"message", "event data cannot be decoded",
+			"reason", reason,
+			"error", err,
+			"eventId", event.Id,
+			"eventType", event.EventType)
+		filteredEventsCounter.WithLabelValues(reason).Inc()
+		event.Data, event.DataBase64 = nil, ""
+		rejectEventWithLog(ctx, pipeline, reason, err, event, label)
+		ackWithLog(ctx, msg, label)
+		return event, false
+	}
+
+	return decoded, true
+}
+
+func allowEvent(ctx context.Context, msg *messaging.Message, pipeline Pipeline, event model.CloudEvent) bool {
+	label := "event_handler/allowEvent"
+	if allowed, reason := pipeline.EventsPolicy.Evaluate(event.EventType); !allowed {
+		operation.Logger(ctx).Debug(
+			"label", label,
+			"message", "event filtered out by events policy",
+			"eventType", event.EventType,
+			"reason", reason)
+		// the policy drops events on purpose, so they are only counted and not dead-lettered
+		filteredEventsCounter.WithLabelValues(reason).Inc()
 		ackWithLog(ctx, msg, label)
 		return false
 	}
@@ -62,6 +285,47 @@ func validateEvent(ctx context.Context, msg *messaging.Message, event model.Clou
 	return true
 }
 
+// conformsToSchema validates an allowed event against the schemas of its type, dead-lettering it when it does not conform
+func conformsToSchema(ctx context.Context, msg *messaging.Message, pipeline Pipeline, event model.CloudEvent) bool {
+	label := "event_handler/conformsToSchema"
+	if pipeline.EventSchemas == nil {
+		return true
+	}
+	if err := pipeline.EventSchemas.Validate(event); err != nil {
+		// violations name the paths of the offending values, never the values
+		operation.Logger(ctx).Info(
+			"label", label,
+			"message", "event does not conform to its schema",
+			"error", err,
+			"eventId", event.Id,
+			"eventType", event.EventType,
+			"tenantId", event.TenantId)
+		filteredEventsCounter.WithLabelValues(deadletter.ReasonSchemaViolation).Inc()
+		rejectEventWithLog(ctx, pipeline, deadletter.ReasonSchemaViolation, err, event, label)
+		ackWithLog(ctx, msg, label)
+		return false
+	}
+
+	return true
+}
+
+// rejectEventWithLog scrubs an event that was rejected before reaching the sink and dead-letters it
+func rejectEventWithLog(ctx context.Context, pipeline Pipeline, reason string, cause error, event model.CloudEvent, label string) {
+	scrubbed, _ := scrubber.ScrubEvent(event, scrubPolicy(ctx, pipeline, event.TenantId))
+	scanForSecrets(ctx, pipeline.SecretScanner, &scrubbed)
+	rejectWithLog(ctx, pipeline.DeadLetters, reason, cause, &scrubbed, label)
+}
+
+func rejectWithLog(ctx context.Context, deadLetters *deadletter.Queue, reason string, cause error, event *model.ScrubbedEvent, label string) {
+	if err := deadLetters.Reject(ctx, reason, cause, event); err != nil {
+		operation.Logger(ctx).Error(
+			"label", label,
+			"message", "failed to dead-letter event",
+			"error", err,
+			"reason", reason)
+	}
+}
+
 func ackWithLog(ctx context.Context, msg *messaging.Message, label string) {
 	ackErr := msg.Ack()
 	if ackErr != nil {
@@ -73,8 +337,43 @@ func ackWithLog(ctx context.Context, msg *messaging.Message, label string) {
 }
 
 func isValidEvent(event model.CloudEvent) bool {
-	if event.EventType == "" || event.Time == "" || event.TenantId == "" {
+	return invalidEventReason(event) == ""
+}
+
+// supportedSpecVersion is the CloudEvents specversion events must have
+const supportedSpecVersion = "1.0"
+
+// invalidEventReason returns the dead letter reason of an event breaking the CloudEvents 1.0 rules of the required
+// specversion, id, source and type attributes, or missing the tenantid and time attributes every event must have.
+// It returns "" for a valid event.
+func invalidEventReason(event model.CloudEvent) string {
+	switch {
+	case event.SpecVersion == "":
+		return deadletter.ReasonMissingSpecVersion
+	case event.SpecVersion != supportedSpecVersion:
+		return deadletter.ReasonUnsupportedSpecVersion
+	case event.Id == "":
+		return deadletter.ReasonMissingID
+	case event.Source == "":
+		return deadletter.ReasonMissingSource
+	case !isURIReference(event.Source):
+		return deadletter.ReasonInvalidSource
+	case event.EventType == "":
+		return deadletter.ReasonMissingType
+	case event.TenantId == "":
+		return deadletter.ReasonMissingTenantID
+	case event.Time == "":
+		return deadletter.ReasonMissingTime
+	default:
+		return ""
+	}
+}
+
+// isURIReference reports whether value is a URI-reference as defined by RFC 3986
+func isURIReference(value string) bool {
+	if strings.ContainsAny(value, " \t\n\r") {
 		return false
 	}
-	return true
+	_, err := url.Parse(value)
+	return err == nil
 }
@@ -0,0 +1,25 @@
+package events
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	filterReasonUnmarshalError = "unmarshal_error"
+	filterReasonInvalidEvent   = "invalid_event"
+	// filterReasonSinkPermanentFailure counts events the sink rejected with sink.ErrPermanent
+	filterReasonSinkPermanentFailure = "sink_permanent_failure"
+	// filterReasonEncodingError counts events the sink rejected with a sink.RejectedError
+	filterReasonEncodingError = "encoding_error"
+	// filterReasonDuplicate counts events dropped by the dedup filter
+	filterReasonDuplicate = "duplicate"
+)
+
+// filteredEventsCounter counts events that are acked without being published, by reason.
+// Validation reasons, the dead letter reasons of undecodable data and the eventspolicy reasons share the counter so drops can be told apart.
+var filteredEventsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "usage_telemetry_publisher",
+	Name:      "filtered_events_total",
+	Help:      "Number of received events that were filtered out instead of being published",
+}, []string{"reason"})
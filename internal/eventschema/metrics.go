@@ -0,0 +1,14 @@
+package eventschema
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// schemaViolationsCounter counts the events not conforming to the schemas of their type.
+// Only event types listed in the event schemas file are counted, so the label is bounded.
+var schemaViolationsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "usage_telemetry_publisher",
+	Name:      "schema_violations_total",
+	Help:      "Number of events rejected for not conforming to the schema of their event type",
+}, []string{"event_type"})
@@ -0,0 +1,154 @@
+package eventschema
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/model"
+	"gopkg.in/yaml.v3"
+)
+
+// EventTypeSpec holds the schemas events of one type must conform to
+type EventTypeSpec struct {
+	// Attributes is the schema of the object of the event's attributes, extensions included.
+	// Attributes with an empty value are left out of the object, so required attributes must be set.
+	Attributes *Schema `yaml:"attributes"`
+	// Data is the schema of the event's data
+	Data *Schema `yaml:"data"`
+}
+
+// Spec is the content of the event schemas file
+type Spec struct {
+	// EventTypes are the schemas by CloudEvent type, events of other types are not validated
+	EventTypes map[string]EventTypeSpec `yaml:"eventTypes"`
+}
+
+// ValidationError is returned for an event that does not conform to the schemas of its type
+type ValidationError struct {
+	EventType  string
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	violations := make([]string, 0, len(e.Violations))
+	for _, violation := range e.Violations {
+		violations = append(violations, violation.String())
+	}
+	return fmt.Sprintf("event of type %s does not conform to its schema: %s", e.EventType, strings.Join(violations, "; "))
+}
+
+// Rules validate events against the schemas of their type
+type Rules struct {
+	eventTypes map[string]EventTypeSpec
+}
+
+// None returns Rules validating no event
+func None() *Rules {
+	return &Rules{eventTypes: map[string]EventTypeSpec{}}
+}
+
+// New creates Rules from spec, validating its schemas
+func New(spec Spec) (*Rules, error) {
+	rules := None()
+	for eventType, typeSpec := range spec.EventTypes {
+		if typeSpec.Attributes != nil {
+			if err := typeSpec.Attributes.compile(eventType + ".attributes"); err != nil {
+				return nil, err
+			}
+		}
+		if typeSpec.Data != nil {
+			if err := typeSpec.Data.compile(eventType + ".data"); err != nil {
+				return nil, err
+			}
+		}
+		rules.eventTypes[eventType] = typeSpec
+	}
+	return rules, nil
+}
+
+// Parse creates Rules from the YAML content of an event schemas file, unknown schema keywords are an error
+func Parse(data []byte) (*Rules, error) {
+	var spec Spec
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&spec); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse event schemas file: %w", err)
+	}
+	return New(spec)
+}
+
+// LoadOrNone reads and parses the event schemas file at filePath, a missing file validates no event
+func LoadOrNone(filePath string) (*Rules, error) {
+	data, err := os.ReadFile(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return None(), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read event schemas file: %w", err)
+	}
+	return Parse(data)
+}
+
+// Len returns the number of event types with schemas
+func (r *Rules) Len() int {
+	return len(r.eventTypes)
+}
+
+// Validate checks an event against the schemas of its type, returning a *ValidationError if it does not conform.
+// Data is validated as received, before it is scrubbed.
+func (r *Rules) Validate(event model.CloudEvent) error {
+	typeSpec, ok := r.eventTypes[event.EventType]
+	if !ok {
+		return nil
+	}
+
+	var violations []Violation
+	if typeSpec.Attributes != nil {
+		typeSpec.Attributes.validate("attributes", attributes(event), &violations)
+	}
+	if typeSpec.Data != nil {
+		typeSpec.Data.validate("data", event.Data, &violations)
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+	schemaViolationsCounter.WithLabelValues(event.EventType).Inc()
+	return &ValidationError{EventType: event.EventType, Violations: violations}
+}
+
+// attributes returns the attributes of an event with a value, by attribute name
+func attributes(event model.CloudEvent) map[string]any {
+	object := make(map[string]any, len(event.Extensions))
+	for name, value := range event.Extensions {
+		object[name] = value
+	}
+	for name, value := range map[string]string{
+		"id":                 event.Id,
+		"specversion":        event.SpecVersion,
+		"tenantid":           event.TenantId,
+		"userid":             event.UserId,
+		"sessionid":          event.SessionId,
+		"source":             event.Source,
+		"type":               event.EventType,
+		"time":               event.Time,
+		"subject":            event.Subject,
+		"host":               event.Host,
+		"originip":           event.OriginIp,
+		"ownerid":            event.OwnerId,
+		"toplevelresourceid": event.TopLevelResourceId,
+		"spaceid":            event.SpaceId,
+		"clientid":           event.ClientId,
+		"reason":             event.Reason,
+		"datacontenttype":    event.DataContentType,
+		"dataschema":         event.DataSchema,
+	} {
+		if value != "" {
+			object[name] = value
+		}
+	}
+	return object
+}
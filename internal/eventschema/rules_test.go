@@ -0,0 +1,136 @@
+package eventschema
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSchemas = `
+eventTypes:
+  com.qlik.v1.analytics.sheet.viewed:
+    attributes:
+      required: [userid, source]
+      properties:
+        source: {pattern: "^/analytics"}
+        priority: {type: integer, minimum: 0, maximum: 9}
+    data:
+      type: object
+      required: [sheetId, mode]
+      additionalProperties: false
+      properties:
+        sheetId: {type: string, maxLength: 8}
+        mode: {enum: [view, edit]}
+        durationMs: {type: number, minimum: 0}
+        tags:
+          type: array
+          maxItems: 2
+          items: {type: string}
+`
+
+func TestValidate(t *testing.T) {
+	rules, err := Parse([]byte(testSchemas))
+	require.NoError(t, err)
+	assert.Equal(t, 1, rules.Len())
+
+	valid := model.CloudEvent{
+		Id:         "event-1",
+		Source:     "/analytics/sheets",
+		EventType:  "com.qlik.v1.analytics.sheet.viewed",
+		UserId:     "user-1",
+		Extensions: map[string]any{"priority": 3.0},
+		Data:       map[string]any{"sheetId": "sheet-1", "mode": "view", "durationMs": 12.0, "tags": []any{"a"}},
+	}
+	require.NoError(t, rules.Validate(valid))
+
+	t.Run("reports violations without values", func(t *testing.T) {
+		invalid := valid
+		invalid.UserId = ""
+		invalid.Source = "/reloads"
+		invalid.Extensions = map[string]any{"priority": 3.5}
+		invalid.Data = map[string]any{
+			"sheetId": "sheet-123456", "mode": "delete", "durationMs": -1.0, "tags": []any{"a", 2.0, "c"}, "email": "jane@example.com",
+		}
+
+		err := rules.Validate(invalid)
+
+		var validationErr *ValidationError
+		require.ErrorAs(t, err, &validationErr)
+		assert.Equal(t, []Violation{
+			{Path: "attributes.userid", Message: "is required"},
+			{Path: "attributes.priority", Message: "expected integer, got number"},
+			{Path: "attributes.source", Message: "does not match pattern ^/analytics"},
+			{Path: "data.durationMs", Message: "is less than 0"},
+			{Path: "data.email", Message: "is not allowed"},
+			{Path: "data.mode", Message: "is not one of the 2 allowed values"},
+			{Path: "data.sheetId", Message: "is longer than 8 characters"},
+			{Path: "data.tags", Message: "has more than 2 items"},
+			{Path: "data.tags[1]", Message: "expected string, got number"},
+		}, validationErr.Violations)
+		assert.NotContains(t, err.Error(), "jane@example.com")
+	})
+
+	t.Run("data of the wrong type", func(t *testing.T) {
+		invalid := valid
+		invalid.Data = []any{"sheet-1"}
+		var validationErr *ValidationError
+		require.ErrorAs(t, rules.Validate(invalid), &validationErr)
+		assert.Equal(t, []Violation{{Path: "data", Message: "expected object, got array"}}, validationErr.Violations)
+	})
+
+	t.Run("events of other types are not validated", func(t *testing.T) {
+		assert.NoError(t, rules.Validate(model.CloudEvent{EventType: "com.qlik.v1.analytics.sheet.edited"}))
+	})
+}
+
+func TestValidate_MaxViolations(t *testing.T) {
+	rules, err := Parse([]byte(`eventTypes: {test: {data: {items: {type: string}}}}`))
+	require.NoError(t, err)
+
+	var validationErr *ValidationError
+	require.ErrorAs(t, rules.Validate(model.CloudEvent{EventType: "test", Data: make([]any, 50)}), &validationErr)
+	assert.Len(t, validationErr.Violations, maxViolations)
+}
+
+func TestParse_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"malformed yaml":    "eventTypes: [",
+		"unknown keyword":   "eventTypes: {test: {data: {format: email}}}",
+		"unknown type":      "eventTypes: {test: {data: {type: text}}}",
+		"invalid pattern":   "eventTypes: {test: {data: {pattern: '['}}}",
+		"negative length":   "eventTypes: {test: {data: {maxLength: -1}}}",
+		"inverted items":    "eventTypes: {test: {data: {minItems: 3, maxItems: 2}}}",
+		"inverted range":    "eventTypes: {test: {data: {minimum: 3, maximum: 2}}}",
+		"nested error":      "eventTypes: {test: {attributes: {properties: {source: {type: url}}}}}",
+		"empty property":    "eventTypes: {test: {data: {properties: {foo: }}}}",
+		"invalid item type": "eventTypes: {test: {data: {items: {type: [string, text]}}}}",
+	}
+
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Parse([]byte(content))
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestLoadOrNone(t *testing.T) {
+	rules, err := LoadOrNone(filepath.Join(t.TempDir(), "missing.yaml"))
+	require.NoError(t, err)
+	assert.Equal(t, 0, rules.Len())
+
+	filePath := filepath.Join(t.TempDir(), "event-schemas.yaml")
+	require.NoError(t, os.WriteFile(filePath, []byte(testSchemas), 0o600))
+	rules, err = LoadOrNone(filePath)
+	require.NoError(t, err)
+	assert.Equal(t, 1, rules.Len())
+
+	require.NoError(t, os.WriteFile(filePath, []byte("---\n"), 0o600))
+	rules, err = LoadOrNone(filePath)
+	require.NoError(t, err)
+	assert.Equal(t, 0, rules.Len())
+}
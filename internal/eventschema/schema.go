@@ -0,0 +1,249 @@
+package eventschema
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// types are the JSON Schema types a Schema may require
+var types = []string{"object", "array", "string", "number", "integer", "boolean", "null"}
+
+// Schema is the subset of JSON Schema validation rules supported in event schemas files:
+// type, enum, required, properties, additionalProperties, items, minItems, maxItems, minLength, maxLength,
+// pattern, minimum and maximum. Other keywords are rejected rather than ignored.
+type Schema struct {
+	// Type is one type or a list of types
+	Type       TypeList           `yaml:"type"`
+	Enum       []any              `yaml:"enum"`
+	Required   []string           `yaml:"required"`
+	Properties map[string]*Schema `yaml:"properties"`
+	// AdditionalProperties rejects the properties of an object Properties does not define when false
+	AdditionalProperties *bool   `yaml:"additionalProperties"`
+	Items                *Schema `yaml:"items"`
+	MinItems             *int    `yaml:"minItems"`
+	MaxItems             *int    `yaml:"maxItems"`
+	// MinLength and MaxLength count the characters of a string
+	MinLength *int     `yaml:"minLength"`
+	MaxLength *int     `yaml:"maxLength"`
+	Pattern   string   `yaml:"pattern"`
+	Minimum   *float64 `yaml:"minimum"`
+	Maximum   *float64 `yaml:"maximum"`
+
+	pattern *regexp.Regexp
+}
+
+// TypeList holds the types of a Schema, written as a single type or a list of types
+type TypeList []string
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (t *TypeList) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*t = TypeList{node.Value}
+		return nil
+	}
+	var list []string
+	if err := node.Decode(&list); err != nil {
+		return err
+	}
+	*t = list
+	return nil
+}
+
+// Violation is a value breaking a schema rule. Violations never hold the value itself, it may be personal data.
+type Violation struct {
+	// Path locates the value, e.g. data.items[2].name
+	Path    string
+	Message string
+}
+
+func (v Violation) String() string {
+	return v.Path + ": " + v.Message
+}
+
+// maxViolations caps the violations reported for an event
+const maxViolations = 10
+
+// compile validates the schema and compiles its patterns, path locates the schema in the schemas file
+func (s *Schema) compile(path string) error {
+	for _, t := range s.Type {
+		if !slices.Contains(types, t) {
+			return fmt.Errorf("%s: unknown type %q", path, t)
+		}
+	}
+	if s.Pattern != "" {
+		pattern, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("%s: invalid pattern: %w", path, err)
+		}
+		s.pattern = pattern
+	}
+	if err := checkBounds(s.MinItems, s.MaxItems); err != nil {
+		return fmt.Errorf("%s: items %w", path, err)
+	}
+	if err := checkBounds(s.MinLength, s.MaxLength); err != nil {
+		return fmt.Errorf("%s: length %w", path, err)
+	}
+	if s.Minimum != nil && s.Maximum != nil && *s.Minimum > *s.Maximum {
+		return fmt.Errorf("%s: minimum is greater than maximum", path)
+	}
+	for name, property := range s.Properties {
+		if property == nil {
+			return fmt.Errorf("%s.properties.%s: empty schema", path, name)
+		}
+		if err := property.compile(path + ".properties." + name); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		return s.Items.compile(path + ".items")
+	}
+	return nil
+}
+
+func checkBounds(minimum, maximum *int) error {
+	switch {
+	case minimum != nil && *minimum < 0, maximum != nil && *maximum < 0:
+		return errors.New("bounds must not be negative")
+	case minimum != nil && maximum != nil && *minimum > *maximum:
+		return errors.New("minimum is greater than maximum")
+	default:
+		return nil
+	}
+}
+
+// validate appends the violations of value, located at path, to violations
+func (s *Schema) validate(path string, value any, violations *[]Violation) {
+	report := func(path, format string, args ...any) {
+		if len(*violations) < maxViolations {
+			*violations = append(*violations, Violation{Path: path, Message: fmt.Sprintf(format, args...)})
+		}
+	}
+
+	if len(s.Type) > 0 && !slices.ContainsFunc(s.Type, func(t string) bool { return hasType(value, t) }) {
+		report(path, "expected %s, got %s", joinTypes(s.Type), typeOf(value))
+		return
+	}
+	if len(s.Enum) > 0 && !slices.ContainsFunc(s.Enum, func(allowed any) bool { return equal(allowed, value) }) {
+		report(path, "is not one of the %d allowed values", len(s.Enum))
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				report(path+"."+name, "is required")
+			}
+		}
+		for _, name := range slices.Sorted(maps.Keys(v)) {
+			if property, ok := s.Properties[name]; ok {
+				property.validate(path+"."+name, v[name], violations)
+			} else if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				report(path+"."+name, "is not allowed")
+			}
+		}
+	case []any:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			report(path, "has fewer than %d items", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			report(path, "has more than %d items", *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.validate(path+"["+strconv.Itoa(i)+"]", item, violations)
+			}
+		}
+	case string:
+		length := utf8.RuneCountInString(v)
+		if s.MinLength != nil && length < *s.MinLength {
+			report(path, "is shorter than %d characters", *s.MinLength)
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			report(path, "is longer than %d characters", *s.MaxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			report(path, "does not match pattern %s", s.Pattern)
+		}
+	default:
+		if number, ok := toFloat(v); ok {
+			if s.Minimum != nil && number < *s.Minimum {
+				report(path, "is less than %v", *s.Minimum)
+			}
+			if s.Maximum != nil && number > *s.Maximum {
+				report(path, "is greater than %v", *s.Maximum)
+			}
+		}
+	}
+}
+
+// hasType reports whether value is of the JSON Schema type t
+func hasType(value any, t string) bool {
+	if t == "integer" {
+		number, ok := toFloat(value)
+		return ok && number == math.Trunc(number)
+	}
+	return typeOf(value) == t
+}
+
+// typeOf returns the JSON Schema type of a decoded JSON value
+func typeOf(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	default:
+		if _, ok := toFloat(value); ok {
+			return "number"
+		}
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+func joinTypes(types TypeList) string {
+	if len(types) == 1 {
+		return types[0]
+	}
+	return fmt.Sprintf("one of %v", []string(types))
+}
+
+// toFloat returns the value of a number decoded from JSON, float64, or YAML, int
+func toFloat(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
+
+// equal compares an enum value decoded from YAML with a value decoded from JSON, numbers are equal by value
+func equal(allowed, value any) bool {
+	a, aNumber := toFloat(allowed)
+	b, bNumber := toFloat(value)
+	if aNumber || bNumber {
+		return aNumber && bNumber && a == b
+	}
+	return reflect.DeepEqual(allowed, value)
+}
@@ -0,0 +1,27 @@
+package eventspolicy
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	reloadResultSuccess = "success"
+	reloadResultFailure = "failure"
+)
+
+var (
+	// versionGauge exposes the version of the events policy in use
+	versionGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "usage_telemetry_publisher",
+		Name:      "events_policy_info",
+		Help:      "Version of the events policy currently in use, a hash of the events file content",
+	}, []string{"version"})
+
+	// reloadsCounter counts events file reloads by result
+	reloadsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "usage_telemetry_publisher",
+		Name:      "events_policy_reloads_total",
+		Help:      "Number of events file reloads, by result",
+	}, []string{"result"})
+)
@@ -0,0 +1,126 @@
+package eventspolicy
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// ReasonNotAllowed is reported when an allow list is configured and the event type does not match it
+	ReasonNotAllowed = "not_allowed"
+	// ReasonDisallowed is reported when the event type matches the disallow list
+	ReasonDisallowed = "disallowed"
+)
+
+// Evaluator decides whether an event type may be published
+type Evaluator interface {
+	// Evaluate returns true if events of eventType are allowed, otherwise false and the reason they are filtered out
+	Evaluate(eventType string) (bool, string)
+}
+
+// Spec is the content of the events file rendered by the events configmap
+type Spec struct {
+	AllowedEvents    []string `yaml:"allowedEvents"`
+	DisallowedEvents []string `yaml:"disallowedEvents"`
+}
+
+// Policy filters events by their CloudEvent type.
+// Patterns are either globs (com.qlik.v1.analytics.*), or plain event types which match the type itself
+// and every type nested below it (com.qlik.v1.analytics matches com.qlik.v1.analytics.sheet.viewed).
+// An empty allow list allows every event type, and the disallow list always takes precedence.
+type Policy struct {
+	allowed    []pattern
+	disallowed []pattern
+}
+
+type pattern struct {
+	value string
+	glob  bool
+}
+
+// AllowAll returns a Policy that does not filter any events
+func AllowAll() *Policy {
+	return &Policy{}
+}
+
+// New creates a Policy from spec, validating its patterns
+func New(spec Spec) (*Policy, error) {
+	allowed, err := compile(spec.AllowedEvents)
+	if err != nil {
+		return nil, fmt.Errorf("invalid allowedEvents: %w", err)
+	}
+	disallowed, err := compile(spec.DisallowedEvents)
+	if err != nil {
+		return nil, fmt.Errorf("invalid disallowedEvents: %w", err)
+	}
+	return &Policy{allowed: allowed, disallowed: disallowed}, nil
+}
+
+// Parse creates a Policy from the YAML content of an events file
+func Parse(data []byte) (*Policy, error) {
+	var spec Spec
+	if err := yaml.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("failed to parse events file: %w", err)
+	}
+	return New(spec)
+}
+
+// Load reads and parses the events file at filePath
+func Load(filePath string) (*Policy, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read events file: %w", err)
+	}
+	return Parse(data)
+}
+
+// Evaluate implements Evaluator
+func (p *Policy) Evaluate(eventType string) (bool, string) {
+	if matchAny(p.disallowed, eventType) {
+		return false, ReasonDisallowed
+	}
+	if len(p.allowed) > 0 && !matchAny(p.allowed, eventType) {
+		return false, ReasonNotAllowed
+	}
+	return true, ""
+}
+
+func compile(values []string) ([]pattern, error) {
+	patterns := make([]pattern, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		p := pattern{value: value, glob: strings.ContainsAny(value, "*?[")}
+		if p.glob {
+			if _, err := path.Match(value, ""); err != nil {
+				return nil, fmt.Errorf("pattern %q: %w", value, err)
+			}
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
+
+func matchAny(patterns []pattern, eventType string) bool {
+	for _, p := range patterns {
+		if p.match(eventType) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p pattern) match(eventType string) bool {
+	if p.glob {
+		// the pattern was validated when compiled
+		matched, _ := path.Match(p.value, eventType)
+		return matched
+	}
+	return eventType == p.value || strings.HasPrefix(eventType, p.value+".")
+}
@@ -0,0 +1,87 @@
+package eventspolicy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvaluate(t *testing.T) {
+	policy, err := Parse([]byte(`---
+allowedEvents:
+  - com.qlik.v1.analytics.*
+  - com.qlik.v1.app
+disallowedEvents:
+  - com.qlik.v1.analytics.debug.*
+  - com.qlik.v1.app.purged
+`))
+	require.NoError(t, err)
+
+	tests := []struct {
+		eventType string
+		allowed   bool
+		reason    string
+	}{
+		{"com.qlik.v1.analytics.sheet.viewed", true, ""},
+		{"com.qlik.v1.app", true, ""},
+		{"com.qlik.v1.app.created", true, ""},
+		{"com.qlik.v1.application.created", false, ReasonNotAllowed},
+		{"com.qlik.v1.audit.purged", false, ReasonNotAllowed},
+		{"com.qlik.v1.analytics.debug.trace", false, ReasonDisallowed},
+		{"com.qlik.v1.app.purged", false, ReasonDisallowed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.eventType, func(t *testing.T) {
+			allowed, reason := policy.Evaluate(test.eventType)
+			assert.Equal(t, test.allowed, allowed)
+			assert.Equal(t, test.reason, reason)
+		})
+	}
+}
+
+func TestEvaluate_EmptyAllowList(t *testing.T) {
+	policy, err := Parse([]byte("---\ndisallowedEvents:\n  - com.qlik.v1.audit.purged\n"))
+	require.NoError(t, err)
+
+	allowed, _ := policy.Evaluate("com.qlik.v1.analytics.sheet.viewed")
+	assert.True(t, allowed)
+	allowed, reason := policy.Evaluate("com.qlik.v1.audit.purged")
+	assert.False(t, allowed)
+	assert.Equal(t, ReasonDisallowed, reason)
+
+	empty, err := Parse([]byte("---\n"))
+	require.NoError(t, err)
+	allowed, _ = empty.Evaluate("com.qlik.v1.audit.purged")
+	assert.True(t, allowed)
+}
+
+func TestParse_Invalid(t *testing.T) {
+	_, err := Parse([]byte("allowedEvents: [com.qlik.v1.[analytics"))
+	require.Error(t, err)
+
+	_, err = Parse([]byte("allowedEvents:\n  - com.qlik.v1.[analytics\n"))
+	require.Error(t, err)
+}
+
+func TestLoad(t *testing.T) {
+	policy, err := Load("../../test/data/events-policy.yaml")
+	require.NoError(t, err)
+	allowed, _ := policy.Evaluate("com.qlik.v1.analytics.sheet.viewed")
+	assert.True(t, allowed)
+	allowed, reason := policy.Evaluate("com.qlik.v1.analytics.debug.trace")
+	assert.False(t, allowed)
+	assert.Equal(t, ReasonDisallowed, reason)
+
+	// the events file of docker-compose and local dev
+	policy, err = Load("../../test/data/events.yaml")
+	require.NoError(t, err)
+	allowed, _ = policy.Evaluate("com.qlik.v1.analytics.analytics-app-client.sheet-view.opened")
+	assert.True(t, allowed)
+
+	_, err = Load(filepath.Join(t.TempDir(), "missing.yaml"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,153 @@
+package eventspolicy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/qlik-trial/go-service-kit/v29/operation"
+)
+
+const (
+	// versionNone is the policy version reported while no events file exists
+	versionNone = "none"
+	// reloadDelay coalesces the burst of file events a single update produces, so partially written files are not read
+	reloadDelay = 250 * time.Millisecond
+)
+
+// Watcher is an Evaluator that reloads its Policy whenever the events file changes.
+// A file that cannot be parsed is rejected and the last good Policy stays in use.
+type Watcher struct {
+	filePath string
+	current  atomic.Pointer[versionedPolicy]
+	mu       sync.Mutex
+}
+
+type versionedPolicy struct {
+	policy  *Policy
+	version string
+}
+
+// NewWatcher creates a Watcher for the events file at filePath and loads its initial Policy
+func NewWatcher(filePath string) (*Watcher, error) {
+	w := &Watcher{filePath: filePath}
+	if _, err := w.reload(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Evaluate implements Evaluator using the most recently loaded Policy
+func (w *Watcher) Evaluate(eventType string) (bool, string) {
+	return w.current.Load().policy.Evaluate(eventType)
+}
+
+// Version returns a hash identifying the content of the loaded events file
+func (w *Watcher) Version() string {
+	return w.current.Load().version
+}
+
+// Start watches the events file until ctx is done.
+// The parent directory is watched since ConfigMap volumes update files by swapping symlinks.
+func (w *Watcher) Start(ctx context.Context) error {
+	label := "eventspolicy/Watcher/Start"
+	fsWatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create events file watcher: %w", err)
+	}
+	defer fsWatcher.Close() //revive:disable:unhandled-error
+
+	dir := filepath.Dir(w.filePath)
+	if err := fsWatcher.Add(dir); err != nil {
+		return fmt.Errorf("failed to watch events file directory %s: %w", dir, err)
+	}
+	operation.Logger(ctx).Info("label", label, "message", "watching events file", "eventsFilePath", w.filePath, "version", w.Version())
+
+	reloadTimer := time.NewTimer(reloadDelay)
+	reloadTimer.Stop()
+	defer reloadTimer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-fsWatcher.Events:
+			if !ok {
+				return nil
+			}
+			if event.Has(fsnotify.Chmod) {
+				continue
+			}
+			reloadTimer.Reset(reloadDelay)
+		case <-reloadTimer.C:
+			w.handleChange(ctx)
+		case err, ok := <-fsWatcher.Errors:
+			if !ok {
+				return nil
+			}
+			operation.Logger(ctx).Warn("label", label, "message", "events file watcher error", "error", err)
+		}
+	}
+}
+
+func (w *Watcher) handleChange(ctx context.Context) {
+	label := "eventspolicy/Watcher/handleChange"
+	previous := w.Version()
+	changed, err := w.reload()
+	if err != nil {
+		reloadsCounter.WithLabelValues(reloadResultFailure).Inc()
+		operation.Logger(ctx).Error("label", label, "message", "rejected events file, keeping last good policy", "error", err, "eventsFilePath", w.filePath, "version", previous)
+		return
+	}
+	if changed {
+		reloadsCounter.WithLabelValues(reloadResultSuccess).Inc()
+		operation.Logger(ctx).Info("label", label, "message", "events policy reloaded", "eventsFilePath", w.filePath, "previousVersion", previous, "version", w.Version())
+	}
+}
+
+// reload loads the events file and swaps in its Policy if the content changed.
+// A missing events file only allows every event before a Policy has been loaded.
+func (w *Watcher) reload() (bool, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	current := w.current.Load()
+	data, err := os.ReadFile(w.filePath)
+	if errors.Is(err, os.ErrNotExist) && current == nil {
+		w.swap(&versionedPolicy{policy: AllowAll(), version: versionNone})
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read events file: %w", err)
+	}
+
+	version := hash(data)
+	if current != nil && current.version == version {
+		return false, nil
+	}
+	policy, err := Parse(data)
+	if err != nil {
+		return false, err
+	}
+	w.swap(&versionedPolicy{policy: policy, version: version})
+	return true, nil
+}
+
+func (w *Watcher) swap(next *versionedPolicy) {
+	w.current.Store(next)
+	versionGauge.Reset()
+	versionGauge.WithLabelValues(next.version).Set(1)
+}
+
+func hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:12]
+}
@@ -0,0 +1,83 @@
+package eventspolicy
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatcher_Reload(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "events.yaml")
+	require.NoError(t, os.WriteFile(filePath, []byte("allowedEvents:\n  - com.qlik.v1.analytics\n"), 0o600))
+
+	w, err := NewWatcher(filePath)
+	require.NoError(t, err)
+	initialVersion := w.Version()
+	allowed, _ := w.Evaluate("com.qlik.v1.audit.purged")
+	assert.False(t, allowed)
+	assert.Equal(t, float64(1), testutil.ToFloat64(versionGauge.WithLabelValues(initialVersion)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- w.Start(ctx) }()
+	defer func() {
+		cancel()
+		require.NoError(t, <-done)
+	}()
+
+	// give the watcher time to register before changing the file
+	time.Sleep(100 * time.Millisecond)
+
+	require.NoError(t, os.WriteFile(filePath, []byte("allowedEvents: [com.qlik.v1.[audit\n"), 0o600))
+	time.Sleep(2 * reloadDelay)
+	assert.Equal(t, initialVersion, w.Version(), "malformed file must be rejected")
+
+	require.NoError(t, os.WriteFile(filePath, []byte("allowedEvents:\n  - com.qlik.v1.audit.*\n"), 0o600))
+	require.Eventually(t, func() bool {
+		allowed, _ := w.Evaluate("com.qlik.v1.audit.purged")
+		return allowed
+	}, 2*time.Second, 20*time.Millisecond)
+	assert.NotEqual(t, initialVersion, w.Version())
+	assert.Equal(t, float64(1), testutil.ToFloat64(versionGauge.WithLabelValues(w.Version())))
+}
+
+func TestWatcher_KeepsLastGoodPolicy(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "events.yaml")
+	require.NoError(t, os.WriteFile(filePath, []byte("disallowedEvents:\n  - com.qlik.v1.audit.purged\n"), 0o600))
+
+	w, err := NewWatcher(filePath)
+	require.NoError(t, err)
+	version := w.Version()
+
+	require.NoError(t, os.WriteFile(filePath, []byte("disallowedEvents:\n  - com.qlik.v1.[audit\n"), 0o600))
+	w.handleChange(context.Background())
+	assert.Equal(t, version, w.Version())
+
+	require.NoError(t, os.Remove(filePath))
+	w.handleChange(context.Background())
+	assert.Equal(t, version, w.Version())
+	allowed, reason := w.Evaluate("com.qlik.v1.audit.purged")
+	assert.False(t, allowed)
+	assert.Equal(t, ReasonDisallowed, reason)
+}
+
+func TestNewWatcher_MissingFile(t *testing.T) {
+	w, err := NewWatcher(filepath.Join(t.TempDir(), "events.yaml"))
+	require.NoError(t, err)
+	assert.Equal(t, versionNone, w.Version())
+	allowed, _ := w.Evaluate("com.qlik.v1.audit.purged")
+	assert.True(t, allowed)
+}
+
+func TestNewWatcher_MalformedFile(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "events.yaml")
+	require.NoError(t, os.WriteFile(filePath, []byte("allowedEvents: [com.qlik.v1.[audit\n"), 0o600))
+	_, err := NewWatcher(filePath)
+	require.Error(t, err)
+}
@@ -8,12 +8,15 @@ import (
 
 const (
 	EventIngestionFlag = "usage-telemetry-event-ingestion"
+	// ScrubPolicyOverlayFlag names the scrub policy overlay applied to a tenant's events, empty for none
+	ScrubPolicyOverlayFlag = "usage-telemetry-scrub-policy-overlay"
 	//PhasedRolloutFlag  = "TLV_x_USAGE_TELEMETRY_PUBLISHER"
 )
 
 type FeaturesClient interface {
 	GetBoolGlobalFeature(ctx context.Context, featureFlag string, contextOptions ...gskFeatures.ContextOption) (value bool, err error)
 	GetBoolTenantFeature(ctx context.Context, featureFlag, tenantID string, contextOptions ...gskFeatures.ContextOption) (value bool, err error)
+	GetStringTenantFeature(ctx context.Context, featureFlag, tenantID string, contextOptions ...gskFeatures.ContextOption) (value string, err error)
 	Initialized() bool
 }
 
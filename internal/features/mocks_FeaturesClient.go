@@ -206,6 +206,93 @@ func (_c *MockFeaturesClient_GetBoolTenantFeature_Call) RunAndReturn(run func(ct
 	return _c
 }
 
+// GetStringTenantFeature provides a mock function for the type MockFeaturesClient
+func (_mock *MockFeaturesClient) GetStringTenantFeature(ctx context.Context, featureFlag string, tenantID string, contextOptions ...features.ContextOption) (string, error) {
+	var tmpRet mock.Arguments
+	if len(contextOptions) > 0 {
+		tmpRet = _mock.Called(ctx, featureFlag, tenantID, contextOptions)
+	} else {
+		tmpRet = _mock.Called(ctx, featureFlag, tenantID)
+	}
+	ret := tmpRet
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetStringTenantFeature")
+	}
+
+	var r0 string
+	var r1 error
+	if returnFunc, ok := ret.Get(0).(func(context.Context, string, string, ...features.ContextOption) (string, error)); ok {
+		return returnFunc(ctx, featureFlag, tenantID, contextOptions...)
+	}
+	if returnFunc, ok := ret.Get(0).(func(context.Context, string, string, ...features.ContextOption) string); ok {
+		r0 = returnFunc(ctx, featureFlag, tenantID, contextOptions...)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+	if returnFunc, ok := ret.Get(1).(func(context.Context, string, string, ...features.ContextOption) error); ok {
+		r1 = returnFunc(ctx, featureFlag, tenantID, contextOptions...)
+	} else {
+		r1 = ret.Error(1)
+	}
+	return r0, r1
+}
+
+// MockFeaturesClient_GetStringTenantFeature_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'GetStringTenantFeature'
+type MockFeaturesClient_GetStringTenantFeature_Call struct {
+	*mock.Call
+}
+
+// GetStringTenantFeature is a helper method to define mock.On call
+//   - ctx context.Context
+//   - featureFlag string
+//   - tenantID string
+//   - contextOptions ...features.ContextOption
+func (_e *MockFeaturesClient_Expecter) GetStringTenantFeature(ctx interface{}, featureFlag interface{}, tenantID interface{}, contextOptions ...interface{}) *MockFeaturesClient_GetStringTenantFeature_Call {
+	return &MockFeaturesClient_GetStringTenantFeature_Call{Call: _e.mock.On("GetStringTenantFeature",
+		append([]interface{}{ctx, featureFlag, tenantID}, contextOptions...)...)}
+}
+
+func (_c *MockFeaturesClient_GetStringTenantFeature_Call) Run(run func(ctx context.Context, featureFlag string, tenantID string, contextOptions ...features.ContextOption)) *MockFeaturesClient_GetStringTenantFeature_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		var arg0 context.Context
+		if args[0] != nil {
+			arg0 = args[0].(context.Context)
+		}
+		var arg1 string
+		if args[1] != nil {
+			arg1 = args[1].(string)
+		}
+		var arg2 string
+		if args[2] != nil {
+			arg2 = args[2].(string)
+		}
+		var arg3 []features.ContextOption
+		var variadicArgs []features.ContextOption
+		if len(args) > 3 {
+			variadicArgs = args[3].([]features.ContextOption)
+		}
+		arg3 = variadicArgs
+		run(
+			arg0,
+			arg1,
+			arg2,
+			arg3...,
+		)
+	})
+	return _c
+}
+
+func (_c *MockFeaturesClient_GetStringTenantFeature_Call) Return(value string, err error) *MockFeaturesClient_GetStringTenantFeature_Call {
+	_c.Call.Return(value, err)
+	return _c
+}
+
+func (_c *MockFeaturesClient_GetStringTenantFeature_Call) RunAndReturn(run func(ctx context.Context, featureFlag string, tenantID string, contextOptions ...features.ContextOption) (string, error)) *MockFeaturesClient_GetStringTenantFeature_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // Initialized provides a mock function for the type MockFeaturesClient
 func (_mock *MockFeaturesClient) Initialized() bool {
 	ret := _mock.Called()
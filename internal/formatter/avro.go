@@ -0,0 +1,269 @@
+package formatter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/avro"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/model"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/parquet"
+)
+
+const (
+	avroName      = "ScrubbedEvent"
+	avroNamespace = "com.qlik.usage_telemetry_publisher"
+	// avroDimension is the map field holding the dimension.* keys of records, without their prefix
+	avroDimension   = "dimension"
+	dimensionPrefix = "dimension."
+)
+
+// avroEnvelope are the record keys of the required string fields the schema starts with
+var avroEnvelope = []string{"idempotencyKey", "eventName", "timestamp", "customerId"}
+
+// avroTypes are the types of values in the order of the branches of unions
+var avroTypes = []string{"null", "boolean", "long", "double", "string"}
+
+// avroFieldName matches the names Avro allows for fields, other record keys are kept in the dimension map
+var avroFieldName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// avroEncoding writes the records of a batch as an Avro Object Container File. The schema has the envelope fields,
+// an optional field for every other top level key and a dimension map. Optional fields and the map values are unions
+// of null and the types of the values written so far, so schemas only ever add fields and union branches,
+// and readers can resolve every earlier schema against a later one.
+// Every schema is registered, its fingerprint is in the metadata of the files written with it.
+type avroEncoding struct {
+	opts     Options
+	registry *avro.Registry
+
+	mu sync.Mutex
+	// fields are the types of the optional fields, dimension the types of the dimension map values
+	fields    map[string]map[string]bool
+	dimension map[string]bool
+}
+
+type avroSchema struct {
+	Type      string      `json:"type"`
+	Name      string      `json:"name"`
+	Namespace string      `json:"namespace"`
+	Fields    []avroField `json:"fields"`
+}
+
+type avroField struct {
+	Name    string          `json:"name"`
+	Type    any             `json:"type"`
+	Default json.RawMessage `json:"default,omitempty"`
+}
+
+type avroMap struct {
+	Type   string   `json:"type"`
+	Values []string `json:"values"`
+}
+
+// newAvroEncoding creates an avroEncoding continuing from the union of the schemas of registry, so it writes schemas
+// that the files of earlier runs and of other replicas sharing the registry can be resolved against.
+// The union is registered as the latest schema.
+func newAvroEncoding(opts Options, registry *avro.Registry) (*avroEncoding, error) {
+	e := &avroEncoding{opts: opts, registry: registry, fields: map[string]map[string]bool{}, dimension: map[string]bool{}}
+	schemas := registry.Schemas()
+	if len(schemas) == 0 {
+		return e, nil
+	}
+	for _, registered := range schemas {
+		if err := e.add(registered); err != nil {
+			return nil, fmt.Errorf("invalid avro schema %s: %w", registered.Fingerprint, err)
+		}
+	}
+	schema, err := json.Marshal(e.schema())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := registry.Register(schema); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// add adds the types of the fields of a registered schema
+func (e *avroEncoding) add(registered avro.Schema) error {
+	var schema struct {
+		Fields []struct {
+			Name string          `json:"name"`
+			Type json.RawMessage `json:"type"`
+		} `json:"fields"`
+	}
+	if err := json.Unmarshal(registered.Schema, &schema); err != nil {
+		return err
+	}
+	for _, field := range schema.Fields {
+		var types []string
+		switch {
+		case slices.Contains(avroEnvelope, field.Name):
+			continue
+		case field.Name == avroDimension:
+			var values avroMap
+			if err := json.Unmarshal(field.Type, &values); err != nil {
+				return err
+			}
+			types = values.Values
+		default:
+			if err := json.Unmarshal(field.Type, &types); err != nil {
+				return err
+			}
+		}
+		for _, t := range types {
+			e.types(field.Name)[t] = true
+		}
+	}
+	return nil
+}
+
+func (e *avroEncoding) Encode(w io.Writer, events []*model.ScrubbedEvent) ([]*RecordError, error) {
+	records, rejected := e.opts.records(events)
+
+	// the schema must not change between registering it and encoding the records with it
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, record := range records {
+		for key, value := range record {
+			if !slices.Contains(avroEnvelope, key) {
+				e.types(avroKey(key))[avroType(value)] = true
+			}
+		}
+	}
+	schema, err := json.Marshal(e.schema())
+	if err != nil {
+		return rejected, err
+	}
+	registered, err := e.registry.Register(schema)
+	if err != nil {
+		return rejected, err
+	}
+
+	encoded := make([][]byte, len(records))
+	for i, record := range records {
+		encoded[i] = e.encode(record)
+	}
+	return rejected, avro.WriteFile(w, registered.Schema, map[string]string{avro.SchemaFingerprintKey: registered.Fingerprint}, encoded)
+}
+
+func (*avroEncoding) ContentType() string   { return "application/vnd.apache.avro+binary" }
+func (*avroEncoding) FileExtension() string { return ".avro" }
+func (*avroEncoding) Concatenable() bool    { return false }
+
+// types returns the types of the values of an optional field or of the dimension map. e.mu must be held.
+func (e *avroEncoding) types(field string) map[string]bool {
+	if field == avroDimension {
+		return e.dimension
+	}
+	if e.fields[field] == nil {
+		e.fields[field] = map[string]bool{}
+	}
+	return e.fields[field]
+}
+
+// schema returns the schema of the types written so far. e.mu must be held.
+func (e *avroEncoding) schema() avroSchema {
+	schema := avroSchema{Type: "record", Name: avroName, Namespace: avroNamespace}
+	for _, key := range avroEnvelope {
+		schema.Fields = append(schema.Fields, avroField{Name: key, Type: "string"})
+	}
+	for _, key := range slices.Sorted(maps.Keys(e.fields)) {
+		schema.Fields = append(schema.Fields, avroField{Name: key, Type: union(e.fields[key]), Default: json.RawMessage("null")})
+	}
+	schema.Fields = append(schema.Fields, avroField{
+		Name:    avroDimension,
+		Type:    avroMap{Type: "map", Values: union(e.dimension)},
+		Default: json.RawMessage("{}"),
+	})
+	return schema
+}
+
+// encode returns the binary encoding of a record with the schema. e.mu must be held.
+func (e *avroEncoding) encode(record map[string]any) []byte {
+	var buf []byte
+	for _, key := range avroEnvelope {
+		buf = avro.AppendString(buf, cell(record[key]))
+	}
+	for _, key := range slices.Sorted(maps.Keys(e.fields)) {
+		buf = appendAvroValue(buf, union(e.fields[key]), record[key])
+	}
+
+	var dimensions []string
+	for key := range record {
+		if avroKey(key) == avroDimension {
+			dimensions = append(dimensions, key)
+		}
+	}
+	slices.Sort(dimensions)
+	if len(dimensions) > 0 {
+		buf = avro.AppendLong(buf, int64(len(dimensions)))
+		branches := union(e.dimension)
+		for _, key := range dimensions {
+			buf = avro.AppendString(buf, strings.TrimPrefix(key, dimensionPrefix))
+			buf = appendAvroValue(buf, branches, record[key])
+		}
+	}
+	return avro.AppendLong(buf, 0)
+}
+
+// avroKey returns the field of a record key, dimension.* keys and keys that are not valid field names are in the
+// dimension map
+func avroKey(key string) string {
+	if strings.HasPrefix(key, dimensionPrefix) || !avroFieldName.MatchString(key) {
+		return avroDimension
+	}
+	return key
+}
+
+// avroType returns the type of a value, values get the same types as in Parquet files
+func avroType(value any) string {
+	if value == nil {
+		return "null"
+	}
+	switch parquetType("", value) {
+	case parquet.Boolean:
+		return "boolean"
+	case parquet.Int64:
+		return "long"
+	case parquet.Double:
+		return "double"
+	default:
+		return "string"
+	}
+}
+
+// union returns the branches of a union of null and types
+func union(types map[string]bool) []string {
+	branches := []string{"null"}
+	for _, t := range avroTypes[1:] {
+		if types[t] {
+			branches = append(branches, t)
+		}
+	}
+	return branches
+}
+
+// appendAvroValue appends the branch index and encoding of a value of a union with branches
+func appendAvroValue(buf []byte, branches []string, value any) []byte {
+	t := avroType(value)
+	buf = avro.AppendLong(buf, int64(slices.Index(branches, t)))
+	switch t {
+	case "boolean":
+		return avro.AppendBoolean(buf, parquetValue(parquet.Boolean, value).(bool))
+	case "long":
+		return avro.AppendLong(buf, parquetValue(parquet.Int64, value).(int64))
+	case "double":
+		return avro.AppendDouble(buf, parquetValue(parquet.Double, value).(float64))
+	case "string":
+		return avro.AppendString(buf, cell(value))
+	default:
+		return buf
+	}
+}
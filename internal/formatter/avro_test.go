@@ -0,0 +1,170 @@
+package formatter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/avro"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// avroReader decodes the binary encoding of Avro
+type avroReader struct {
+	buf []byte
+	pos int
+}
+
+func (r *avroReader) long() int64 {
+	v, n := binary.Varint(r.buf[r.pos:])
+	r.pos += n
+	return v
+}
+
+func (r *avroReader) string() string {
+	n := int(r.long())
+	r.pos += n
+	return string(r.buf[r.pos-n : r.pos])
+}
+
+func (r *avroReader) value(branches []string) any {
+	switch branches[r.long()] {
+	case "boolean":
+		r.pos++
+		return r.buf[r.pos-1] == 1
+	case "long":
+		return r.long()
+	case "double":
+		r.pos += 8
+		return math.Float64frombits(binary.LittleEndian.Uint64(r.buf[r.pos-8:]))
+	case "string":
+		return r.string()
+	default:
+		return nil
+	}
+}
+
+// readAvroFile returns the metadata of a file with a single block and the remaining bytes of the block
+func readAvroFile(t *testing.T, file []byte) (map[string]string, *avroReader) {
+	t.Helper()
+	r := &avroReader{buf: file, pos: 4}
+	require.Equal(t, "Obj\x01", string(file[:4]))
+	metadata := map[string]string{}
+	for n := r.long(); n != 0; n = r.long() {
+		for range n {
+			metadata[r.string()] = r.string()
+		}
+	}
+	r.pos += 16 // sync marker
+	r.long()    // records
+	r.long()    // size
+	return metadata, r
+}
+
+func TestAvroEncoding(t *testing.T) {
+	registry, err := avro.OpenRegistry("")
+	require.NoError(t, err)
+	encoding, err := NewEncoding(EncodingOptions{Format: FormatAvro, Flatten: DefaultOptions(), AvroSchemas: registry})
+	require.NoError(t, err)
+
+	event := &model.ScrubbedEvent{
+		Id:       "1",
+		Type:     "com.qlik.v1.some_event",
+		Time:     "2023-10-01T12:00:00Z",
+		TenantId: "tenant_123",
+		Data:     map[string]any{"count": 3.0, "enabled": true},
+	}
+	file := encode(t, encoding, []*model.ScrubbedEvent{event})
+
+	latest, ok := registry.Latest()
+	require.True(t, ok)
+	assert.JSONEq(t, `{
+		"type": "record",
+		"name": "ScrubbedEvent",
+		"namespace": "com.qlik.usage_telemetry_publisher",
+		"fields": [
+			{"name": "idempotencyKey", "type": "string"},
+			{"name": "eventName", "type": "string"},
+			{"name": "timestamp", "type": "string"},
+			{"name": "customerId", "type": "string"},
+			{"name": "dimension", "type": {"type": "map", "values": ["null", "boolean", "long"]}, "default": {}}
+		]
+	}`, string(latest.Schema))
+
+	metadata, r := readAvroFile(t, file)
+	assert.Equal(t, string(latest.Schema), metadata["avro.schema"])
+	assert.Equal(t, latest.Fingerprint, metadata[avro.SchemaFingerprintKey])
+	assert.Equal(t, []string{"1", "com.qlik.v1.some_event", "2023-10-01T12:00:00Z", "tenant_123"}, []string{r.string(), r.string(), r.string(), r.string()})
+	require.Equal(t, int64(2), r.long())
+	branches := []string{"null", "boolean", "long"}
+	assert.Equal(t, "data.count", r.string())
+	assert.Equal(t, int64(3), r.value(branches))
+	assert.Equal(t, "data.enabled", r.string())
+	assert.Equal(t, true, r.value(branches))
+	assert.Equal(t, int64(0), r.long())
+
+	first := latest
+	t.Run("additive changes register a new schema", func(t *testing.T) {
+		event := *event
+		event.UserId = "user-1"
+		event.Data = map[string]any{"count": 1.5}
+		file := encode(t, encoding, []*model.ScrubbedEvent{&event})
+
+		latest, _ := registry.Latest()
+		assert.NotEqual(t, first.Fingerprint, latest.Fingerprint)
+		metadata, r := readAvroFile(t, file)
+		assert.Equal(t, latest.Fingerprint, metadata[avro.SchemaFingerprintKey])
+		assert.Contains(t, metadata["avro.schema"], `{"name":"userId","type":["null","string"],"default":null}`)
+		assert.Contains(t, metadata["avro.schema"], `"values":["null","boolean","long","double"]`)
+
+		for range 4 {
+			r.string()
+		}
+		assert.Equal(t, "user-1", r.value([]string{"null", "string"}))
+		require.Equal(t, int64(1), r.long())
+		assert.Equal(t, "data.count", r.string())
+		assert.Equal(t, 1.5, r.value([]string{"null", "boolean", "long", "double"}))
+	})
+
+	t.Run("continues from the registered schemas", func(t *testing.T) {
+		previous, _ := registry.Latest()
+		restarted, err := NewEncoding(EncodingOptions{Format: FormatAvro, Flatten: DefaultOptions(), AvroSchemas: registry})
+		require.NoError(t, err)
+		encode(t, restarted, []*model.ScrubbedEvent{event})
+		latest, _ := registry.Latest()
+		assert.Equal(t, previous, latest)
+		assert.Len(t, registry.Schemas(), 2)
+	})
+}
+
+func TestAvroEncoding_SharedRegistry(t *testing.T) {
+	dir := t.TempDir()
+	open := func() (*avro.Registry, Encoding) {
+		registry, err := avro.OpenRegistry(dir)
+		require.NoError(t, err)
+		encoding, err := NewEncoding(EncodingOptions{Format: FormatAvro, Flatten: DefaultOptions(), AvroSchemas: registry})
+		require.NoError(t, err)
+		return registry, encoding
+	}
+	event := func(data map[string]any) []*model.ScrubbedEvent {
+		return []*model.ScrubbedEvent{{Id: "1", Type: "com.qlik.v1.some_event", Data: data}}
+	}
+
+	// replicas running at the same time register schemas of their own
+	_, replica := open()
+	_, other := open()
+	_, err := replica.Encode(&bytes.Buffer{}, event(map[string]any{"count": 3.0}))
+	require.NoError(t, err)
+	_, err = other.Encode(&bytes.Buffer{}, event(map[string]any{"enabled": true}))
+	require.NoError(t, err)
+
+	// a replica starting later writes their union
+	registry, _ := open()
+	assert.Len(t, registry.Schemas(), 3)
+	latest, ok := registry.Latest()
+	require.True(t, ok)
+	assert.Contains(t, string(latest.Schema), `"values":["null","boolean","long"]`)
+}
@@ -0,0 +1,61 @@
+package formatter
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"maps"
+	"slices"
+
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/model"
+)
+
+// WriteCSV formats the events as CSV with DefaultOptions
+func WriteCSV(w io.Writer, events []*model.ScrubbedEvent) ([]*RecordError, error) {
+	return DefaultOptions().WriteCSV(w, events)
+}
+
+// WriteCSV writes the records of the events as RFC 4180 CSV to w. The header is the sorted union of the keys of all
+// records, so it only describes this batch. Records without a key have an empty cell, nested objects and arrays are
+// JSON encoded strings. No events write nothing, not even the header.
+// Events that cannot be encoded are left out and returned, the error is an error of w.
+func (o Options) WriteCSV(w io.Writer, events []*model.ScrubbedEvent) ([]*RecordError, error) {
+	records, rejected := o.records(events)
+	if len(records) == 0 {
+		return rejected, nil
+	}
+
+	columns := make(map[string]struct{})
+	for _, record := range records {
+		for key := range record {
+			columns[key] = struct{}{}
+		}
+	}
+	header := slices.Sorted(maps.Keys(columns))
+
+	cw := csv.NewWriter(w)
+	cw.UseCRLF = true
+	_ = cw.Write(header) // errors of w are returned by Error once flushed
+	row := make([]string, len(header))
+	for _, record := range records {
+		for i, key := range header {
+			row[i] = cell(record[key])
+		}
+		_ = cw.Write(row)
+	}
+	cw.Flush()
+	return rejected, cw.Error()
+}
+
+// cell returns value as the content of a CSV cell
+func cell(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		b, _ := json.Marshal(v) // Records only returns values that encode
+		return string(b)
+	}
+}
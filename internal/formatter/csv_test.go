@@ -0,0 +1,56 @@
+package formatter
+
+import (
+	"encoding/csv"
+	"strings"
+	"testing"
+
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteCSV(t *testing.T) {
+	events := []*model.ScrubbedEvent{
+		{
+			Id:       "1",
+			Type:     "com.qlik.v1.some_event",
+			Time:     "2023-10-01T12:00:00Z",
+			TenantId: "tenant_123",
+			Data: map[string]any{
+				"name":  "say \"hi\", then\nleave",
+				"count": 3.0,
+				"items": []any{map[string]any{"id": "a"}},
+			},
+		},
+		{
+			Id:       "2",
+			Type:     "com.qlik.v1.other_event",
+			Time:     "2023-10-01T12:00:01Z",
+			TenantId: "tenant_123",
+			UserId:   "user-1",
+			Data:     map[string]any{"enabled": true},
+		},
+	}
+
+	expected := "customerId,dimension.data.count,dimension.data.enabled,dimension.data.items,dimension.data.name,eventName,idempotencyKey,timestamp,userId\r\n" +
+		"tenant_123,3,,\"[{\"\"id\"\":\"\"a\"\"}]\",\"say \"\"hi\"\", then\r\nleave\",com.qlik.v1.some_event,1,2023-10-01T12:00:00Z,\r\n" +
+		"tenant_123,,true,,,com.qlik.v1.other_event,2,2023-10-01T12:00:01Z,user-1\r\n"
+	var b strings.Builder
+	rejected, err := WriteCSV(&b, events)
+	require.NoError(t, err)
+	assert.Empty(t, rejected)
+	assert.Equal(t, expected, b.String())
+
+	rows, err := csv.NewReader(strings.NewReader(b.String())).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 3)
+	assert.Equal(t, `[{"id":"a"}]`, rows[1][3])
+	assert.Equal(t, "say \"hi\", then\nleave", rows[1][4])
+
+	b.Reset()
+	rejected, err = WriteCSV(&b, nil)
+	require.NoError(t, err)
+	assert.Empty(t, rejected)
+	assert.Empty(t, b.String())
+}
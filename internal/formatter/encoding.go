@@ -0,0 +1,120 @@
+package formatter
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/avro"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/model"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/parquet"
+)
+
+// Formats selectable with EncodingOptions.Format
+const (
+	// FormatNDJSON writes one JSON object per line
+	FormatNDJSON = "ndjson"
+	// FormatCSV writes a header row followed by one row per record
+	FormatCSV = "csv"
+	// FormatParquet writes a Parquet file per batch
+	FormatParquet = "parquet"
+	// FormatAvro writes an Avro Object Container File per batch
+	FormatAvro = "avro"
+)
+
+// Encoding encodes batches of events into the payload a sink writes
+type Encoding interface {
+	// Encode writes the payload of a batch of events to w. Events that cannot be encoded are left out of the payload
+	// and returned, the error is an error of w or of writing the payload.
+	Encode(w io.Writer, events []*model.ScrubbedEvent) ([]*RecordError, error)
+	// ContentType is the media type of the payload
+	ContentType() string
+	// FileExtension is the extension of files holding payloads, including the dot
+	FileExtension() string
+	// Concatenable reports whether payloads can be appended to each other and still be read as one,
+	// payloads of other encodings have to be written to files of their own
+	Concatenable() bool
+}
+
+// EncodingOptions configure an Encoding
+type EncodingOptions struct {
+	// Format is FormatNDJSON, FormatCSV, FormatParquet or FormatAvro. It defaults to FormatNDJSON.
+	Format string
+	// Flatten configures how events are flattened into records
+	Flatten Options
+	// Parquet configures the files of FormatParquet
+	Parquet parquet.Options
+	// AvroSchemas keeps the schemas of FormatAvro, the schemas are only kept in memory when it is nil
+	AvroSchemas *avro.Registry
+}
+
+// NewEncoding returns the Encoding configured by opts
+func NewEncoding(opts EncodingOptions) (Encoding, error) {
+	if err := opts.Flatten.Validate(); err != nil {
+		return nil, err
+	}
+	switch opts.Format {
+	case "", FormatNDJSON:
+		return ndjsonEncoding{opts: opts.Flatten}, nil
+	case FormatCSV:
+		return csvEncoding{opts: opts.Flatten}, nil
+	case FormatParquet:
+		if err := opts.Parquet.Validate(); err != nil {
+			return nil, err
+		}
+		return newParquetEncoding(opts.Flatten, opts.Parquet), nil
+	case FormatAvro:
+		registry := opts.AvroSchemas
+		if registry == nil {
+			registry, _ = avro.OpenRegistry("") // a registry without persistence does not fail
+		}
+		return newAvroEncoding(opts.Flatten, registry)
+	default:
+		return nil, fmt.Errorf("unknown format %q", opts.Format)
+	}
+}
+
+// NDJSON returns the Encoding writing events as newline delimited JSON
+func NDJSON(opts Options) Encoding {
+	return ndjsonEncoding{opts: opts}
+}
+
+type ndjsonEncoding struct {
+	opts Options
+}
+
+func (e ndjsonEncoding) Encode(w io.Writer, events []*model.ScrubbedEvent) ([]*RecordError, error) {
+	encoder, err := NewEncoder(w, e.opts, CompressionNone)
+	if err != nil {
+		return nil, err
+	}
+	var rejected []*RecordError
+	for _, event := range events {
+		err := encoder.Encode(event)
+		var recordErr *RecordError
+		if errors.As(err, &recordErr) {
+			rejected = append(rejected, recordErr)
+		} else if err != nil {
+			return rejected, err
+		}
+	}
+	return rejected, encoder.Close()
+}
+
+func (ndjsonEncoding) ContentType() string   { return "application/x-ndjson" }
+func (ndjsonEncoding) FileExtension() string { return ".ndjson" }
+func (ndjsonEncoding) Concatenable() bool    { return true }
+
+type csvEncoding struct {
+	opts Options
+}
+
+func (e csvEncoding) Encode(w io.Writer, events []*model.ScrubbedEvent) ([]*RecordError, error) {
+	return e.opts.WriteCSV(w, events)
+}
+
+func (csvEncoding) ContentType() string   { return "text/csv; charset=utf-8; header=present" }
+func (csvEncoding) FileExtension() string { return ".csv" }
+
+// Concatenable is false, the header of each batch only describes the rows of that batch
+func (csvEncoding) Concatenable() bool { return false }
@@ -0,0 +1,61 @@
+package formatter
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/model"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/parquet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewEncoding(t *testing.T) {
+	ndjson, err := NewEncoding(EncodingOptions{Format: FormatNDJSON, Flatten: DefaultOptions()})
+	require.NoError(t, err)
+	assert.True(t, ndjson.Concatenable())
+	assert.Equal(t, ".ndjson", ndjson.FileExtension())
+	assert.Empty(t, encode(t, ndjson, nil))
+
+	csvEncoding, err := NewEncoding(EncodingOptions{Format: FormatCSV, Flatten: DefaultOptions()})
+	require.NoError(t, err)
+	assert.False(t, csvEncoding.Concatenable())
+	assert.Equal(t, ".csv", csvEncoding.FileExtension())
+
+	_, err = NewEncoding(EncodingOptions{Format: "xml"})
+	require.Error(t, err)
+	_, err = NewEncoding(EncodingOptions{Format: FormatCSV, Flatten: Options{Arrays: "rows"}})
+	require.Error(t, err)
+	_, err = NewEncoding(EncodingOptions{Format: FormatParquet, Parquet: parquet.Options{Compression: "lz4"}})
+	require.Error(t, err)
+}
+
+func TestEncoding_RejectsEvents(t *testing.T) {
+	valid := &model.ScrubbedEvent{Id: "1", Type: "com.qlik.v1.some_event", Data: map[string]any{"count": 1.0}}
+	invalid := &model.ScrubbedEvent{Id: "2", Type: "com.qlik.v1.some_event", Data: map[string]any{"count": math.NaN()}}
+
+	for _, format := range []string{FormatNDJSON, FormatCSV, FormatParquet, FormatAvro} {
+		t.Run(format, func(t *testing.T) {
+			encoding, err := NewEncoding(EncodingOptions{Format: format, Flatten: DefaultOptions()})
+			require.NoError(t, err)
+
+			var b bytes.Buffer
+			rejected, err := encoding.Encode(&b, []*model.ScrubbedEvent{invalid, valid})
+			require.NoError(t, err)
+			require.Len(t, rejected, 1)
+			assert.Same(t, invalid, rejected[0].Event)
+			assert.NotEmpty(t, b.Bytes())
+		})
+	}
+}
+
+// encode returns the events encoded with encoding, requiring that none is rejected
+func encode(t *testing.T, encoding Encoding, events []*model.ScrubbedEvent) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	rejected, err := encoding.Encode(&b, events)
+	require.NoError(t, err)
+	require.Empty(t, rejected)
+	return b.Bytes()
+}
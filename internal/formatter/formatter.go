@@ -2,28 +2,148 @@ package formatter
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
 
 	"github.com/qlik-trial/usage-telemetry-publisher/internal/model"
 )
 
-// flattenMap recursively flattens nested maps with a given prefix
-func flattenMap(data map[string]any, prefix string, result map[string]any) {
-	for key, value := range data {
-		fullKey := prefix + key
+// Array strategies selectable with Options.Arrays
+const (
+	// ArraysJSON writes arrays as JSON encoded strings under their key, dimension.data.items
+	ArraysJSON = "json"
+	// ArraysIndexed flattens array elements into keys holding their index, dimension.data.items.0.id
+	ArraysIndexed = "indexed"
+	// ArraysExplode writes one record per element of the first non-empty array in key order, the element is
+	// flattened under the key of the array. Other arrays are written as JSON encoded strings.
+	ArraysExplode = "explode"
+)
+
+// OverflowKey holds the dimensions past Options.MaxKeys, as a JSON encoded object of their keys and values
+const OverflowKey = "dimension.overflow"
+
+const (
+	dataPrefix       = "dimension.data"
+	extensionsPrefix = "dimension.extensions"
+)
+
+// Options configure how the data and extensions of events are flattened into dimensions
+type Options struct {
+	// Arrays is the array strategy, ArraysJSON, ArraysIndexed or ArraysExplode. It defaults to ArraysJSON.
+	Arrays string
+	// MaxDepth caps the number of path segments of a dimension key below dimension.data,
+	// deeper objects and arrays are written as JSON encoded strings. 0 does not cap the depth.
+	MaxDepth int
+	// MaxKeys caps the number of dimensions of a record, the remaining dimensions are moved to OverflowKey.
+	// 0 does not cap the number of dimensions.
+	MaxKeys int
+}
+
+// DefaultOptions returns the Options of Flatten and Write
+func DefaultOptions() Options {
+	return Options{Arrays: ArraysJSON, MaxDepth: 10, MaxKeys: 250}
+}
+
+// Validate returns an error for options with an unknown array strategy or negative limits
+func (o Options) Validate() error {
+	if !slices.Contains([]string{"", ArraysJSON, ArraysIndexed, ArraysExplode}, o.Arrays) {
+		return fmt.Errorf("unknown array strategy %q", o.Arrays)
+	}
+	if o.MaxDepth < 0 || o.MaxKeys < 0 {
+		return errors.New("flatten limits must not be negative")
+	}
+	return nil
+}
+
+// setIfNotEmpty adds value to the result unless it is empty
+func setIfNotEmpty(flattened map[string]any, key, value string) {
+	if value != "" {
+		flattened[key] = value
+	}
+}
+
+// RecordError is returned for an event whose records cannot be encoded, such as data holding NaN or a channel.
+// The event is left out of the output and encoding it again fails the same way.
+type RecordError struct {
+	Event *model.ScrubbedEvent
+	Err   error
+}
+
+func (e *RecordError) Error() string {
+	return fmt.Sprintf("failed to encode event %s: %v", e.Event.Id, e.Err)
+}
 
-		if nestedMap, ok := value.(map[string]any); ok {
-			// Recursively flatten nested maps
-			flattenMap(nestedMap, fullKey+".", result)
-		} else {
-			// Add the leaf value to the result
-			result[fullKey] = value
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
+// Flatten takes a CloudEvent and returns a flattened representation of its data with DefaultOptions.
+// data should be flattened into dimension single level (dimension.data.<key>),
+// extension attributes into dimension.extensions.<name>
+func Flatten(event *model.ScrubbedEvent) (string, error) {
+	records, err := DefaultOptions().Records(event)
+	if err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(records[0])
+	if err != nil {
+		return "", &RecordError{Event: event, Err: err}
+	}
+	return string(b), nil
+}
+
+// Records flattens an event into the records published for it, which is a single record unless arrays are exploded.
+// Object keys are visited in sorted order, so records are the same every time an event is flattened:
+// when a key containing a dot collides with the key of a nested value, the key visited last gets a ~2 suffix, then ~3,
+// and the same dimensions overflow MaxKeys.
+// It returns a *RecordError for values that cannot be encoded as JSON, so the values of records always can.
+func (o Options) Records(event *model.ScrubbedEvent) ([]map[string]any, error) {
+	if o.Arrays == ArraysExplode {
+		f := &flattener{opts: o}
+		if path, elements, found := f.findArray(dataPrefix, 0, event.Data); found {
+			records := make([]map[string]any, 0, len(elements))
+			for i, element := range elements {
+				record, err := newRecord(event, &flattener{opts: o, explodePath: path, element: element})
+				if err != nil {
+					return nil, err
+				}
+				record["idempotencyKey"] = event.Id + "#" + strconv.Itoa(i)
+				record["rowIndex"] = i
+				records = append(records, record)
+			}
+			return records, nil
 		}
 	}
+	record, err := newRecord(event, &flattener{opts: o})
+	if err != nil {
+		return nil, err
+	}
+	return []map[string]any{record}, nil
 }
 
-// Flatten takes a CloudEvent and returns a flattened representation of its data.
-// data should be flattened into dimension single level (dimension.data.<key>)
-func Flatten(event *model.ScrubbedEvent) string {
+// records returns the records of the events, leaving out the events whose records cannot be encoded
+func (o Options) records(events []*model.ScrubbedEvent) ([]map[string]any, []*RecordError) {
+	var records []map[string]any
+	var rejected []*RecordError
+	for _, event := range events {
+		eventRecords, err := o.Records(event)
+		var recordErr *RecordError
+		if errors.As(err, &recordErr) {
+			rejected = append(rejected, recordErr)
+			continue
+		}
+		records = append(records, eventRecords...)
+	}
+	return records, rejected
+}
+
+// newRecord returns the record of an event, flattening its data and extensions with f
+func newRecord(event *model.ScrubbedEvent, f *flattener) (map[string]any, error) {
 	flattened := make(map[string]any)
 
 	// set standard values
@@ -32,9 +152,169 @@ func Flatten(event *model.ScrubbedEvent) string {
 	flattened["timestamp"] = event.Time
 	flattened["customerId"] = event.TenantId
 
-	// Recursively flatten the event data
-	flattenMap(event.Data, "dimension.data.", flattened)
+	// identifiers are only set when the scrub policy keeps them, pseudonymized or not
+	setIfNotEmpty(flattened, "userId", event.UserId)
+	setIfNotEmpty(flattened, "sessionId", event.SessionId)
+	setIfNotEmpty(flattened, "ownerId", event.OwnerId)
+	setIfNotEmpty(flattened, "pseudonymKeyId", event.PseudonymKeyId)
+	setIfNotEmpty(flattened, "scrubPolicyVersion", event.ScrubPolicyVersion)
+
+	f.record = flattened
+	f.object(dataPrefix, 0, event.Data)
+	f.object(extensionsPrefix, 0, event.Extensions)
+	if len(f.overflow) > 0 {
+		// overflowing values were checked by set, so the overflow always encodes
+		flattened[OverflowKey] = f.encode(OverflowKey, f.overflow)
+	}
+	if f.err != nil {
+		return nil, &RecordError{Event: event, Err: f.err}
+	}
+	return flattened, nil
+}
+
+// flattener flattens the values of an event into the dimensions of a record
+type flattener struct {
+	opts       Options
+	record     map[string]any
+	dimensions int
+	overflow   map[string]any
+	// err is the first value that cannot be encoded
+	err error
+	// explodePath is the key of the array exploded into records, element is the element of this record
+	explodePath string
+	element     any
+}
+
+// object flattens the entries of a top level object, an empty object has no dimensions
+func (f *flattener) object(prefix string, depth int, object map[string]any) {
+	for _, key := range slices.Sorted(maps.Keys(object)) {
+		f.value(prefix+"."+key, depth+1, object[key])
+	}
+}
+
+// value flattens the value at key, which has depth path segments
+func (f *flattener) value(key string, depth int, value any) {
+	if f.explodePath != "" && key == f.explodePath {
+		f.explodePath = "" // the element is flattened in place of the array once
+		f.value(key, depth, f.element)
+		return
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		if len(v) == 0 || f.atMaxDepth(depth) {
+			f.set(key, f.encode(key, v))
+			return
+		}
+		f.object(key, depth, v)
+	default:
+		elements, ok := toSlice(value)
+		if !ok {
+			f.set(key, f.scalar(key, value))
+			return
+		}
+		if len(elements) == 0 || f.opts.Arrays != ArraysIndexed || f.atMaxDepth(depth) {
+			f.set(key, f.encode(key, value))
+			return
+		}
+		for i, element := range elements {
+			f.value(key+"."+strconv.Itoa(i), depth+1, element)
+		}
+	}
+}
+
+// findArray returns the key and elements of the first non-empty array in key order that is not deeper than MaxDepth
+func (f *flattener) findArray(prefix string, depth int, object map[string]any) (string, []any, bool) {
+	if f.atMaxDepth(depth + 1) {
+		return "", nil, false
+	}
+	for _, key := range slices.Sorted(maps.Keys(object)) {
+		switch v := object[key].(type) {
+		case map[string]any:
+			if path, elements, found := f.findArray(prefix+"."+key, depth+1, v); found {
+				return path, elements, true
+			}
+		default:
+			if elements, ok := toSlice(v); ok && len(elements) > 0 {
+				return prefix + "." + key, elements, true
+			}
+		}
+	}
+	return "", nil, false
+}
+
+func (f *flattener) atMaxDepth(depth int) bool {
+	return f.opts.MaxDepth > 0 && depth >= f.opts.MaxDepth
+}
+
+// set adds a dimension to the record, resolving collisions with a suffix and moving it to the overflow past MaxKeys
+func (f *flattener) set(key string, value any) {
+	resolved := key
+	for n := 2; f.exists(resolved); n++ {
+		resolved = key + "~" + strconv.Itoa(n)
+	}
+	if f.opts.MaxKeys > 0 && f.dimensions >= f.opts.MaxKeys {
+		if f.overflow == nil {
+			f.overflow = map[string]any{}
+		}
+		f.overflow[resolved] = value
+		return
+	}
+	f.record[resolved] = value
+	f.dimensions++
+}
+
+func (f *flattener) exists(key string) bool {
+	if _, ok := f.record[key]; ok {
+		return true
+	}
+	_, ok := f.overflow[key]
+	return ok
+}
+
+// scalar returns a value that is not an object or array, recording an error if it cannot be encoded as JSON
+func (f *flattener) scalar(key string, value any) any {
+	switch v := value.(type) {
+	case nil, string, bool, int, int64:
+		return value
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			f.fail(key, fmt.Errorf("unsupported value %v", v))
+		}
+		return value
+	default:
+		f.encode(key, value)
+		return value
+	}
+}
 
-	b, _ := json.Marshal(flattened)
+// encode returns value as a JSON encoded string, recording an error if it cannot be encoded
+func (f *flattener) encode(key string, value any) string {
+	b, err := json.Marshal(value)
+	if err != nil {
+		f.fail(key, err)
+	}
 	return string(b)
 }
+
+func (f *flattener) fail(key string, err error) {
+	if f.err == nil {
+		f.err = fmt.Errorf("invalid value at %s: %w", key, err)
+	}
+}
+
+// toSlice returns the elements of a slice value, []byte excluded
+func toSlice(value any) ([]any, bool) {
+	if elements, ok := value.([]any); ok {
+		return elements, true
+	}
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Slice || v.Type().Elem().Kind() == reflect.Uint8 {
+		return nil, false
+	}
+	elements := make([]any, v.Len())
+	for i := range elements {
+		elements[i] = v.Index(i).Interface()
+	}
+	return elements, true
+}
@@ -1,9 +1,12 @@
 package formatter
 
 import (
+	"math"
 	"testing"
 
 	"github.com/qlik-trial/usage-telemetry-publisher/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestShouldFlattenEvent(t *testing.T) {
@@ -48,6 +51,17 @@ func TestShouldFlattenEvent(t *testing.T) {
 			},
 			expected: `{"customerId":"tenant_123","dimension.data.foo.bar":"baz","eventName":"com.qlik.v1.some_event","idempotencyKey":"12345","timestamp":"2023-10-01T12:00:00Z"}`,
 		},
+		{
+			event: &model.ScrubbedEvent{
+				Id:         "12345",
+				Type:       "com.qlik.v1.some_event",
+				Time:       "2023-10-01T12:00:00Z",
+				TenantId:   "tenant_123",
+				Data:       map[string]any{"foo": "bar"},
+				Extensions: map[string]any{"traceparent": "00-abc-01", "sequence": 7},
+			},
+			expected: `{"customerId":"tenant_123","dimension.data.foo":"bar","dimension.extensions.sequence":7,"dimension.extensions.traceparent":"00-abc-01","eventName":"com.qlik.v1.some_event","idempotencyKey":"12345","timestamp":"2023-10-01T12:00:00Z"}`,
+		},
 		{
 			event: &model.ScrubbedEvent{
 				Id:       "12345",
@@ -60,14 +74,166 @@ func TestShouldFlattenEvent(t *testing.T) {
 			},
 			expected: `{"customerId":"tenant_123","dimension.data.foo.bar.baz":"qux","eventName":"com.qlik.v1.some_event","idempotencyKey":"12345","timestamp":"2023-10-01T12:00:00Z"}`,
 		},
+		{
+			event: &model.ScrubbedEvent{
+				Id:                 "12345",
+				Type:               "com.qlik.v1.some_event",
+				Time:               "2023-10-01T12:00:00Z",
+				TenantId:           "tenant_123",
+				UserId:             "3f1a",
+				SessionId:          "9c2b",
+				PseudonymKeyId:     "2025-08",
+				ScrubPolicyVersion: "3fa2b1c0d9e8+strict.1a2b3c4d5e6f",
+				Data:               map[string]any{},
+			},
+			expected: `{"customerId":"tenant_123","eventName":"com.qlik.v1.some_event","idempotencyKey":"12345","pseudonymKeyId":"2025-08","scrubPolicyVersion":"3fa2b1c0d9e8+strict.1a2b3c4d5e6f","sessionId":"9c2b","timestamp":"2023-10-01T12:00:00Z","userId":"3f1a"}`,
+		},
 	}
 
 	for _, test := range tests {
 		t.Run(test.expected, func(t *testing.T) {
-			result := Flatten(test.event)
+			result, err := Flatten(test.event)
+			require.NoError(t, err)
 			if result != test.expected {
 				t.Errorf("expected %q, got %q", test.expected, result)
 			}
 		})
 	}
 }
+
+func TestRecords_Arrays(t *testing.T) {
+	event := &model.ScrubbedEvent{
+		Id:       "12345",
+		Type:     "com.qlik.v1.some_event",
+		Time:     "2023-10-01T12:00:00Z",
+		TenantId: "tenant_123",
+		Data: map[string]any{
+			"items": []any{map[string]any{"id": 1.0, "tags": []any{"a"}}, map[string]any{"id": 2.0}},
+			"empty": map[string]any{},
+			"none":  []any{},
+		},
+	}
+
+	t.Run("json", func(t *testing.T) {
+		records, err := Options{Arrays: ArraysJSON}.Records(event)
+		require.NoError(t, err)
+		require.Len(t, records, 1)
+		assert.Equal(t, `[{"id":1,"tags":["a"]},{"id":2}]`, records[0]["dimension.data.items"])
+		assert.Equal(t, "{}", records[0]["dimension.data.empty"], "empty objects keep their key")
+		assert.Equal(t, "[]", records[0]["dimension.data.none"])
+	})
+
+	t.Run("indexed", func(t *testing.T) {
+		records, err := Options{Arrays: ArraysIndexed}.Records(event)
+		require.NoError(t, err)
+		require.Len(t, records, 1)
+		assert.Equal(t, 1.0, records[0]["dimension.data.items.0.id"])
+		assert.Equal(t, "a", records[0]["dimension.data.items.0.tags.0"])
+		assert.Equal(t, 2.0, records[0]["dimension.data.items.1.id"])
+		assert.Equal(t, "[]", records[0]["dimension.data.none"])
+	})
+
+	t.Run("explode", func(t *testing.T) {
+		records, err := Options{Arrays: ArraysExplode}.Records(event)
+		require.NoError(t, err)
+		require.Len(t, records, 2)
+		assert.Equal(t, "12345#0", records[0]["idempotencyKey"])
+		assert.Equal(t, 0, records[0]["rowIndex"])
+		assert.Equal(t, 1.0, records[0]["dimension.data.items.id"])
+		assert.Equal(t, `["a"]`, records[0]["dimension.data.items.tags"])
+		assert.Equal(t, "[]", records[0]["dimension.data.none"], "only the first non-empty array is exploded")
+		assert.Equal(t, "12345#1", records[1]["idempotencyKey"])
+		assert.Equal(t, 2.0, records[1]["dimension.data.items.id"])
+		assert.NotContains(t, records[1], "dimension.data.items.tags")
+	})
+
+	t.Run("explode skips empty arrays", func(t *testing.T) {
+		records, err := Options{Arrays: ArraysExplode}.Records(&model.ScrubbedEvent{Id: "12345", Data: map[string]any{
+			"a": []any{},
+			"b": []any{"x", "y"},
+		}})
+		require.NoError(t, err)
+		require.Len(t, records, 2)
+		assert.Equal(t, "[]", records[0]["dimension.data.a"])
+		assert.Equal(t, "x", records[0]["dimension.data.b"])
+		assert.Equal(t, "y", records[1]["dimension.data.b"])
+	})
+
+	t.Run("explode without arrays", func(t *testing.T) {
+		records, err := Options{Arrays: ArraysExplode}.Records(&model.ScrubbedEvent{Id: "12345", Data: map[string]any{"foo": "bar"}})
+		require.NoError(t, err)
+		require.Len(t, records, 1)
+		assert.Equal(t, "12345", records[0]["idempotencyKey"])
+		assert.NotContains(t, records[0], "rowIndex")
+	})
+}
+
+func TestRecords_Limits(t *testing.T) {
+	event := &model.ScrubbedEvent{
+		Id: "12345",
+		Data: map[string]any{
+			"a": map[string]any{"b": map[string]any{"c": "deep"}},
+			"d": "1",
+			"e": "2",
+			"f": "3",
+		},
+	}
+
+	records, err := Options{Arrays: ArraysJSON, MaxDepth: 2, MaxKeys: 3}.Records(event)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, `{"c":"deep"}`, records[0]["dimension.data.a.b"], "objects at MaxDepth are JSON encoded")
+	assert.Equal(t, "1", records[0]["dimension.data.d"])
+	assert.Equal(t, "2", records[0]["dimension.data.e"])
+	assert.NotContains(t, records[0], "dimension.data.f")
+	assert.Equal(t, `{"dimension.data.f":"3"}`, records[0][OverflowKey])
+}
+
+func TestRecords_Collisions(t *testing.T) {
+	event := &model.ScrubbedEvent{
+		Id: "12345",
+		Data: map[string]any{
+			"a.b":   "literal",
+			"a":     map[string]any{"b": "nested"},
+			"a.b~2": "taken",
+		},
+	}
+
+	for range 10 {
+		records, err := DefaultOptions().Records(event)
+		require.NoError(t, err)
+		require.Len(t, records, 1)
+		assert.Equal(t, "nested", records[0]["dimension.data.a.b"])
+		assert.Equal(t, "literal", records[0]["dimension.data.a.b~2"])
+		assert.Equal(t, "taken", records[0]["dimension.data.a.b~2~2"])
+	}
+}
+
+func TestRecords_InvalidValues(t *testing.T) {
+	tests := map[string]any{
+		"NaN":      math.NaN(),
+		"Inf":      math.Inf(1),
+		"channel":  make(chan int),
+		"in array": []any{1.0, math.Inf(-1)},
+		"in map":   map[string]any{"a": map[string]any{"b": func() {}}},
+	}
+
+	for name, value := range tests {
+		t.Run(name, func(t *testing.T) {
+			event := &model.ScrubbedEvent{Id: "12345", Data: map[string]any{"value": value}}
+			for _, arrays := range []string{ArraysJSON, ArraysIndexed, ArraysExplode} {
+				_, err := Options{Arrays: arrays, MaxDepth: 1}.Records(event)
+				var recordErr *RecordError
+				require.ErrorAs(t, err, &recordErr, arrays)
+				assert.Same(t, event, recordErr.Event)
+				assert.Contains(t, err.Error(), "dimension.data.value")
+			}
+		})
+	}
+}
+
+func TestOptions_Validate(t *testing.T) {
+	assert.NoError(t, DefaultOptions().Validate())
+	assert.Error(t, Options{Arrays: "rows"}.Validate())
+	assert.Error(t, Options{Arrays: ArraysJSON, MaxDepth: -1}.Validate())
+}
@@ -0,0 +1,101 @@
+package formatter_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	parquetgo "github.com/parquet-go/parquet-go"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/avro"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/formatter"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/model"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/wal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// forwardedEvents returns the events as the forwarder reads them back from the write-ahead log
+func forwardedEvents(t *testing.T, events []*model.ScrubbedEvent) []*model.ScrubbedEvent {
+	t.Helper()
+	log, err := wal.Open(wal.Options{Directory: t.TempDir(), SyncPolicy: wal.SyncNever})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = log.Close() })
+
+	require.NoError(t, log.Write(context.Background(), events))
+	forwarded, _, err := log.Read(log.Committed(), len(events))
+	require.NoError(t, err)
+	require.Len(t, forwarded, len(events))
+	return forwarded
+}
+
+func numberEvents() []*model.ScrubbedEvent {
+	return []*model.ScrubbedEvent{{
+		Id:       "1",
+		Type:     "com.qlik.v1.some_event",
+		Time:     "2023-10-01T12:00:00Z",
+		TenantId: "tenant_123",
+		Data:     map[string]any{"count": 5.0, "ratio": 0.5},
+	}}
+}
+
+func TestParquetEncoding_ForwardedEvents(t *testing.T) {
+	encoding, err := formatter.NewEncoding(formatter.EncodingOptions{Format: formatter.FormatParquet, Flatten: formatter.DefaultOptions()})
+	require.NoError(t, err)
+
+	// forwarded numbers get the types of numbers that were not stored, and do not widen the kept types
+	for name, events := range map[string][]*model.ScrubbedEvent{
+		"direct":    numberEvents(),
+		"forwarded": forwardedEvents(t, numberEvents()),
+	} {
+		var buf bytes.Buffer
+		rejected, err := encoding.Encode(&buf, events)
+		require.NoError(t, err)
+		require.Empty(t, rejected)
+
+		file, err := parquetgo.OpenFile(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		require.NoError(t, err, name)
+		kinds := map[string]parquetgo.Kind{}
+		for _, field := range file.Schema().Fields() {
+			kinds[field.Name()] = field.Type().Kind()
+		}
+		assert.Equal(t, parquetgo.Int64, kinds["dimension.data.count"], name)
+		assert.Equal(t, parquetgo.Double, kinds["dimension.data.ratio"], name)
+
+		rows := make([]parquetgo.Row, 1)
+		n, _ := parquetgo.NewReader(file).ReadRows(rows)
+		require.Equal(t, 1, n, name)
+		var values []any
+		for _, value := range rows[0] {
+			switch value.Kind() {
+			case parquetgo.Int64:
+				values = append(values, value.Int64())
+			case parquetgo.Double:
+				values = append(values, value.Double())
+			}
+		}
+		assert.Contains(t, values, int64(5), name)
+		assert.Contains(t, values, 0.5, name)
+	}
+}
+
+func TestAvroEncoding_ForwardedEvents(t *testing.T) {
+	registry, err := avro.OpenRegistry("")
+	require.NoError(t, err)
+	encoding, err := formatter.NewEncoding(formatter.EncodingOptions{Format: formatter.FormatAvro, Flatten: formatter.DefaultOptions(), AvroSchemas: registry})
+	require.NoError(t, err)
+
+	rejected, err := encoding.Encode(&bytes.Buffer{}, numberEvents())
+	require.NoError(t, err)
+	require.Empty(t, rejected)
+	direct, ok := registry.Latest()
+	require.True(t, ok)
+	assert.Contains(t, string(direct.Schema), `"values":["null","long","double"]`)
+
+	// forwarded numbers keep the schema instead of adding a string branch
+	rejected, err = encoding.Encode(&bytes.Buffer{}, forwardedEvents(t, numberEvents()))
+	require.NoError(t, err)
+	require.Empty(t, rejected)
+	forwarded, _ := registry.Latest()
+	assert.Equal(t, direct, forwarded)
+	assert.Len(t, registry.Schemas(), 1)
+}
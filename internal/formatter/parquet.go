@@ -0,0 +1,186 @@
+package formatter
+
+import (
+	"encoding/json"
+	"io"
+	"maps"
+	"math"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/model"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/parquet"
+)
+
+const (
+	// timestampKey is the record key written as a timestamp column
+	timestampKey = "timestamp"
+	// maxParquetColumnTypes bounds the number of keys whose column types are kept across batches
+	maxParquetColumnTypes = 10000
+)
+
+// parquetEncoding writes the records of a batch as a Parquet file with a column per key of the records.
+// A column gets the type of its values, which is widened when they disagree: integers to doubles, and any other mix
+// to strings. Columns only holding nulls are strings. The types of up to maxParquetColumnTypes keys are kept across
+// batches, so a column keeps its widened type in the files written later.
+// The timestamp column is a timestamp, times that do not parse as RFC 3339 are nulls.
+type parquetEncoding struct {
+	opts    Options
+	parquet parquet.Options
+
+	mu    sync.Mutex
+	types map[string]parquet.Type
+}
+
+func newParquetEncoding(opts Options, parquetOpts parquet.Options) *parquetEncoding {
+	return &parquetEncoding{opts: opts, parquet: parquetOpts, types: map[string]parquet.Type{}}
+}
+
+func (e *parquetEncoding) Encode(w io.Writer, events []*model.ScrubbedEvent) ([]*RecordError, error) {
+	records, rejected := e.opts.records(events)
+	columns := e.schema(records)
+
+	rows := make([][]any, len(records))
+	for i, record := range records {
+		rows[i] = make([]any, len(columns))
+		for c, column := range columns {
+			rows[i][c] = parquetValue(column.Type, record[column.Name])
+		}
+	}
+	return rejected, parquet.Write(w, columns, rows, e.parquet)
+}
+
+func (*parquetEncoding) ContentType() string   { return "application/vnd.apache.parquet" }
+func (*parquetEncoding) FileExtension() string { return ".parquet" }
+func (*parquetEncoding) Concatenable() bool    { return false }
+
+// schema returns the columns of the keys of the records in key order, widening the kept types with their values
+func (e *parquetEncoding) schema(records []map[string]any) []parquet.Column {
+	keys := make(map[string]struct{})
+	types := make(map[string]parquet.Type)
+	for _, record := range records {
+		for key, value := range record {
+			keys[key] = struct{}{}
+			if value == nil {
+				continue
+			}
+			valueType := parquetType(key, value)
+			if columnType, ok := types[key]; ok {
+				valueType = widen(columnType, valueType)
+			}
+			types[key] = valueType
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	columns := make([]parquet.Column, 0, len(keys))
+	for _, key := range slices.Sorted(maps.Keys(keys)) {
+		columnType, typed := types[key]
+		kept, known := e.types[key]
+		switch {
+		case known && typed:
+			columnType = widen(kept, columnType)
+		case known:
+			columnType, typed = kept, true
+		}
+		if typed && (known || len(e.types) < maxParquetColumnTypes) {
+			e.types[key] = columnType
+		}
+		if !typed {
+			columnType = parquet.String
+		}
+		columns = append(columns, parquet.Column{Name: key, Type: columnType})
+	}
+	return columns
+}
+
+// parquetType returns the column type of a value that is not nil
+func parquetType(key string, value any) parquet.Type {
+	if key == timestampKey {
+		return parquet.Timestamp
+	}
+	v := reflect.ValueOf(number(value))
+	switch v.Kind() {
+	case reflect.Bool:
+		return parquet.Boolean
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return parquet.Int64
+	case reflect.Float32, reflect.Float64:
+		// JSON numbers are decoded as float64, whole numbers that are exact in a float64 are integers
+		if f := v.Float(); f == math.Trunc(f) && math.Abs(f) <= 1<<53 {
+			return parquet.Int64
+		}
+		return parquet.Double
+	default:
+		return parquet.String
+	}
+}
+
+// number returns a json.Number as a float64. The write-ahead log decodes numbers as json.Number,
+// so forwarded events get the same types as events decoded with their numbers as float64.
+func number(value any) any {
+	if n, ok := value.(json.Number); ok {
+		if f, err := n.Float64(); err == nil {
+			return f
+		}
+	}
+	return value
+}
+
+// widen returns the type of a column holding values of both types
+func widen(a, b parquet.Type) parquet.Type {
+	switch {
+	case a == b:
+		return a
+	case (a == parquet.Int64 || a == parquet.Double) && (b == parquet.Int64 || b == parquet.Double):
+		return parquet.Double
+	default:
+		return parquet.String
+	}
+}
+
+// parquetValue converts a value to the Go type of a column of type t
+func parquetValue(t parquet.Type, value any) any {
+	if value == nil {
+		return nil
+	}
+	value = number(value)
+	switch t {
+	case parquet.Boolean:
+		return reflect.ValueOf(value).Bool()
+	case parquet.Int64:
+		v := reflect.ValueOf(value)
+		switch {
+		case v.CanInt():
+			return v.Int()
+		case v.CanUint():
+			return int64(v.Uint())
+		default:
+			return int64(v.Float())
+		}
+	case parquet.Double:
+		v := reflect.ValueOf(value)
+		switch {
+		case v.CanInt():
+			return float64(v.Int())
+		case v.CanUint():
+			return float64(v.Uint())
+		default:
+			return v.Float()
+		}
+	case parquet.Timestamp:
+		s, _ := value.(string)
+		timestamp, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return nil
+		}
+		return timestamp
+	default:
+		return cell(value)
+	}
+}
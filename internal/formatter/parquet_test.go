@@ -0,0 +1,102 @@
+package formatter
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/model"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/parquet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParquetEncoding_WidensSchema(t *testing.T) {
+	encoding, err := NewEncoding(EncodingOptions{Format: FormatParquet, Flatten: DefaultOptions()})
+	require.NoError(t, err)
+	e := encoding.(*parquetEncoding)
+
+	event := func(data map[string]any) *model.ScrubbedEvent {
+		return &model.ScrubbedEvent{Id: "1", Type: "com.qlik.v1.some_event", Time: "2023-10-01T12:00:00Z", TenantId: "tenant_123", Data: data}
+	}
+	records := func(data map[string]any) []map[string]any {
+		records, err := DefaultOptions().Records(event(data))
+		require.NoError(t, err)
+		return records
+	}
+
+	assert.Equal(t, []parquet.Column{
+		{Name: "customerId", Type: parquet.String},
+		{Name: "dimension.data.count", Type: parquet.Int64},
+		{Name: "dimension.data.enabled", Type: parquet.Boolean},
+		{Name: "dimension.data.items", Type: parquet.String},
+		{Name: "dimension.data.ratio", Type: parquet.Double},
+		{Name: "eventName", Type: parquet.String},
+		{Name: "idempotencyKey", Type: parquet.String},
+		{Name: "timestamp", Type: parquet.Timestamp},
+	}, e.schema(records(map[string]any{"count": 3.0, "enabled": true, "items": []any{1.0}, "ratio": 0.5})))
+
+	// types of earlier batches are kept and widened, their columns are not
+	assert.Equal(t, []parquet.Column{
+		{Name: "customerId", Type: parquet.String},
+		{Name: "dimension.data.count", Type: parquet.Double},
+		{Name: "dimension.data.enabled", Type: parquet.String},
+		{Name: "dimension.data.ratio", Type: parquet.Double},
+		{Name: "eventName", Type: parquet.String},
+		{Name: "idempotencyKey", Type: parquet.String},
+		{Name: "timestamp", Type: parquet.Timestamp},
+	}, e.schema(records(map[string]any{"count": 3.5, "enabled": "yes", "ratio": 2.0})))
+
+	// nulls do not type a column, a column of only nulls is a string
+	assert.Contains(t, e.schema(records(map[string]any{"n": nil})), parquet.Column{Name: "dimension.data.n", Type: parquet.String})
+	assert.Contains(t, e.schema(records(map[string]any{"n": 5.0})), parquet.Column{Name: "dimension.data.n", Type: parquet.Int64})
+	assert.Contains(t, e.schema(records(map[string]any{"n": nil})), parquet.Column{Name: "dimension.data.n", Type: parquet.Int64})
+
+	// once maxParquetColumnTypes types are kept, types of new keys are only widened within their batch
+	for i := len(e.types); i < maxParquetColumnTypes; i++ {
+		e.types[fmt.Sprintf("key%d", i)] = parquet.String
+	}
+	assert.Contains(t, e.schema(records(map[string]any{"new": 1.0})), parquet.Column{Name: "dimension.data.new", Type: parquet.Int64})
+	assert.Contains(t, e.schema(records(map[string]any{"new": true})), parquet.Column{Name: "dimension.data.new", Type: parquet.Boolean})
+	assert.Len(t, e.types, maxParquetColumnTypes)
+
+	file := encode(t, encoding, []*model.ScrubbedEvent{event(map[string]any{"count": 1.0})})
+	assert.Equal(t, "PAR1", string(file[:4]))
+	assert.Equal(t, "PAR1", string(file[len(file)-4:]))
+	assert.False(t, encoding.Concatenable())
+	assert.Equal(t, ".parquet", encoding.FileExtension())
+}
+
+func TestParquetValue(t *testing.T) {
+	tests := []struct {
+		columnType parquet.Type
+		value      any
+		expected   any
+	}{
+		{columnType: parquet.Int64, value: 3.0, expected: int64(3)},
+		{columnType: parquet.Int64, value: 2, expected: int64(2)},
+		{columnType: parquet.Double, value: 3.0, expected: 3.0},
+		{columnType: parquet.Double, value: 2, expected: 2.0},
+		{columnType: parquet.Boolean, value: true, expected: true},
+		{columnType: parquet.String, value: true, expected: "true"},
+		{columnType: parquet.String, value: 1.5, expected: "1.5"},
+		{columnType: parquet.String, value: "text", expected: "text"},
+		{columnType: parquet.String, value: nil, expected: nil},
+		{
+			columnType: parquet.Timestamp,
+			value:      "2023-10-01T12:00:00.5+02:00",
+			expected:   time.Date(2023, 10, 1, 12, 0, 0, 500000000, time.FixedZone("", 2*60*60)),
+		},
+		{columnType: parquet.Timestamp, value: "yesterday", expected: nil},
+	}
+
+	for _, test := range tests {
+		actual := parquetValue(test.columnType, test.value)
+		if expected, ok := test.expected.(time.Time); ok {
+			require.IsType(t, time.Time{}, actual)
+			assert.True(t, expected.Equal(actual.(time.Time)))
+			continue
+		}
+		assert.Equal(t, test.expected, actual)
+	}
+}
@@ -1,15 +1,158 @@
 package formatter
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"strings"
+	"sync"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/qlik-trial/usage-telemetry-publisher/internal/model"
 )
 
-func Write(events []*model.ScrubbedEvent) string {
-	res := make([]string, 0, len(events))
-	for _, e := range events {
-		res = append(res, Flatten(e))
+// Compressions of the output of an Encoder
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+const zstdWindowSize = 1 << 20
+
+// compressors are pooled, creating them allocates their window and tables for every stream
+var (
+	gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(io.Discard) }}
+	zstdWriters = sync.Pool{New: func() any {
+		// batches are small and encoded one at a time, the defaults size the encoder for concurrent 8 MiB windows
+		w, _ := zstd.NewWriter(io.Discard, zstd.WithEncoderConcurrency(1), zstd.WithWindowSize(zstdWindowSize), zstd.WithLowerEncoderMem(true))
+		return w
+	}}
+)
+
+// Write formats the events as newline delimited JSON with DefaultOptions
+func Write(events []*model.ScrubbedEvent) (string, error) {
+	return DefaultOptions().Write(events)
+}
+
+// Write formats the records of the events as newline delimited JSON. Events that cannot be encoded are left out,
+// their *RecordError are joined into the returned error.
+func (o Options) Write(events []*model.ScrubbedEvent) (string, error) {
+	var b strings.Builder
+	encoder, _ := NewEncoder(&b, o, CompressionNone) // only fails for unknown compressions
+	var errs []error
+	for _, event := range events {
+		if err := encoder.Encode(event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n"), errors.Join(errs...)
+}
+
+// Encoder streams the records of events as newline delimited JSON to a writer, framed with its compression
+type Encoder struct {
+	opts       Options
+	w          io.Writer
+	compressor io.WriteCloser
+	// buf holds the records of the event being encoded, so a failing event writes nothing
+	buf  bytes.Buffer
+	json *json.Encoder
+}
+
+// NewEncoder creates an Encoder writing to w with a compression of CompressionNone, CompressionGzip or CompressionZstd.
+// An empty compression writes uncompressed records.
+func NewEncoder(w io.Writer, opts Options, compression string) (*Encoder, error) {
+	compressor, err := Compress(w, compression)
+	if err != nil {
+		return nil, err
+	}
+	e := &Encoder{opts: opts, w: w}
+	if compressor != nil {
+		e.compressor, e.w = compressor, compressor
+	}
+	e.json = json.NewEncoder(&e.buf)
+	return e, nil
+}
+
+// Encode writes the records of an event, one per line. An event that cannot be encoded writes nothing
+// and returns a *RecordError, other errors are errors of the underlying writer.
+func (e *Encoder) Encode(event *model.ScrubbedEvent) error {
+	records, err := e.opts.Records(event)
+	if err != nil {
+		return err
+	}
+	e.buf.Reset()
+	for _, record := range records {
+		if err := e.json.Encode(record); err != nil {
+			return &RecordError{Event: event, Err: err}
+		}
+	}
+	_, err = e.w.Write(e.buf.Bytes())
+	return err
+}
+
+// Close ends the compressed stream, the underlying writer is not closed
+func (e *Encoder) Close() error {
+	if e.compressor == nil {
+		return nil
+	}
+	return e.compressor.Close()
+}
+
+// Compress returns a writer compressing to w with CompressionGzip or CompressionZstd, which must be closed to end the
+// stream and must not be used after. It returns nil for CompressionNone and an empty compression.
+func Compress(w io.Writer, compression string) (io.WriteCloser, error) {
+	var pool *sync.Pool
+	switch compression {
+	case "", CompressionNone:
+		return nil, nil
+	case CompressionGzip:
+		pool = &gzipWriters
+	case CompressionZstd:
+		pool = &zstdWriters
+	default:
+		return nil, fmt.Errorf("unknown compression %q", compression)
+	}
+	compressor := pool.Get().(resetWriter)
+	compressor.Reset(w)
+	return &pooledCompressor{w: compressor, pool: pool}, nil
+}
+
+// errCompressorClosed is returned by writes to a compressor whose stream was closed
+var errCompressorClosed = errors.New("compressor is closed")
+
+// resetWriter is a compressor that can be reused for another stream
+type resetWriter interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+// pooledCompressor returns its compressor to its pool once the stream is closed
+type pooledCompressor struct {
+	// w is nil once the stream is closed, the compressor may then be in use by another stream
+	w    resetWriter
+	pool *sync.Pool
+}
+
+func (c *pooledCompressor) Write(p []byte) (int, error) {
+	if c.w == nil {
+		return 0, errCompressorClosed
+	}
+	return c.w.Write(p)
+}
+
+// Close ends the stream and returns the compressor to its pool, closing it again does nothing
+func (c *pooledCompressor) Close() error {
+	if c.w == nil {
+		return nil
 	}
-	return strings.Join(res, "\n")
+	err := c.w.Close()
+	// drop the reference to the stream's writer before the compressor is reused
+	c.w.Reset(io.Discard)
+	c.pool.Put(c.w)
+	c.w = nil
+	return err
 }
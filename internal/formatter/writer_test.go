@@ -1,9 +1,19 @@
 package formatter
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strings"
 	"testing"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/qlik-trial/usage-telemetry-publisher/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func Test_write_events(t *testing.T) {
@@ -52,9 +62,152 @@ func Test_write_events(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		result := Write(test.events)
+		result, err := Write(test.events)
+		require.NoError(t, err)
 		if result != test.expected {
 			t.Errorf("expected %q, got %q", test.expected, result)
 		}
 	}
 }
+
+func TestWrite_RejectsEvents(t *testing.T) {
+	invalid := &model.ScrubbedEvent{Id: "2", Data: map[string]any{"ch": make(chan int)}}
+	result, err := Write([]*model.ScrubbedEvent{{Id: "1"}, invalid, {Id: "3"}})
+
+	var recordErr *RecordError
+	require.ErrorAs(t, err, &recordErr)
+	assert.Same(t, invalid, recordErr.Event)
+	assert.Equal(t, `{"customerId":"","eventName":"","idempotencyKey":"1","timestamp":""}`+"\n"+
+		`{"customerId":"","eventName":"","idempotencyKey":"3","timestamp":""}`, result)
+}
+
+func TestEncoder(t *testing.T) {
+	events := []*model.ScrubbedEvent{
+		{Id: "1", Data: map[string]any{"count": 1.0}},
+		{Id: "2", Data: map[string]any{"count": math.NaN()}},
+		{Id: "3", Data: map[string]any{"count": 3.0}},
+	}
+	expected := `{"customerId":"","dimension.data.count":1,"eventName":"","idempotencyKey":"1","timestamp":""}` + "\n" +
+		`{"customerId":"","dimension.data.count":3,"eventName":"","idempotencyKey":"3","timestamp":""}` + "\n"
+
+	decompress := map[string]func(r io.Reader) (io.Reader, error){
+		CompressionNone: func(r io.Reader) (io.Reader, error) { return r, nil },
+		CompressionGzip: func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		CompressionZstd: func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) },
+	}
+	for compression, decompress := range decompress {
+		t.Run(compression, func(t *testing.T) {
+			// the second stream reuses the pooled compressor of the first
+			for range 2 {
+				var b bytes.Buffer
+				encoder, err := NewEncoder(&b, DefaultOptions(), compression)
+				require.NoError(t, err)
+				for _, event := range events {
+					err := encoder.Encode(event)
+					if event.Id == "2" {
+						var recordErr *RecordError
+						require.ErrorAs(t, err, &recordErr)
+						assert.Same(t, event, recordErr.Event)
+						continue
+					}
+					require.NoError(t, err)
+				}
+				require.NoError(t, encoder.Close())
+
+				r, err := decompress(&b)
+				require.NoError(t, err)
+				decoded, err := io.ReadAll(r)
+				require.NoError(t, err)
+				assert.Equal(t, expected, string(decoded))
+			}
+		})
+	}
+
+	_, err := NewEncoder(io.Discard, DefaultOptions(), "lz4")
+	require.Error(t, err)
+}
+
+func TestCompress_Close(t *testing.T) {
+	for _, compression := range []string{CompressionGzip, CompressionZstd} {
+		t.Run(compression, func(t *testing.T) {
+			compressor, err := Compress(io.Discard, compression)
+			require.NoError(t, err)
+			_, err = compressor.Write([]byte("record"))
+			require.NoError(t, err)
+			require.NoError(t, compressor.Close())
+
+			// the compressor is back in its pool, the closed stream neither writes to it nor returns it again
+			require.NoError(t, compressor.Close())
+			_, err = compressor.Write([]byte("record"))
+			require.ErrorIs(t, err, errCompressorClosed)
+		})
+	}
+}
+
+// benchEvents returns n events with a few nested dimensions each
+func benchEvents(n int) []*model.ScrubbedEvent {
+	events := make([]*model.ScrubbedEvent, n)
+	for i := range events {
+		events[i] = &model.ScrubbedEvent{
+			Id:       fmt.Sprintf("event-%d", i),
+			Type:     "com.qlik.v1.some_event",
+			Time:     "2023-10-01T12:00:00Z",
+			TenantId: "tenant_123",
+			UserId:   "3f1a",
+			Data: map[string]any{
+				"app":      map[string]any{"id": "app-1", "name": strings.Repeat("a", 32), "size": 1024.0},
+				"count":    float64(i),
+				"enabled":  true,
+				"duration": 1.5,
+				"tags":     []any{"a", "b"},
+			},
+		}
+	}
+	return events
+}
+
+// joinedWrite is Write as it was before the Encoder, which joined the JSON of the records.
+// It is kept as the baseline of BenchmarkWrite.
+func joinedWrite(events []*model.ScrubbedEvent) string {
+	res := make([]string, 0, len(events))
+	for _, e := range events {
+		records, _ := DefaultOptions().Records(e)
+		for _, record := range records {
+			b, _ := json.Marshal(record)
+			res = append(res, string(b))
+		}
+	}
+	return strings.Join(res, "\n")
+}
+
+func BenchmarkWrite(b *testing.B) {
+	events := benchEvents(500)
+	b.Run("joined", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			_ = []byte(joinedWrite(events) + "\n")
+		}
+	})
+	b.Run("encoder", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			_, _ = Write(events)
+		}
+	})
+}
+
+func BenchmarkEncoder(b *testing.B) {
+	events := benchEvents(500)
+	for _, compression := range []string{CompressionNone, CompressionGzip, CompressionZstd} {
+		b.Run(compression, func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				encoder, _ := NewEncoder(io.Discard, DefaultOptions(), compression)
+				for _, event := range events {
+					_ = encoder.Encode(event)
+				}
+				_ = encoder.Close()
+			}
+		})
+	}
+}
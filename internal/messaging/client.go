@@ -25,6 +25,7 @@ type EventListener interface {
 	AddReadinessCheck(healthcheck.Handler)
 	Close()
 	Connect(<-chan struct{}) error
+	Publish(ctx context.Context, subject string, data []byte) error
 }
 
 // CreateClient creates a messaging Client instance
@@ -98,3 +99,8 @@ func (mc *Client) SubscribeEvent(subject, qgroup string, cb messaging.MsgHandler
 	subOpts = append(subOpts, messaging.SetManualAckMode())
 	return mc.Subscribe(messaging.NewQueueSubscription(subject+"/>", qgroup, cb, subOpts...)) //nolint:staticcheck
 }
+
+// Publish publishes data to the specified subject
+func (mc *Client) Publish(_ context.Context, subject string, data []byte) error {
+	return mc.Client.Publish(subject, data)
+}
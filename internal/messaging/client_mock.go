@@ -1,6 +1,8 @@
 package messaging
 
 import (
+	"context"
+
 	"github.com/qlik-trial/go-service-kit/v29/healthcheck"
 	"github.com/qlik-trial/go-service-kit/v29/messaging"
 	"github.com/stretchr/testify/mock"
@@ -26,3 +28,8 @@ func (client *MockedMessagingClient) Connect(channel <-chan struct{}) error {
 	retval, _ := returns[0].(error)
 	return retval
 }
+func (client *MockedMessagingClient) Publish(ctx context.Context, subject string, data []byte) error {
+	returns := client.Called(subject, data)
+	retval, _ := returns[0].(error)
+	return retval
+}
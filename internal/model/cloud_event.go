@@ -1,6 +1,13 @@
 package model
 
-// CloudEvent struct for CloudEvent 1.0
+import (
+	"encoding/json"
+	"strings"
+)
+
+// CloudEvent struct for CloudEvent 1.0, the event type every stage of the pipeline works on.
+// Data holds any JSON value, DataBase64 holds binary data, base64 encoded, in place of Data.
+// Extensions holds the extension attributes that have no field, by attribute name.
 type CloudEvent struct {
 	Id                 string         `json:"id" bson:"event_id,omitempty"`
 	SpecVersion        string         `json:"specversion" bson:"specversion,omitempty"`
@@ -10,6 +17,7 @@ type CloudEvent struct {
 	Source             string         `json:"source" bson:"source,omitempty"`
 	EventType          string         `json:"type" bson:"event_type,omitempty"`
 	Time               string         `json:"time" bson:"event_time,omitempty"`
+	Subject            string         `json:"subject,omitempty" bson:"subject,omitempty"`
 	Host               string         `json:"host" bson:"host,omitempty"`
 	OriginIp           string         `json:"originip" bson:"origin_ip,omitempty"`
 	OwnerId            string         `json:"ownerid" bson:"owner_id,omitempty"`
@@ -17,5 +25,80 @@ type CloudEvent struct {
 	SpaceId            string         `json:"spaceid" bson:"space_id,omitempty"`
 	ClientId           string         `json:"clientid" bson:"client_id,omitempty"`
 	Reason             string         `json:"reason" bson:"reason,omitempty"`
-	Data               map[string]any `json:"data" bson:"data,omitempty"`
+	DataContentType    string         `json:"datacontenttype" bson:"data_content_type,omitempty"`
+	DataSchema         string         `json:"dataschema,omitempty" bson:"data_schema,omitempty"`
+	Data               any            `json:"data" bson:"data,omitempty"`
+	DataBase64         string         `json:"data_base64" bson:"data_base64,omitempty"`
+	Extensions         map[string]any `json:"-" bson:"extensions,omitempty"`
+}
+
+// cloudEventFields is CloudEvent without its JSON methods
+type cloudEventFields CloudEvent
+
+// fieldAttributes are the attribute names of the CloudEvent fields, all other attributes are extensions.
+// encoding/json matches attribute names to fields ignoring case, so they are compared with isFieldAttribute.
+var fieldAttributes = map[string]bool{
+	"id": true, "specversion": true, "tenantid": true, "userid": true, "sessionid": true, "source": true, "type": true,
+	"time": true, "subject": true, "host": true, "originip": true, "ownerid": true, "toplevelresourceid": true,
+	"spaceid": true, "clientid": true, "reason": true, "datacontenttype": true, "dataschema": true, "data": true,
+	"data_base64": true,
+}
+
+// isFieldAttribute returns whether name is decoded into a CloudEvent field, e.g. userId fills UserId,
+// folding case like encoding/json does
+func isFieldAttribute(name string) bool {
+	if fieldAttributes[name] {
+		return true
+	}
+	for attribute := range fieldAttributes {
+		if strings.EqualFold(name, attribute) {
+			return true
+		}
+	}
+	return false
+}
+
+// UnmarshalJSON decodes an event in the CloudEvents JSON format, collecting the attributes without a field into Extensions
+func (e *CloudEvent) UnmarshalJSON(b []byte) error {
+	var fields cloudEventFields
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+	var attributes map[string]json.RawMessage
+	if err := json.Unmarshal(b, &attributes); err != nil {
+		return err
+	}
+	for name, raw := range attributes {
+		if isFieldAttribute(name) {
+			continue
+		}
+		var value any
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return err
+		}
+		if fields.Extensions == nil {
+			fields.Extensions = map[string]any{}
+		}
+		fields.Extensions[name] = value
+	}
+	*e = CloudEvent(fields)
+	return nil
+}
+
+// MarshalJSON encodes an event in the CloudEvents JSON format, with its Extensions as top level attributes
+func (e CloudEvent) MarshalJSON() ([]byte, error) {
+	b, err := json.Marshal(cloudEventFields(e))
+	if err != nil || len(e.Extensions) == 0 {
+		return b, err
+	}
+	var attributes map[string]any
+	if err := json.Unmarshal(b, &attributes); err != nil {
+		return nil, err
+	}
+	for name, value := range e.Extensions {
+		if !isFieldAttribute(name) {
+			attributes[name] = value
+		}
+	}
+	return json.Marshal(attributes)
 }
@@ -0,0 +1,55 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCloudEvent_JSON(t *testing.T) {
+	payload := `{"specversion":"1.0","id":"event-1","source":"/analytics","type":"com.qlik.v1.analytics.sheet.viewed",` +
+		`"tenantid":"tenant-1","subject":"sheet-1","dataschema":"https://qlik.com/schemas/sheet.json",` +
+		`"traceparent":"00-abc-01","sequence":7,"data":{"foo":"bar"}}`
+
+	var event CloudEvent
+	require.NoError(t, json.Unmarshal([]byte(payload), &event))
+	assert.Equal(t, "tenant-1", event.TenantId)
+	assert.Equal(t, "sheet-1", event.Subject)
+	assert.Equal(t, "https://qlik.com/schemas/sheet.json", event.DataSchema)
+	assert.Equal(t, map[string]any{"traceparent": "00-abc-01", "sequence": 7.0}, event.Extensions)
+
+	b, err := json.Marshal(event)
+	require.NoError(t, err)
+	var roundTripped CloudEvent
+	require.NoError(t, json.Unmarshal(b, &roundTripped))
+	assert.Equal(t, event, roundTripped)
+
+	var attributes map[string]any
+	require.NoError(t, json.Unmarshal(b, &attributes))
+	assert.Equal(t, "00-abc-01", attributes["traceparent"], "extensions are top level attributes")
+}
+
+func TestCloudEvent_JSON_NoExtensions(t *testing.T) {
+	var event CloudEvent
+	require.NoError(t, json.Unmarshal([]byte(`{"id":"event-1","data":[1,2]}`), &event))
+	assert.Nil(t, event.Extensions)
+	assert.Equal(t, []any{1.0, 2.0}, event.Data)
+
+	require.Error(t, json.Unmarshal([]byte(`{"id":1}`), &event))
+}
+
+func TestCloudEvent_JSON_FieldAttributesIgnoreCase(t *testing.T) {
+	var event CloudEvent
+	require.NoError(t, json.Unmarshal([]byte(`{"id":"event-1","userId":"alice@example.com","OriginIP":"10.0.0.1","ſessionid":"session-1","sequence":7}`), &event))
+	assert.Equal(t, "alice@example.com", event.UserId)
+	assert.Equal(t, "10.0.0.1", event.OriginIp)
+	assert.Equal(t, "session-1", event.SessionId)
+	assert.Equal(t, map[string]any{"sequence": 7.0}, event.Extensions, "attributes decoded into fields are not extensions")
+
+	event.Extensions["UserID"] = "bob@example.com"
+	b, err := json.Marshal(event)
+	require.NoError(t, err)
+	assert.NotContains(t, string(b), "bob@example.com")
+}
@@ -10,6 +10,7 @@ type ScrubbedEvent struct {
 	Source             string
 	Type               string
 	Time               string
+	Subject            string
 	Host               string
 	OriginIp           string
 	OwnerId            string
@@ -17,5 +18,12 @@ type ScrubbedEvent struct {
 	SpaceId            string
 	ClientId           string
 	Reason             string
+	DataSchema         string
 	Data               map[string]any
+	// Extensions are the scrubbed extension attributes of the event, by attribute name
+	Extensions map[string]any
+	// PseudonymKeyId identifies the key UserId, SessionId and OwnerId were pseudonymized with, if any
+	PseudonymKeyId string
+	// ScrubPolicyVersion identifies the scrub policy, including the tenant's overlay, the event was scrubbed with
+	ScrubPolicyVersion string
 }
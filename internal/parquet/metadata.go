@@ -0,0 +1,150 @@
+package parquet
+
+// Enum values of the Parquet format, see parquet.thrift of apache/parquet-format
+const (
+	physicalBoolean   = 0
+	physicalInt64     = 2
+	physicalDouble    = 5
+	physicalByteArray = 6
+
+	repetitionOptional = 1
+
+	convertedUTF8            = 0
+	convertedTimestampMicros = 10
+
+	encodingPlain = 0
+	encodingRLE   = 3
+
+	codecUncompressed = 0
+	codecSnappy       = 1
+	codecZstd         = 6
+
+	pageTypeData = 0
+)
+
+// fileMetaData is the footer of a Parquet file
+type fileMetaData struct {
+	columns   []Column
+	numRows   int64
+	rowGroups []rowGroup
+}
+
+type rowGroup struct {
+	columns             []columnChunk
+	totalByteSize       int64
+	totalCompressedSize int64
+	numRows             int64
+	fileOffset          int64
+}
+
+type columnChunk struct {
+	column                Column
+	codec                 int32
+	numValues             int64
+	totalUncompressedSize int64
+	totalCompressedSize   int64
+	dataPageOffset        int64
+}
+
+type pageHeader struct {
+	uncompressedSize int32
+	compressedSize   int32
+	numValues        int32
+}
+
+func (m fileMetaData) write(w *compactWriter) {
+	w.beginStruct()
+	w.i32Field(1, 1) // version
+
+	w.listField(2, thriftStruct, len(m.columns)+1)
+	w.beginStruct() // root of the schema
+	w.stringField(4, "schema")
+	w.i32Field(5, int32(len(m.columns)))
+	w.endStruct()
+	for _, column := range m.columns {
+		column.write(w)
+	}
+
+	w.i64Field(3, m.numRows)
+	w.listField(4, thriftStruct, len(m.rowGroups))
+	for _, group := range m.rowGroups {
+		group.write(w)
+	}
+	w.stringField(6, createdBy)
+	w.endStruct()
+}
+
+// write writes the schema element of the column
+func (c Column) write(w *compactWriter) {
+	w.beginStruct()
+	w.i32Field(1, c.Type.physical())
+	w.i32Field(3, repetitionOptional)
+	w.stringField(4, c.Name)
+	switch c.Type {
+	case String:
+		w.i32Field(6, convertedUTF8)
+		w.structField(10) // logicalType
+		w.structField(1)  // STRING
+		w.endStruct()
+		w.endStruct()
+	case Timestamp:
+		w.i32Field(6, convertedTimestampMicros)
+		w.structField(10) // logicalType
+		w.structField(8)  // TIMESTAMP
+		w.boolField(1, true)
+		w.structField(2) // unit
+		w.structField(2) // MICROS
+		w.endStruct()
+		w.endStruct()
+		w.endStruct()
+		w.endStruct()
+	}
+	w.endStruct()
+}
+
+func (g rowGroup) write(w *compactWriter) {
+	w.beginStruct()
+	w.listField(1, thriftStruct, len(g.columns))
+	for _, chunk := range g.columns {
+		chunk.write(w)
+	}
+	w.i64Field(2, g.totalByteSize)
+	w.i64Field(3, g.numRows)
+	w.i64Field(5, g.fileOffset)
+	w.i64Field(6, g.totalCompressedSize)
+	w.endStruct()
+}
+
+func (c columnChunk) write(w *compactWriter) {
+	w.beginStruct()
+	w.i64Field(2, c.dataPageOffset) // file_offset
+	w.structField(3)                // meta_data
+	w.i32Field(1, c.column.Type.physical())
+	w.listField(2, thriftI32, 2)
+	w.i32(encodingPlain)
+	w.i32(encodingRLE)
+	w.listField(3, thriftBinary, 1)
+	w.string(c.column.Name)
+	w.i32Field(4, c.codec)
+	w.i64Field(5, c.numValues)
+	w.i64Field(6, c.totalUncompressedSize)
+	w.i64Field(7, c.totalCompressedSize)
+	w.i64Field(9, c.dataPageOffset)
+	w.endStruct()
+	w.endStruct()
+}
+
+// write writes the header of a data page with plain encoded values and RLE encoded definition levels
+func (h pageHeader) write(w *compactWriter) {
+	w.beginStruct()
+	w.i32Field(1, pageTypeData)
+	w.i32Field(2, h.uncompressedSize)
+	w.i32Field(3, h.compressedSize)
+	w.structField(5) // data_page_header
+	w.i32Field(1, h.numValues)
+	w.i32Field(2, encodingPlain)
+	w.i32Field(3, encodingRLE)
+	w.i32Field(4, encodingRLE)
+	w.endStruct()
+	w.endStruct()
+}
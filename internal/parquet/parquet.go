@@ -0,0 +1,284 @@
+// Package parquet writes flat Apache Parquet files in pure Go.
+// Every column is optional and holds a single data page per row group,
+// with plain encoded values and RLE encoded definition levels.
+package parquet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Type is the type of the values of a column
+type Type int
+
+const (
+	// String columns hold UTF-8 string values
+	String Type = iota
+	// Boolean columns hold bool values
+	Boolean
+	// Int64 columns hold int64 values
+	Int64
+	// Double columns hold float64 values
+	Double
+	// Timestamp columns hold time.Time values, stored as microseconds since the Unix epoch in UTC
+	Timestamp
+)
+
+// Compression codecs of column chunks
+const (
+	CompressionNone   = "none"
+	CompressionSnappy = "snappy"
+	CompressionZstd   = "zstd"
+)
+
+const (
+	magic     = "PAR1"
+	createdBy = "usage-telemetry-publisher"
+	// defaultRowGroupSize is the number of rows of a row group when Options.RowGroupSize is not set
+	defaultRowGroupSize = 10000
+)
+
+// Column is a column of a file
+type Column struct {
+	Name string
+	Type Type
+}
+
+// Options configure how files are written
+type Options struct {
+	// Compression is the codec of the column chunks, CompressionNone, CompressionSnappy or CompressionZstd.
+	// It defaults to CompressionSnappy.
+	Compression string
+	// RowGroupSize is the maximum number of rows of a row group, it defaults to 10000
+	RowGroupSize int
+}
+
+// Validate returns an error for options with an unknown compression or a negative row group size
+func (o Options) Validate() error {
+	if _, err := codec(o.Compression); err != nil {
+		return err
+	}
+	if o.RowGroupSize < 0 {
+		return errors.New("row group size must not be negative")
+	}
+	return nil
+}
+
+// Write writes rows as a Parquet file with columns to w. A nil value is a null,
+// other values must have the Go type of the Type of their column.
+func Write(w io.Writer, columns []Column, rows [][]any, opts Options) error {
+	codec, err := codec(opts.Compression)
+	if err != nil {
+		return err
+	}
+	size := opts.RowGroupSize
+	if size <= 0 {
+		size = defaultRowGroupSize
+	}
+
+	out := &countingWriter{w: w}
+	if _, err := io.WriteString(out, magic); err != nil {
+		return err
+	}
+	metadata := fileMetaData{columns: columns, numRows: int64(len(rows))}
+	for start := 0; start < len(rows); start += size {
+		group, err := writeRowGroup(out, columns, rows[start:min(start+size, len(rows))], codec)
+		if err != nil {
+			return err
+		}
+		metadata.rowGroups = append(metadata.rowGroups, group)
+	}
+
+	footer := &compactWriter{}
+	metadata.write(footer)
+	footer.buf = binary.LittleEndian.AppendUint32(footer.buf, uint32(len(footer.buf)))
+	footer.buf = append(footer.buf, magic...)
+	_, err = out.Write(footer.buf)
+	return err
+}
+
+func writeRowGroup(out *countingWriter, columns []Column, rows [][]any, codec int32) (rowGroup, error) {
+	group := rowGroup{numRows: int64(len(rows)), fileOffset: out.n}
+	for i, column := range columns {
+		page, err := encodePage(column, i, rows)
+		if err != nil {
+			return rowGroup{}, err
+		}
+		compressed := compress(codec, page)
+
+		header := &compactWriter{}
+		pageHeader{
+			uncompressedSize: int32(len(page)),
+			compressedSize:   int32(len(compressed)),
+			numValues:        int32(len(rows)),
+		}.write(header)
+
+		chunk := columnChunk{
+			column:                column,
+			codec:                 codec,
+			numValues:             int64(len(rows)),
+			totalUncompressedSize: int64(len(header.buf) + len(page)),
+			totalCompressedSize:   int64(len(header.buf) + len(compressed)),
+			dataPageOffset:        out.n,
+		}
+		if _, err := out.Write(header.buf); err != nil {
+			return rowGroup{}, err
+		}
+		if _, err := out.Write(compressed); err != nil {
+			return rowGroup{}, err
+		}
+		group.columns = append(group.columns, chunk)
+		group.totalByteSize += chunk.totalUncompressedSize
+		group.totalCompressedSize += chunk.totalCompressedSize
+	}
+	return group, nil
+}
+
+// encodePage returns the uncompressed data page of column i of rows: the definition levels followed by the values
+func encodePage(column Column, i int, rows [][]any) ([]byte, error) {
+	levels := make([]bool, len(rows))
+	var values []byte
+	var booleans []bool
+	for r, row := range rows {
+		value := row[i]
+		if value == nil {
+			continue
+		}
+		levels[r] = true
+
+		var ok bool
+		switch column.Type {
+		case String:
+			var v string
+			if v, ok = value.(string); ok {
+				values = binary.LittleEndian.AppendUint32(values, uint32(len(v)))
+				values = append(values, v...)
+			}
+		case Boolean:
+			var v bool
+			if v, ok = value.(bool); ok {
+				booleans = append(booleans, v)
+			}
+		case Int64:
+			var v int64
+			if v, ok = value.(int64); ok {
+				values = binary.LittleEndian.AppendUint64(values, uint64(v))
+			}
+		case Double:
+			var v float64
+			if v, ok = value.(float64); ok {
+				values = binary.LittleEndian.AppendUint64(values, math.Float64bits(v))
+			}
+		case Timestamp:
+			var v time.Time
+			if v, ok = value.(time.Time); ok {
+				values = binary.LittleEndian.AppendUint64(values, uint64(v.UnixMicro()))
+			}
+		}
+		if !ok {
+			return nil, fmt.Errorf("unexpected value of type %T in column %s", value, column.Name)
+		}
+	}
+	if column.Type == Boolean {
+		values = packBits(booleans)
+	}
+
+	page := encodeLevels(levels)
+	return append(page, values...), nil
+}
+
+// encodeLevels returns definition levels of bit width 1 as runs of the RLE/bit-packing hybrid encoding,
+// prefixed with their length
+func encodeLevels(levels []bool) []byte {
+	buf := make([]byte, 4, 16)
+	for start := 0; start < len(levels); {
+		end := start + 1
+		for end < len(levels) && levels[end] == levels[start] {
+			end++
+		}
+		buf = binary.AppendUvarint(buf, uint64(end-start)<<1)
+		if levels[start] {
+			buf = append(buf, 1)
+		} else {
+			buf = append(buf, 0)
+		}
+		start = end
+	}
+	binary.LittleEndian.PutUint32(buf, uint32(len(buf)-4))
+	return buf
+}
+
+// packBits returns plain encoded booleans, one bit per value starting with the least significant bit
+func packBits(values []bool) []byte {
+	buf := make([]byte, (len(values)+7)/8)
+	for i, v := range values {
+		if v {
+			buf[i/8] |= 1 << (i % 8)
+		}
+	}
+	return buf
+}
+
+func codec(compression string) (int32, error) {
+	switch compression {
+	case "", CompressionSnappy:
+		return codecSnappy, nil
+	case CompressionZstd:
+		return codecZstd, nil
+	case CompressionNone:
+		return codecUncompressed, nil
+	default:
+		return 0, fmt.Errorf("unknown compression %q", compression)
+	}
+}
+
+// zstdEncoder is shared by all files, EncodeAll is safe for concurrent use
+var zstdEncoder = sync.OnceValue(func() *zstd.Encoder {
+	encoder, _ := zstd.NewWriter(nil) // only fails for invalid options
+	return encoder
+})
+
+func compress(codec int32, data []byte) []byte {
+	switch codec {
+	case codecSnappy:
+		return s2.EncodeSnappy(nil, data)
+	case codecZstd:
+		return zstdEncoder().EncodeAll(data, nil)
+	default:
+		return data
+	}
+}
+
+// countingWriter tracks the offset in the file
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// physical returns the physical type of the values of a column of type t
+func (t Type) physical() int32 {
+	switch t {
+	case Boolean:
+		return physicalBoolean
+	case Int64, Timestamp:
+		return physicalInt64
+	case Double:
+		return physicalDouble
+	default:
+		return physicalByteArray
+	}
+}
@@ -0,0 +1,308 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+	parquetgo "github.com/parquet-go/parquet-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// thriftReader decodes compact protocol structs into their fields by id
+type thriftReader struct {
+	buf []byte
+	pos int
+}
+
+func (r *thriftReader) readStruct() map[int16]any {
+	fields := map[int16]any{}
+	var last int16
+	for {
+		b := r.buf[r.pos]
+		r.pos++
+		if b == 0 {
+			return fields
+		}
+		id := last + int16(b>>4)
+		if b>>4 == 0 {
+			id = int16(r.varint())
+		}
+		last = id
+		fields[id] = r.value(b & 0x0f)
+	}
+}
+
+func (r *thriftReader) value(typ byte) any {
+	switch typ {
+	case thriftBooleanTrue:
+		return true
+	case thriftBooleanFalse:
+		return false
+	case thriftI32, thriftI64:
+		return r.varint()
+	case thriftBinary:
+		n := int(r.uvarint())
+		r.pos += n
+		return string(r.buf[r.pos-n : r.pos])
+	case thriftList:
+		header := r.buf[r.pos]
+		r.pos++
+		size := int(header >> 4)
+		if size == 15 {
+			size = int(r.uvarint())
+		}
+		list := make([]any, size)
+		for i := range list {
+			list[i] = r.value(header & 0x0f)
+		}
+		return list
+	case thriftStruct:
+		return r.readStruct()
+	default:
+		panic("unexpected thrift type")
+	}
+}
+
+func (r *thriftReader) varint() int64 {
+	v, n := binary.Varint(r.buf[r.pos:])
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.buf[r.pos:])
+	r.pos += n
+	return v
+}
+
+// readFile returns the metadata of a file and the rows of its row groups
+func readFile(t *testing.T, file []byte) (map[int16]any, [][]any) {
+	t.Helper()
+	require.Equal(t, magic, string(file[:4]))
+	require.Equal(t, magic, string(file[len(file)-4:]))
+	length := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+	footer := &thriftReader{buf: file[len(file)-8-length : len(file)-8]}
+	metadata := footer.readStruct()
+	require.Equal(t, length, footer.pos)
+
+	schema := metadata[2].([]any)[1:]
+	var rows [][]any
+	for _, group := range metadata[4].([]any) {
+		groupRows := make([][]any, group.(map[int16]any)[3].(int64))
+		for i := range groupRows {
+			groupRows[i] = make([]any, len(schema))
+		}
+		for c, chunk := range group.(map[int16]any)[1].([]any) {
+			meta := chunk.(map[int16]any)[3].(map[int16]any)
+			header := &thriftReader{buf: file, pos: int(meta[9].(int64))}
+			page := readPage(t, meta[4].(int64), header.readStruct(), file[header.pos:])
+			readColumn(t, schema[c].(map[int16]any)[1].(int64), page, groupRows, c)
+		}
+		rows = append(rows, groupRows...)
+	}
+	return metadata, rows
+}
+
+func readPage(t *testing.T, codec int64, header map[int16]any, data []byte) []byte {
+	t.Helper()
+	compressed := data[:header[3].(int64)]
+	var page []byte
+	var err error
+	switch codec {
+	case codecSnappy:
+		page, err = s2.Decode(nil, compressed)
+	case codecZstd:
+		var decoder *zstd.Decoder
+		decoder, err = zstd.NewReader(nil)
+		require.NoError(t, err)
+		page, err = decoder.DecodeAll(compressed, nil)
+	default:
+		page = compressed
+	}
+	require.NoError(t, err)
+	require.Len(t, page, int(header[2].(int64)))
+	return page
+}
+
+// readColumn decodes the RLE runs of definition levels and the plain values of a page into column c of rows
+func readColumn(t *testing.T, physical int64, page []byte, rows [][]any, c int) {
+	t.Helper()
+	r := &thriftReader{buf: page[4 : 4+binary.LittleEndian.Uint32(page)]}
+	var levels []bool
+	for r.pos < len(r.buf) {
+		run := int(r.uvarint() >> 1)
+		for range run {
+			levels = append(levels, r.buf[r.pos] == 1)
+		}
+		r.pos++
+	}
+	require.Len(t, levels, len(rows))
+
+	values := page[4+len(r.buf):]
+	n := 0
+	for i, defined := range levels {
+		if !defined {
+			continue
+		}
+		switch physical {
+		case physicalBoolean:
+			rows[i][c] = values[n/8]&(1<<(n%8)) != 0
+		case physicalInt64:
+			rows[i][c] = int64(binary.LittleEndian.Uint64(values))
+			values = values[8:]
+		case physicalDouble:
+			rows[i][c] = math.Float64frombits(binary.LittleEndian.Uint64(values))
+			values = values[8:]
+		case physicalByteArray:
+			length := binary.LittleEndian.Uint32(values)
+			rows[i][c] = string(values[4 : 4+length])
+			values = values[4+length:]
+		}
+		n++
+	}
+}
+
+func TestWrite(t *testing.T) {
+	columns := []Column{
+		{Name: "name", Type: String},
+		{Name: "enabled", Type: Boolean},
+		{Name: "count", Type: Int64},
+		{Name: "ratio", Type: Double},
+		{Name: "timestamp", Type: Timestamp},
+	}
+	timestamp := time.Date(2023, 10, 1, 12, 0, 0, 123456000, time.UTC)
+	rows := [][]any{
+		{"a", true, int64(1), 0.5, timestamp},
+		{nil, false, nil, -1.25, nil},
+		{"c", nil, int64(-3), nil, timestamp},
+	}
+
+	for _, compression := range []string{CompressionNone, CompressionSnappy, CompressionZstd} {
+		t.Run(compression, func(t *testing.T) {
+			var buf bytes.Buffer
+			require.NoError(t, Write(&buf, columns, rows, Options{Compression: compression, RowGroupSize: 2}))
+
+			metadata, written := readFile(t, buf.Bytes())
+			assert.Equal(t, int64(3), metadata[3])
+			assert.Len(t, metadata[4], 2)
+			assert.Equal(t, [][]any{
+				{"a", true, int64(1), 0.5, timestamp.UnixMicro()},
+				{nil, false, nil, -1.25, nil},
+				{"c", nil, int64(-3), nil, timestamp.UnixMicro()},
+			}, written)
+
+			schema := metadata[2].([]any)
+			require.Len(t, schema, 6)
+			assert.Equal(t, int64(5), schema[0].(map[int16]any)[5])
+			timestampColumn := schema[5].(map[int16]any)
+			assert.Equal(t, "timestamp", timestampColumn[4])
+			assert.Equal(t, map[int16]any{8: map[int16]any{1: true, 2: map[int16]any{2: map[int16]any{}}}}, timestampColumn[10])
+		})
+	}
+}
+
+// TestWrite_ReadByParquetGo reads files back with another implementation, which catches mistakes readFile shares
+// with the writer
+func TestWrite_ReadByParquetGo(t *testing.T) {
+	columns := []Column{
+		{Name: "name", Type: String},
+		{Name: "enabled", Type: Boolean},
+		{Name: "count", Type: Int64},
+		{Name: "ratio", Type: Double},
+		{Name: "timestamp", Type: Timestamp},
+	}
+	timestamp := time.Date(2023, 10, 1, 12, 0, 0, 123456000, time.UTC)
+	rows := [][]any{
+		{"a", true, int64(1), 0.5, timestamp},
+		{nil, false, nil, -1.25, nil},
+		{"c", nil, int64(-3), nil, timestamp},
+	}
+
+	for _, compression := range []string{CompressionNone, CompressionSnappy, CompressionZstd} {
+		t.Run(compression, func(t *testing.T) {
+			var buf bytes.Buffer
+			require.NoError(t, Write(&buf, columns, rows, Options{Compression: compression, RowGroupSize: 2}))
+
+			file, err := parquetgo.OpenFile(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			require.NoError(t, err)
+			assert.Equal(t, int64(3), file.NumRows())
+			assert.Len(t, file.RowGroups(), 2)
+
+			fields := file.Schema().Fields()
+			require.Len(t, fields, len(columns))
+			for i, column := range columns {
+				assert.Equal(t, column.Name, fields[i].Name())
+				assert.True(t, fields[i].Optional())
+			}
+			assert.NotNil(t, fields[0].Type().LogicalType().UTF8)
+			assert.Equal(t, parquetgo.Boolean, fields[1].Type().Kind())
+			assert.Equal(t, parquetgo.Int64, fields[2].Type().Kind())
+			assert.Equal(t, parquetgo.Double, fields[3].Type().Kind())
+			timestampType := fields[4].Type().LogicalType().Timestamp
+			require.NotNil(t, timestampType)
+			assert.True(t, timestampType.IsAdjustedToUTC)
+			assert.NotNil(t, timestampType.Unit.Micros)
+
+			read := make([]parquetgo.Row, file.NumRows())
+			n, err := parquetgo.NewReader(file).ReadRows(read)
+			if !errors.Is(err, io.EOF) {
+				require.NoError(t, err)
+			}
+			require.Equal(t, len(rows), n)
+			values := make([][]any, n)
+			for i, row := range read[:n] {
+				for _, value := range row {
+					values[i] = append(values[i], goValue(value))
+				}
+			}
+			assert.Equal(t, [][]any{
+				{"a", true, int64(1), 0.5, timestamp.UnixMicro()},
+				{nil, false, nil, -1.25, nil},
+				{"c", nil, int64(-3), nil, timestamp.UnixMicro()},
+			}, values)
+		})
+	}
+}
+
+// goValue returns the Go value of a value read by parquet-go
+func goValue(value parquetgo.Value) any {
+	if value.IsNull() {
+		return nil
+	}
+	switch value.Kind() {
+	case parquetgo.ByteArray:
+		return string(value.ByteArray())
+	case parquetgo.Boolean:
+		return value.Boolean()
+	case parquetgo.Int64:
+		return value.Int64()
+	case parquetgo.Double:
+		return value.Double()
+	default:
+		return value.String()
+	}
+}
+
+func TestWrite_NoRows(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, []Column{{Name: "name", Type: String}}, nil, Options{}))
+
+	metadata, rows := readFile(t, buf.Bytes())
+	assert.Equal(t, int64(0), metadata[3])
+	assert.Empty(t, rows)
+}
+
+func TestWrite_Invalid(t *testing.T) {
+	columns := []Column{{Name: "count", Type: Int64}}
+	require.Error(t, Write(&bytes.Buffer{}, columns, [][]any{{"1"}}, Options{}))
+	require.Error(t, Write(&bytes.Buffer{}, columns, nil, Options{Compression: "lz4"}))
+	require.Error(t, Options{RowGroupSize: -1}.Validate())
+}
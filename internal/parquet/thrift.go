@@ -0,0 +1,95 @@
+package parquet
+
+import (
+	"encoding/binary"
+)
+
+// Thrift compact protocol types, the file metadata and page headers of Parquet files are encoded with it
+const (
+	thriftBooleanTrue  = 1
+	thriftBooleanFalse = 2
+	thriftI32          = 5
+	thriftI64          = 6
+	thriftBinary       = 8
+	thriftList         = 9
+	thriftStruct       = 12
+)
+
+// compactWriter encodes thrift structs with the compact protocol
+type compactWriter struct {
+	buf []byte
+	// lastID is the id of the previous field of the current struct, ids is the stack of the enclosing structs
+	lastID int16
+	ids    []int16
+}
+
+func (w *compactWriter) fieldHeader(id int16, typ byte) {
+	if delta := id - w.lastID; delta > 0 && delta <= 15 {
+		w.buf = append(w.buf, byte(delta)<<4|typ)
+	} else {
+		w.buf = append(w.buf, typ)
+		w.buf = binary.AppendVarint(w.buf, int64(id))
+	}
+	w.lastID = id
+}
+
+func (w *compactWriter) boolField(id int16, value bool) {
+	if value {
+		w.fieldHeader(id, thriftBooleanTrue)
+	} else {
+		w.fieldHeader(id, thriftBooleanFalse)
+	}
+}
+
+func (w *compactWriter) i32Field(id int16, value int32) {
+	w.fieldHeader(id, thriftI32)
+	w.i32(value)
+}
+
+func (w *compactWriter) i64Field(id int16, value int64) {
+	w.fieldHeader(id, thriftI64)
+	w.buf = binary.AppendVarint(w.buf, value)
+}
+
+func (w *compactWriter) stringField(id int16, value string) {
+	w.fieldHeader(id, thriftBinary)
+	w.string(value)
+}
+
+// listField writes the header of a list field, its size elements of elemType follow
+func (w *compactWriter) listField(id int16, elemType byte, size int) {
+	w.fieldHeader(id, thriftList)
+	if size < 15 {
+		w.buf = append(w.buf, byte(size)<<4|elemType)
+		return
+	}
+	w.buf = append(w.buf, 0xf0|elemType)
+	w.buf = binary.AppendUvarint(w.buf, uint64(size))
+}
+
+// structField writes the header of a struct field, its fields follow until endStruct
+func (w *compactWriter) structField(id int16) {
+	w.fieldHeader(id, thriftStruct)
+	w.beginStruct()
+}
+
+// beginStruct starts a struct, it is called directly for the elements of lists
+func (w *compactWriter) beginStruct() {
+	w.ids = append(w.ids, w.lastID)
+	w.lastID = 0
+}
+
+func (w *compactWriter) endStruct() {
+	w.buf = append(w.buf, 0)
+	w.lastID = w.ids[len(w.ids)-1]
+	w.ids = w.ids[:len(w.ids)-1]
+}
+
+func (w *compactWriter) i32(value int32) {
+	w.buf = binary.AppendVarint(w.buf, int64(value))
+}
+
+func (w *compactWriter) string(value string) {
+	w.buf = binary.AppendUvarint(w.buf, uint64(len(value)))
+	w.buf = append(w.buf, value...)
+}
@@ -5,6 +5,8 @@ import (
 	"errors"
 	"net/http"
 	"net/http/pprof"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/gorilla/handlers"
@@ -17,7 +19,11 @@ import (
 	"github.com/qlik-trial/go-service-kit/v29/operation"
 	"github.com/qlik-trial/usage-telemetry-publisher/cmd/config"
 	"github.com/qlik-trial/usage-telemetry-publisher/cmd/version"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/auth"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/avro"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/deadletter"
 	"github.com/qlik-trial/usage-telemetry-publisher/internal/dependencies"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/vault"
 	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
 )
 
@@ -52,6 +58,12 @@ func BuildUsageTelemetryPublisherAPIServer(appCtx *dependencies.ApplicationConte
 	if config.Global.MessagingEnabled {
 		appCtx.MessagingClient.AddReadinessCheck(healthHandler)
 	}
+	// with intermediate storage a sink outage only grows the write-ahead log, so only its health affects readiness
+	if appCtx.IntermediateStorage != nil {
+		healthHandler.AddReadinessCheck("intermediate_storage", appCtx.IntermediateStorage.Healthy)
+	} else {
+		healthHandler.AddReadinessCheck("sink", appCtx.Sink.Healthy)
+	}
 	router.Methods(http.MethodGet).Path("/health").Name("health").HandlerFunc(healthHandler.LiveEndpoint)
 	router.Methods(http.MethodGet).Path("/ready").Name("ready").HandlerFunc(healthHandler.ReadyEndpoint)
 
@@ -67,12 +79,36 @@ func BuildUsageTelemetryPublisherAPIServer(appCtx *dependencies.ApplicationConte
 	subrouter.Use(otelmux.Middleware(config.ServiceName))
 	subrouter.Use(metricsMiddleware)
 
+	// Add the endpoints consumers of avro output fetch its schema from
+	avro.RegisterRoutes(subrouter, appCtx.AvroSchemas)
+
+	// Add admin endpoints
+	adminRouter := subrouter.PathPrefix("/admin").Subrouter()
+	registerAdminRoutes(adminRouter, appCtx)
+
 	return &APIServer{
 		address: config.Global.HTTPAddr,
 		handler: handlers.RecoveryHandler()(router),
 	}
 }
 
+// registerAdminRoutes adds the dead letter endpoints and, when the token vault is enabled, the detokenize endpoint.
+// Dead letters hold tenant event payloads and detokenizing reverses pseudonyms, so each endpoint requires a token
+// with its scope and none is served without auth.
+func registerAdminRoutes(router *mux.Router, appCtx *dependencies.ApplicationContext) {
+	label := "api_server/registerAdminRoutes"
+	if !config.Global.AuthEnabled {
+		operation.Logger(context.TODO()).Warn("label", label, "message", "auth is disabled, the admin endpoints are not served")
+		return
+	}
+	keysURL := strings.TrimSuffix(config.Global.KeysUri, "/") + "/v1/keys/" + url.PathEscape(config.Global.AuthJwtIss)
+	verifier := auth.NewVerifier(context.TODO(), keysURL, config.Global.AuthJwtIss, config.Global.AuthJwtAud)
+	deadletter.RegisterRoutes(router, appCtx.DeadLetters, auth.RequireScope(verifier, config.Global.DeadLettersScope))
+	if appCtx.TokenVault != nil {
+		vault.RegisterRoutes(router, appCtx.TokenVault, auth.RequireScope(verifier, config.Global.DetokenizeScope))
+	}
+}
+
 // Start starts a RunnableHttpServer
 func (svr *APIServer) Start(ctx context.Context) error {
 	label := "api_server/Start"
@@ -1,14 +1,32 @@
 package processes
 
 import (
+	"time"
+
 	"github.com/qlik-trial/go-service-kit/v29/application"
+	"github.com/qlik-trial/usage-telemetry-publisher/cmd/config"
 	"github.com/qlik-trial/usage-telemetry-publisher/internal/dependencies"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/wal"
 )
 
 // BuildAppProcesses builds all processes of the usage-telemetry-publisher application
 var BuildAppProcesses = func(appCtx *dependencies.ApplicationContext) map[string]application.Runnable {
 	processes := map[string]application.Runnable{
 		"UsageTelemetryPublisherAPIServer": BuildUsageTelemetryPublisherAPIServer(appCtx),
+		"EventsPolicyWatcher":              appCtx.EventsPolicy,
+	}
+
+	if appCtx.IntermediateStorage != nil {
+		processes["IntermediateStorageForwarder"] = wal.NewForwarder(appCtx.IntermediateStorage, appCtx.Sink, wal.ForwarderOptions{
+			BatchSize:      config.Global.IntermediateStorageForwardBatchSize,
+			InitialBackoff: 500 * time.Millisecond,
+			MaxBackoff:     30 * time.Second,
+			DeadLetters:    appCtx.DeadLetters,
+		})
+	}
+
+	if appCtx.DedupFilter != nil {
+		processes["DedupFilter"] = appCtx.DedupFilter
 	}
 
 	return processes
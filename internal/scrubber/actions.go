@@ -0,0 +1,124 @@
+package scrubber
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// eventKeys are the secrets the values of a single event are scrubbed with
+type eventKeys struct {
+	// pseudonym is used by pseudonymize, which redacts values when it is nil
+	pseudonym *Key
+	// vault keeps the values behind pseudonyms when tokenization is enabled
+	vault Vault
+	// tenantSalt is used by tenant_hash, which redacts values when it is nil
+	tenantSalt []byte
+}
+
+// apply returns the value r replaces value with, or false if the value is dropped
+func apply(r *rule, value any, keys eventKeys) (any, bool) {
+	switch r.action {
+	case ActionDrop:
+		return nil, false
+	case ActionHash:
+		return hashValue(value), true
+	case ActionPseudonymize:
+		if keys.pseudonym == nil {
+			return RedactedValue, true
+		}
+		token := keys.pseudonym.pseudonym(stringValue(value))
+		if keys.vault != nil {
+			// the pseudonym is published either way, it just cannot be reversed if storing it failed
+			if err := keys.vault.Store(token, stringValue(value)); err != nil {
+				tokenizeFailuresCounter.Inc()
+			}
+		}
+		return token, true
+	case ActionTenantHash:
+		if keys.tenantSalt == nil {
+			return RedactedValue, true
+		}
+		return tenantHash(keys.tenantSalt, stringValue(value)), true
+	case ActionRedact:
+		return RedactedValue, true
+	case ActionTruncate:
+		if s, ok := value.(string); ok {
+			return truncate(s, r.length), true
+		}
+		return value, true
+	case ActionGeneralize:
+		return generalize(value, r.bucket, r.ipv6PrefixLength), true
+	case ActionSanitizeURL:
+		if s, ok := value.(string); ok {
+			if sanitized, ok := sanitizeURL(s, r.queryAllowlist); ok {
+				return sanitized, true
+			}
+		}
+		return RedactedValue, true
+	default:
+		return value, true
+	}
+}
+
+// hashValue returns the SHA-256 hex digest of a string, or of the JSON encoding of any other value
+func hashValue(value any) string {
+	sum := sha256.Sum256([]byte(stringValue(value)))
+	return hex.EncodeToString(sum[:])
+}
+
+// stringValue returns a string as is and the JSON encoding of any other value
+func stringValue(value any) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	if encoded, err := json.Marshal(value); err == nil {
+		return string(encoded)
+	}
+	return fmt.Sprint(value)
+}
+
+// truncate keeps the first length characters of s
+func truncate(s string, length int) string {
+	if utf8.RuneCountInString(s) <= length {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:length])
+}
+
+// generalize reduces the precision of value: IP addresses and lists of them are cut to their network prefix,
+// timestamps to the hour and numbers are rounded down to a multiple of bucket. Booleans are kept and other values are redacted.
+func generalize(value any, bucket float64, ipv6PrefixLength int) any {
+	switch v := value.(type) {
+	case string:
+		if ips, ok := generalizeIPList(v, ipv6PrefixLength); ok {
+			return ips
+		}
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return t.Truncate(time.Hour).Format(time.RFC3339)
+		}
+		return RedactedValue
+	case float64:
+		return math.Floor(v/bucket) * bucket
+	case int:
+		return int(math.Floor(float64(v)/bucket) * bucket)
+	case int64:
+		return int64(math.Floor(float64(v)/bucket) * bucket)
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return RedactedValue
+		}
+		return json.Number(strconv.FormatFloat(math.Floor(f/bucket)*bucket, 'f', -1, 64))
+	case bool, nil:
+		return v
+	default:
+		return RedactedValue
+	}
+}
@@ -0,0 +1,48 @@
+package scrubber
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGeneralize(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    any
+		bucket   float64
+		expected any
+	}{
+		{"ipv4", "192.168.1.17", 10, "192.168.1.0"},
+		{"ipv4-mapped ipv6", "::ffff:192.168.1.17", 10, "192.168.1.0"},
+		{"ipv6", "2001:db8:85a3:8d3:1319:8a2e:370:7348", 10, "2001:db8:85a3::"},
+		{"forwarded for list", "203.0.113.195, 2001:db8:85a3:8d3:1319:8a2e:370:7348,10.0.0.1", 10, "203.0.113.0, 2001:db8:85a3::, 10.0.0.0"},
+		{"list with other values", "203.0.113.195, unknown", 10, RedactedValue},
+		{"timestamp", "2025-08-21T10:42:13.123Z", 10, "2025-08-21T10:00:00Z"},
+		{"float", 1234.5, 100, float64(1200)},
+		{"int", 57, 10, 50},
+		{"json number", json.Number("57"), 10, json.Number("50")},
+		{"bool", true, 10, true},
+		{"other string", "Jane Doe", 10, RedactedValue},
+		{"object", map[string]any{"a": 1}, 10, RedactedValue},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, generalize(test.value, test.bucket, defaultIPv6PrefixLength))
+		})
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	assert.Equal(t, "abc", truncate("abc", 5))
+	assert.Equal(t, "ab", truncate("abc", 2))
+	assert.Equal(t, "日本", truncate("日本語", 2))
+}
+
+func TestHashValue(t *testing.T) {
+	assert.Equal(t, "a665a45920422f9d417e4867efdc4fb8a04a1f3fff1fa07e998e86f7f7a27ae3", hashValue("123"))
+	assert.Equal(t, hashValue("123"), hashValue(json.RawMessage("123")), "non-strings hash their JSON encoding")
+	assert.Equal(t, hashValue(map[string]any{"a": 1, "b": 2}), hashValue(map[string]any{"b": 2, "a": 1}))
+}
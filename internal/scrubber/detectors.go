@@ -0,0 +1,151 @@
+package scrubber
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// Detectors flagging personal data inside data strings
+const (
+	DetectorEmail       = "email"
+	DetectorPhone       = "phone"
+	DetectorCreditCard  = "credit_card"
+	DetectorJWT         = "jwt"
+	DetectorBearerToken = "bearer_token"
+	// DetectorName flags the values of keys naming a person, such as firstName or display_name
+	DetectorName = "name"
+)
+
+// detector finds personal data in a string, either by pattern or, when it has a key pattern, by the key of the value
+type detector struct {
+	name string
+	// pattern matches candidates in the value
+	pattern *regexp.Regexp
+	// valid confirms a candidate, cutting false positives
+	valid func(match string) bool
+	// prefix is the length of the part of a match that is kept, e.g. the scheme of an authorization header
+	prefix func(match string) int
+	// key matches the keys whose whole value is personal data
+	key *regexp.Regexp
+}
+
+// detectors run in this order, so tokens are replaced before their content could be mistaken for other data
+var detectors = []*detector{
+	{
+		name:    DetectorJWT,
+		pattern: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`),
+		valid:   isJWT,
+	},
+	{
+		name:    DetectorBearerToken,
+		pattern: regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9\-._~+/]+=*`),
+		prefix: func(match string) int {
+			// keeps the scheme and the whitespace after it
+			return len(match) - len(strings.TrimLeft(match[len("bearer"):], " \t\r\n"))
+		},
+	},
+	{
+		name:    DetectorCreditCard,
+		pattern: regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`),
+		valid:   isLuhnValid,
+	},
+	{
+		name:    DetectorEmail,
+		pattern: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`),
+	},
+	{
+		name:    DetectorPhone,
+		pattern: regexp.MustCompile(`(?:\+\d{1,3}[\s-]?)?(?:\(\d{1,4}\)[\s-]?|\b\d{2,4}[\s-])\d{3,4}[\s-]?\d{3,4}\b`),
+		valid: func(match string) bool {
+			n := countDigits(match)
+			return n >= 7 && n <= 15
+		},
+	},
+	{
+		name: DetectorName,
+		key:  regexp.MustCompile(`(?i)^(first|last|full|given|family|middle|display|sur|user|person|contact|customer)[_-]?name$`),
+	},
+}
+
+// detectorByName returns the detector called name, or nil
+func detectorByName(name string) *detector {
+	for _, d := range detectors {
+		if d.name == name {
+			return d
+		}
+	}
+	return nil
+}
+
+// replace replaces the personal data found in value with what replacement returns for it,
+// returning the new value and the number of hits
+func (d *detector) replace(key, value string, replacement func(match string) string) (string, int) {
+	if d.key != nil {
+		if value == "" || !d.key.MatchString(key) {
+			return value, 0
+		}
+		return replacement(value), 1
+	}
+
+	hits := 0
+	replaced := d.pattern.ReplaceAllStringFunc(value, func(match string) string {
+		if d.valid != nil && !d.valid(match) {
+			return match
+		}
+		hits++
+		prefix := 0
+		if d.prefix != nil {
+			prefix = d.prefix(match)
+		}
+		return match[:prefix] + replacement(match[prefix:])
+	})
+	return replaced, hits
+}
+
+// isJWT checks that the header of a JWT candidate is base64url encoded JSON naming an algorithm
+func isJWT(match string) bool {
+	header, _, _ := strings.Cut(match, ".")
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(header, "="))
+	if err != nil {
+		return false
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(decoded, &fields); err != nil {
+		return false
+	}
+	_, ok := fields["alg"]
+	return ok
+}
+
+// isLuhnValid checks the Luhn checksum of the digits of a card number candidate
+func isLuhnValid(match string) bool {
+	sum, n := 0, 0
+	for i := len(match) - 1; i >= 0; i-- {
+		c := match[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		digit := int(c - '0')
+		if n%2 == 1 {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		n++
+	}
+	return n >= 13 && n <= 19 && sum%10 == 0
+}
+
+func countDigits(s string) int {
+	n := 0
+	for _, c := range s {
+		if c >= '0' && c <= '9' {
+			n++
+		}
+	}
+	return n
+}
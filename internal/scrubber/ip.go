@@ -0,0 +1,55 @@
+package scrubber
+
+import (
+	"net"
+	"regexp"
+	"strings"
+)
+
+const (
+	// ipv4PrefixLength is the prefix IPv4 addresses are generalized to, it zeroes the last octet
+	ipv4PrefixLength = 24
+	// defaultIPv6PrefixLength is the prefix IPv6 addresses are generalized to unless the policy configures one
+	defaultIPv6PrefixLength = 48
+)
+
+// ipCandidate matches the tokens of a string that may be IP addresses, net.ParseIP decides whether they are
+var ipCandidate = regexp.MustCompile(`[0-9A-Fa-f]*[.:][0-9A-Fa-f.:]+`)
+
+// generalizeIP zeroes the host part of ip. IPv4-mapped IPv6 addresses are generalized, and written, as IPv4.
+func generalizeIP(ip net.IP, ipv6PrefixLength int) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(ipv4PrefixLength, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(ipv6PrefixLength, 128)).String()
+}
+
+// generalizeIPList generalizes a single IP address or an X-Forwarded-For style comma separated list of them.
+// It returns false if s is not such a list.
+func generalizeIPList(s string, ipv6PrefixLength int) (string, bool) {
+	parts := strings.Split(s, ",")
+	for i, part := range parts {
+		ip := net.ParseIP(strings.TrimSpace(part))
+		if ip == nil {
+			return "", false
+		}
+		parts[i] = generalizeIP(ip, ipv6PrefixLength)
+	}
+	return strings.Join(parts, ", "), true
+}
+
+// generalizeEmbeddedIPs generalizes the IP addresses found anywhere in s, returning false if it contains none
+func generalizeEmbeddedIPs(s string, ipv6PrefixLength int) (string, bool) {
+	found := false
+	generalized := ipCandidate.ReplaceAllStringFunc(s, func(token string) string {
+		// sentence punctuation is not part of the address
+		candidate := strings.TrimRight(token, ".:")
+		ip := net.ParseIP(candidate)
+		if ip == nil {
+			return token
+		}
+		found = true
+		return generalizeIP(ip, ipv6PrefixLength) + token[len(candidate):]
+	})
+	return generalized, found
+}
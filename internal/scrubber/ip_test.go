@@ -0,0 +1,48 @@
+package scrubber
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGeneralizeIP(t *testing.T) {
+	tests := []struct {
+		ip           string
+		prefixLength int
+		expected     string
+	}{
+		{"192.168.1.17", 48, "192.168.1.0"},
+		{"::ffff:192.168.1.17", 48, "192.168.1.0"},
+		{"2001:db8:85a3:8d3:1319:8a2e:370:7348", 48, "2001:db8:85a3::"},
+		{"2001:db8:85a3:8d3:1319:8a2e:370:7348", 32, "2001:db8::"},
+		{"2001:db8:85a3:8d3:1319:8a2e:370:7348", 64, "2001:db8:85a3:8d3::"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, generalizeIP(net.ParseIP(test.ip), test.prefixLength), test.ip)
+	}
+}
+
+func TestGeneralizeEmbeddedIPs(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+		found    bool
+	}{
+		{"client 203.0.113.195 connected.", "client 203.0.113.0 connected.", true},
+		{"from 10.1.2.3.", "from 10.1.2.0.", true},
+		{"peer=[2001:db8:85a3:8d3::1]:443", "peer=[2001:db8:85a3::]:443", true},
+		{"203.0.113.195,10.0.0.1", "203.0.113.0,10.0.0.0", true},
+		{"at 2025-08-21T10:42:13Z", "at 2025-08-21T10:42:13Z", false},
+		{"cafe: open", "cafe: open", false},
+		{"no addresses", "no addresses", false},
+	}
+
+	for _, test := range tests {
+		generalized, found := generalizeEmbeddedIPs(test.value, defaultIPv6PrefixLength)
+		assert.Equal(t, test.expected, generalized, test.value)
+		assert.Equal(t, test.found, found, test.value)
+	}
+}
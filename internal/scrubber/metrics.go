@@ -0,0 +1,27 @@
+package scrubber
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// rulesFiredCounter counts the events each scrub rule fired on
+var rulesFiredCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "usage_telemetry_publisher",
+	Name:      "scrub_rules_fired_total",
+	Help:      "Number of events a scrub rule fired on, by rule name",
+}, []string{"rule"})
+
+// detectorHitsCounter counts the personal data each detector found in data strings
+var detectorHitsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "usage_telemetry_publisher",
+	Name:      "pii_detector_hits_total",
+	Help:      "Number of personal data matches found in event data, by detector",
+}, []string{"detector"})
+
+// tokenizeFailuresCounter counts the pseudonyms whose values could not be stored in the tokenization vault
+var tokenizeFailuresCounter = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: "usage_telemetry_publisher",
+	Name:      "tokenize_failures_total",
+	Help:      "Number of pseudonyms published without their value being stored in the tokenization vault",
+})
@@ -0,0 +1,151 @@
+package scrubber
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// OverlaySpec changes the base scrub policy for the tenants it is assigned to
+type OverlaySpec struct {
+	// Attributes replace the base rules of the same attributes, other base rules still apply
+	Attributes []RuleSpec `yaml:"attributes"`
+	// Data take precedence over the base data rules
+	Data []RuleSpec `yaml:"data"`
+	// DefaultDataAction replaces the base default data action when set
+	DefaultDataAction string `yaml:"defaultDataAction"`
+	ScanDataForIPs    *bool  `yaml:"scanDataForIPs"`
+	IPv6PrefixLength  int    `yaml:"ipv6PrefixLength"`
+	ScanDataForURLs   *bool  `yaml:"scanDataForURLs"`
+	// URLQueryAllowlist replaces the base allowlist when set
+	URLQueryAllowlist []string `yaml:"urlQueryAllowlist"`
+	// Detectors replace the base rules of the same detectors, other base rules still apply
+	Detectors []RuleSpec `yaml:"detectors"`
+}
+
+// OverlaysSpec is the content of the scrub policy overlays file
+type OverlaysSpec struct {
+	// Overlays are the overlays by name
+	Overlays map[string]OverlaySpec `yaml:"overlays"`
+	// Tenants assigns overlays to tenant ids
+	Tenants map[string]string `yaml:"tenants"`
+}
+
+// Overlays resolve the scrub policy of a tenant from the base policy and the overlay assigned to the tenant
+type Overlays struct {
+	base     *Policy
+	policies map[string]*Policy
+	tenants  map[string]string
+}
+
+// ErrUnknownOverlay is returned by Resolve for an overlay name the overlays file does not define
+var ErrUnknownOverlay = errors.New("unknown scrub policy overlay")
+
+// ParseOverlays creates the Overlays of base from the YAML content of an overlays file.
+// Every overlay is merged into the base policy and compiled up front, so Resolve never fails on a bad rule.
+// The resolved policies use the keys, tenant secret and vault of base.
+func ParseOverlays(base *Policy, data []byte) (*Overlays, error) {
+	var spec OverlaysSpec
+	if err := yaml.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("failed to parse scrub policy overlays file: %w", err)
+	}
+
+	overlays := &Overlays{base: base, policies: map[string]*Policy{}, tenants: spec.Tenants}
+	for name, overlay := range spec.Overlays {
+		policy, err := New(merge(base.spec, overlay))
+		if err != nil {
+			return nil, fmt.Errorf("overlay %q: %w", name, err)
+		}
+		encoded, err := yaml.Marshal(overlay)
+		if err != nil {
+			return nil, fmt.Errorf("overlay %q: %w", name, err)
+		}
+		policy.version = base.version + "+" + name + "." + hash(encoded)
+		policy.key, policy.tenantSecret, policy.vault = base.key, base.tenantSecret, base.vault
+		overlays.policies[name] = policy
+	}
+	for tenantID, name := range spec.Tenants {
+		if _, ok := overlays.policies[name]; !ok {
+			return nil, fmt.Errorf("tenant %q: %w %q", tenantID, ErrUnknownOverlay, name)
+		}
+	}
+	return overlays, nil
+}
+
+// LoadOverlays reads and parses the overlays file at filePath, a missing file yields no overlays
+func LoadOverlays(base *Policy, filePath string) (*Overlays, error) {
+	data, err := os.ReadFile(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return &Overlays{base: base}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scrub policy overlays file: %w", err)
+	}
+	return ParseOverlays(base, data)
+}
+
+// Resolve returns the policy of a tenant: the overlay named selected, else the overlay assigned to the tenant,
+// else the base policy. A selected overlay that does not exist returns ErrUnknownOverlay along with the policy
+// the tenant would get without it.
+func (o *Overlays) Resolve(tenantID, selected string) (*Policy, error) {
+	var err error
+	if selected != "" {
+		if policy, ok := o.policies[selected]; ok {
+			return policy, nil
+		}
+		err = fmt.Errorf("%w %q", ErrUnknownOverlay, selected)
+	}
+	if policy, ok := o.policies[o.tenants[tenantID]]; ok {
+		return policy, err
+	}
+	return o.base, err
+}
+
+// Len returns the number of overlays
+func (o *Overlays) Len() int {
+	return len(o.policies)
+}
+
+// merge returns base changed by overlay
+func merge(base Spec, overlay OverlaySpec) Spec {
+	merged := base
+	merged.Attributes = mergeRules(base.Attributes, overlay.Attributes, func(r RuleSpec) string { return r.Attribute })
+	merged.Data = append(append([]RuleSpec(nil), overlay.Data...), base.Data...)
+	merged.Detectors = mergeRules(base.Detectors, overlay.Detectors, func(r RuleSpec) string { return r.Detector })
+	if overlay.DefaultDataAction != "" {
+		merged.DefaultDataAction = overlay.DefaultDataAction
+	}
+	if overlay.ScanDataForIPs != nil {
+		merged.ScanDataForIPs = *overlay.ScanDataForIPs
+	}
+	if overlay.IPv6PrefixLength != 0 {
+		merged.IPv6PrefixLength = overlay.IPv6PrefixLength
+	}
+	if overlay.ScanDataForURLs != nil {
+		merged.ScanDataForURLs = *overlay.ScanDataForURLs
+	}
+	if overlay.URLQueryAllowlist != nil {
+		merged.URLQueryAllowlist = overlay.URLQueryAllowlist
+	}
+	return merged
+}
+
+// mergeRules replaces the base rules with the overlay rules of the same key and appends the other overlay rules
+func mergeRules(base, overlay []RuleSpec, key func(RuleSpec) string) []RuleSpec {
+	normalize := func(r RuleSpec) string { return strings.ToLower(strings.TrimSpace(key(r))) }
+	replaced := map[string]bool{}
+	for _, r := range overlay {
+		replaced[normalize(r)] = true
+	}
+
+	var merged []RuleSpec
+	for _, r := range base {
+		if !replaced[normalize(r)] {
+			merged = append(merged, r)
+		}
+	}
+	return append(merged, overlay...)
+}
@@ -0,0 +1,131 @@
+package scrubber
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testOverlays = `
+overlays:
+  strict:
+    attributes:
+      - attribute: sessionid
+        action: drop
+    data:
+      - path: query
+        action: redact
+    scanDataForURLs: true
+  rich:
+    attributes:
+      - attribute: userid
+        action: keep
+    scanDataForIPs: false
+tenants:
+  tenant-strict: strict
+`
+
+func TestParseOverlays(t *testing.T) {
+	base, err := Parse([]byte(`
+attributes:
+  - attribute: userid
+    action: hash
+  - attribute: sessionid
+    action: hash
+data:
+  - path: query
+    action: keep
+scanDataForIPs: true
+`))
+	require.NoError(t, err)
+	overlays, err := ParseOverlays(base, []byte(testOverlays))
+	require.NoError(t, err)
+	assert.Equal(t, 2, overlays.Len())
+
+	event := func(tenantID string) model.CloudEvent {
+		return model.CloudEvent{
+			Id:        "test-id",
+			TenantId:  tenantID,
+			UserId:    "user-1",
+			SessionId: "session-1",
+			Data:      map[string]any{"query": "revenue", "peer": "10.1.2.3", "url": "https://example.com/a?q=jane"},
+		}
+	}
+
+	t.Run("tenant without overlay gets the base policy", func(t *testing.T) {
+		policy, err := overlays.Resolve("tenant-other", "")
+		require.NoError(t, err)
+		assert.Same(t, base, policy)
+	})
+
+	t.Run("tenant gets its assigned overlay", func(t *testing.T) {
+		policy, err := overlays.Resolve("tenant-strict", "")
+		require.NoError(t, err)
+		result, _ := ScrubEvent(event("tenant-strict"), policy)
+		assert.Empty(t, result.SessionId)
+		assert.Equal(t, hashValue("user-1"), result.UserId, "base rules of other attributes still apply")
+		assert.Equal(t, map[string]any{"query": RedactedValue, "peer": "10.1.2.0", "url": "https://example.com/a"}, result.Data)
+		assert.Regexp(t, `^[0-9a-f]{12}\+strict\.[0-9a-f]{12}$`, result.ScrubPolicyVersion)
+	})
+
+	t.Run("selected overlay takes precedence", func(t *testing.T) {
+		policy, err := overlays.Resolve("tenant-strict", "rich")
+		require.NoError(t, err)
+		result, _ := ScrubEvent(event("tenant-strict"), policy)
+		assert.Equal(t, "user-1", result.UserId)
+		assert.Equal(t, hashValue("session-1"), result.SessionId)
+		assert.Equal(t, "10.1.2.3", result.Data["peer"])
+		assert.Contains(t, result.ScrubPolicyVersion, "+rich.")
+	})
+
+	t.Run("unknown selected overlay falls back", func(t *testing.T) {
+		policy, err := overlays.Resolve("tenant-strict", "missing")
+		require.ErrorIs(t, err, ErrUnknownOverlay)
+		assert.Same(t, overlays.policies["strict"], policy)
+	})
+}
+
+func TestParseOverlays_KeepsKeys(t *testing.T) {
+	key := NewKey("k1", []byte("secret"))
+	base := Default().WithKey(key).WithTenantSecret([]byte("master"))
+	overlays, err := ParseOverlays(base, []byte(testOverlays))
+	require.NoError(t, err)
+
+	policy, err := overlays.Resolve("tenant-strict", "")
+	require.NoError(t, err)
+	result, _ := ScrubEvent(model.CloudEvent{Id: "test-id", TenantId: "tenant-strict", UserId: "user-1", SpaceId: "space-1"}, policy)
+	assert.Equal(t, key.pseudonym("user-1"), result.UserId)
+	assert.Equal(t, tenantHash(tenantSalt([]byte("master"), "tenant-strict"), "space-1"), result.SpaceId)
+	assert.Equal(t, "none+strict", result.ScrubPolicyVersion[:len("none+strict")])
+}
+
+func TestParseOverlays_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"malformed yaml":  "overlays: [",
+		"invalid rule":    "overlays: {strict: {attributes: [{attribute: email, action: drop}]}}",
+		"unknown overlay": "tenants: {tenant-a: strict}",
+	}
+
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseOverlays(KeepAll(), []byte(content))
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestLoadOverlays(t *testing.T) {
+	overlays, err := LoadOverlays(KeepAll(), filepath.Join(t.TempDir(), "missing.yaml"))
+	require.NoError(t, err)
+	assert.Zero(t, overlays.Len())
+
+	filePath := filepath.Join(t.TempDir(), "scrub-overlays.yaml")
+	require.NoError(t, os.WriteFile(filePath, []byte(testOverlays), 0o600))
+	overlays, err = LoadOverlays(KeepAll(), filePath)
+	require.NoError(t, err)
+	assert.Equal(t, 2, overlays.Len())
+}
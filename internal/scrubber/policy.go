@@ -0,0 +1,415 @@
+package scrubber
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Actions a scrub rule applies to the value it matches
+const (
+	// ActionKeep leaves the value unchanged
+	ActionKeep = "keep"
+	// ActionDrop removes the value
+	ActionDrop = "drop"
+	// ActionHash replaces the value with its SHA-256 hex digest
+	ActionHash = "hash"
+	// ActionPseudonymize replaces the value with its HMAC-SHA256 hex digest under the policy's Key,
+	// values are redacted when the policy has no key
+	ActionPseudonymize = "pseudonymize"
+	// ActionRedact replaces the value with RedactedValue
+	ActionRedact = "redact"
+	// ActionTruncate keeps the first Length characters of a string
+	ActionTruncate = "truncate"
+	// ActionGeneralize reduces the precision of IP addresses, timestamps and numbers, other values are redacted
+	ActionGeneralize = "generalize"
+	// ActionSanitizeURL strips the userinfo, fragment and the query parameters not in the query allowlist of a URL
+	// and replaces the path segments holding ids or emails with {id} and {email}, other values are redacted
+	ActionSanitizeURL = "sanitize_url"
+	// ActionTenantHash replaces the value with its HMAC-SHA256 hex digest under a salt derived from the policy's
+	// tenant secret and the event's tenant id, so values can be joined within a tenant but not across tenants.
+	// Values are redacted when the policy has no tenant secret.
+	ActionTenantHash = "tenant_hash"
+)
+
+// RedactedValue replaces redacted values
+const RedactedValue = "[REDACTED]"
+
+// redactedAs returns the value replacing a redacted match of a detector, naming what was found
+func redactedAs(kind string) string {
+	return "[REDACTED:" + kind + "]"
+}
+
+// versionNone is the version of the built-in default policy
+const versionNone = "none"
+
+var actions = []string{ActionKeep, ActionDrop, ActionHash, ActionPseudonymize, ActionRedact, ActionTruncate, ActionGeneralize, ActionSanitizeURL, ActionTenantHash}
+
+// detectorActions are the actions a detector rule may replace its matches with
+var detectorActions = []string{ActionHash, ActionPseudonymize, ActionRedact}
+
+// attributes are the CloudEvent attribute names envelope rules may refer to
+var attributes = []string{
+	"id", "specversion", "tenantid", "userid", "sessionid", "source", "type", "time", "subject",
+	"host", "originip", "ownerid", "toplevelresourceid", "spaceid", "clientid", "reason", "dataschema",
+}
+
+// Spec is the content of the scrub policy file
+type Spec struct {
+	// Attributes are the rules of the envelope attributes, attributes without a rule are kept
+	Attributes []RuleSpec `yaml:"attributes"`
+	// Data are the rules of the data paths, the first rule matching a path applies
+	Data []RuleSpec `yaml:"data"`
+	// DefaultDataAction applies to data values no rule matches, it defaults to keep
+	DefaultDataAction string `yaml:"defaultDataAction"`
+	// ScanDataForIPs generalizes IP addresses found in data strings no rule matches
+	ScanDataForIPs bool `yaml:"scanDataForIPs"`
+	// IPv6PrefixLength is the prefix IPv6 addresses are generalized to, it defaults to 48
+	IPv6PrefixLength int `yaml:"ipv6PrefixLength"`
+	// ScanDataForURLs sanitizes http(s) URLs found in data strings no rule matches
+	ScanDataForURLs bool `yaml:"scanDataForURLs"`
+	// URLQueryAllowlist are the query parameters sanitized URLs keep, all others are dropped
+	URLQueryAllowlist []string `yaml:"urlQueryAllowlist"`
+	// Detectors are the rules of the personal data detectors run over data strings no rule matches,
+	// detectors without a rule do not run
+	Detectors []RuleSpec `yaml:"detectors"`
+}
+
+// RuleSpec assigns an action to an envelope attribute or a data path
+type RuleSpec struct {
+	// Name identifies the rule when it fires, it defaults to the attribute or path and the action
+	Name string `yaml:"name"`
+	// Attribute is the CloudEvent attribute name of an envelope rule, e.g. userid
+	Attribute string `yaml:"attribute"`
+	// Detector is the name of the detector of a detector rule, e.g. email.
+	// Detector rules replace what is found with redact, hash or pseudonymize, redacted matches name the detector.
+	Detector string `yaml:"detector"`
+	// Path is the dot separated path of a data rule. Segments are globs, ** matches any number of segments
+	// and arrays are transparent, so items.name matches the name of every element of items.
+	// Extension attributes are matched at extensions.<name>, see ExtensionsKey.
+	Path   string `yaml:"path"`
+	Action string `yaml:"action"`
+	// Length is the number of characters kept by truncate
+	Length int `yaml:"length"`
+	// Bucket is the multiple numbers are rounded down to by generalize, it defaults to 10
+	Bucket float64 `yaml:"bucket"`
+	// IPv6PrefixLength overrides the policy's IPv6PrefixLength for generalize
+	IPv6PrefixLength int `yaml:"ipv6PrefixLength"`
+	// QueryAllowlist overrides the policy's URLQueryAllowlist for sanitize_url
+	QueryAllowlist []string `yaml:"queryAllowlist"`
+}
+
+// Policy assigns scrub actions to the envelope attributes and data paths of events
+type Policy struct {
+	spec          Spec
+	attributes    map[string]*rule
+	data          []*rule
+	defaultAction *rule
+	ipScan        *rule
+	urlScan       *rule
+	detections    []detection
+	version       string
+	key           *Key
+	tenantSecret  []byte
+	vault         Vault
+}
+
+// detection runs a detector, replacing its matches according to rule
+type detection struct {
+	detector *detector
+	rule     *rule
+}
+
+type rule struct {
+	name     string
+	segments []string
+	action   string
+	length   int
+	bucket   float64
+	// ipv6PrefixLength is used by generalize
+	ipv6PrefixLength int
+	// queryAllowlist is used by sanitize_url
+	queryAllowlist []string
+}
+
+// Default returns the policy used when no scrub policy file exists: user, session and owner ids are pseudonymized,
+// top level resource, space and client ids are hashed per tenant, the origin IP and IP addresses found in data are generalized, URLs found in data are sanitized,
+// personal data found in data is redacted and everything else is kept
+func Default() *Policy {
+	var detectorRules []RuleSpec
+	for _, d := range detectors {
+		detectorRules = append(detectorRules, RuleSpec{Detector: d.name, Action: ActionRedact})
+	}
+	policy, err := New(Spec{
+		Attributes: []RuleSpec{
+			{Attribute: "userid", Action: ActionPseudonymize},
+			{Attribute: "sessionid", Action: ActionPseudonymize},
+			{Attribute: "ownerid", Action: ActionPseudonymize},
+			{Attribute: "originip", Action: ActionGeneralize},
+			{Attribute: "toplevelresourceid", Action: ActionTenantHash},
+			{Attribute: "spaceid", Action: ActionTenantHash},
+			{Attribute: "clientid", Action: ActionTenantHash},
+		},
+		ScanDataForIPs:  true,
+		ScanDataForURLs: true,
+		Detectors:       detectorRules,
+	})
+	if err != nil {
+		panic(err)
+	}
+	policy.version = versionNone
+	return policy
+}
+
+// KeepAll returns a policy that does not scrub anything
+func KeepAll() *Policy {
+	policy, _ := New(Spec{})
+	policy.version = versionNone
+	return policy
+}
+
+// New creates a Policy from spec, validating its rules
+func New(spec Spec) (*Policy, error) {
+	policy := &Policy{spec: spec, attributes: map[string]*rule{}}
+	if spec.IPv6PrefixLength < 0 || spec.IPv6PrefixLength > 128 {
+		return nil, errors.New("ipv6PrefixLength must be between 0 and 128")
+	}
+	if spec.IPv6PrefixLength == 0 {
+		spec.IPv6PrefixLength = defaultIPv6PrefixLength
+	}
+	// rules without a prefix length or query allowlist of their own use the policy's
+	compile := func(ruleSpec RuleSpec, defaultName string) (*rule, error) {
+		if ruleSpec.IPv6PrefixLength == 0 {
+			ruleSpec.IPv6PrefixLength = spec.IPv6PrefixLength
+		}
+		if ruleSpec.QueryAllowlist == nil {
+			ruleSpec.QueryAllowlist = spec.URLQueryAllowlist
+		}
+		return compileRule(ruleSpec, defaultName)
+	}
+
+	for i, ruleSpec := range spec.Attributes {
+		attribute := strings.ToLower(strings.TrimSpace(ruleSpec.Attribute))
+		if !slices.Contains(attributes, attribute) {
+			return nil, fmt.Errorf("attributes[%d]: unknown attribute %q", i, ruleSpec.Attribute)
+		}
+		if _, ok := policy.attributes[attribute]; ok {
+			return nil, fmt.Errorf("attributes[%d]: duplicate rule for attribute %q", i, attribute)
+		}
+		r, err := compile(ruleSpec, "attributes."+attribute)
+		if err != nil {
+			return nil, fmt.Errorf("attributes[%d]: %w", i, err)
+		}
+		policy.attributes[attribute] = r
+	}
+
+	for i, ruleSpec := range spec.Data {
+		p := strings.TrimSpace(ruleSpec.Path)
+		if p == "" {
+			return nil, fmt.Errorf("data[%d]: path is required", i)
+		}
+		r, err := compile(ruleSpec, "data."+p)
+		if err != nil {
+			return nil, fmt.Errorf("data[%d]: %w", i, err)
+		}
+		r.segments = strings.Split(p, ".")
+		for _, segment := range r.segments {
+			if _, err := path.Match(segment, ""); err != nil {
+				return nil, fmt.Errorf("data[%d]: path %q: %w", i, p, err)
+			}
+		}
+		policy.data = append(policy.data, r)
+	}
+
+	if spec.DefaultDataAction != "" {
+		r, err := compile(RuleSpec{Action: spec.DefaultDataAction}, "data.default")
+		if err != nil {
+			return nil, fmt.Errorf("defaultDataAction: %w", err)
+		}
+		if r.action == ActionTruncate {
+			return nil, errors.New("defaultDataAction: truncate requires a rule with a length")
+		}
+		if r.action != ActionKeep {
+			policy.defaultAction = r
+		}
+	}
+
+	if spec.ScanDataForIPs {
+		r, err := compile(RuleSpec{Action: ActionGeneralize}, "data.ip-scan")
+		if err != nil {
+			return nil, err
+		}
+		policy.ipScan = r
+	}
+
+	if spec.ScanDataForURLs {
+		r, err := compile(RuleSpec{Action: ActionSanitizeURL}, "data.url-scan")
+		if err != nil {
+			return nil, err
+		}
+		policy.urlScan = r
+	}
+
+	seen := map[string]bool{}
+	for i, ruleSpec := range spec.Detectors {
+		name := strings.ToLower(strings.TrimSpace(ruleSpec.Detector))
+		d := detectorByName(name)
+		if d == nil {
+			return nil, fmt.Errorf("detectors[%d]: unknown detector %q", i, ruleSpec.Detector)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("detectors[%d]: duplicate rule for detector %q", i, name)
+		}
+		seen[name] = true
+		r, err := compile(ruleSpec, "detectors."+name)
+		if err != nil {
+			return nil, fmt.Errorf("detectors[%d]: %w", i, err)
+		}
+		if !slices.Contains(detectorActions, r.action) {
+			return nil, fmt.Errorf("detectors[%d]: detectors do not support action %q", i, r.action)
+		}
+		policy.detections = append(policy.detections, detection{detector: d, rule: r})
+	}
+	// detectors run in their own order, not in the order of the rules
+	slices.SortStableFunc(policy.detections, func(a, b detection) int {
+		return slices.Index(detectors, a.detector) - slices.Index(detectors, b.detector)
+	})
+
+	return policy, nil
+}
+
+// Parse creates a Policy from the YAML content of a scrub policy file
+func Parse(data []byte) (*Policy, error) {
+	var spec Spec
+	if err := yaml.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("failed to parse scrub policy file: %w", err)
+	}
+	policy, err := New(spec)
+	if err != nil {
+		return nil, err
+	}
+	policy.version = hash(data)
+	return policy, nil
+}
+
+// Load reads and parses the scrub policy file at filePath
+func Load(filePath string) (*Policy, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scrub policy file: %w", err)
+	}
+	return Parse(data)
+}
+
+// LoadOrDefault loads the scrub policy file at filePath, falling back to Default if the file does not exist
+func LoadOrDefault(filePath string) (*Policy, error) {
+	policy, err := Load(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return Default(), nil
+	}
+	return policy, err
+}
+
+// WithKey returns a copy of the policy pseudonymizing values with key
+func (p *Policy) WithKey(key Key) *Policy {
+	withKey := *p
+	withKey.key = &key
+	return &withKey
+}
+
+// WithTenantSecret returns a copy of the policy deriving the salts of tenant_hash from secret
+func (p *Policy) WithTenantSecret(secret []byte) *Policy {
+	withSecret := *p
+	withSecret.tenantSecret = secret
+	return &withSecret
+}
+
+// WithVault returns a copy of the policy storing the values behind its pseudonyms in vault, so they can be reversed.
+// Without a vault pseudonymize is one-way.
+func (p *Policy) WithVault(vault Vault) *Policy {
+	withVault := *p
+	withVault.vault = vault
+	return &withVault
+}
+
+// Version returns a hash identifying the content of the scrub policy file, or "none" for a built-in policy
+func (p *Policy) Version() string {
+	return p.version
+}
+
+func compileRule(spec RuleSpec, defaultName string) (*rule, error) {
+	action := strings.ToLower(strings.TrimSpace(spec.Action))
+	if !slices.Contains(actions, action) {
+		return nil, fmt.Errorf("unknown action %q", spec.Action)
+	}
+	if action == ActionTruncate && spec.Length <= 0 {
+		return nil, errors.New("truncate requires a positive length")
+	}
+	if spec.Bucket < 0 {
+		return nil, errors.New("bucket must not be negative")
+	}
+	if spec.IPv6PrefixLength < 0 || spec.IPv6PrefixLength > 128 {
+		return nil, errors.New("ipv6PrefixLength must be between 0 and 128")
+	}
+
+	r := &rule{
+		name:             spec.Name,
+		action:           action,
+		length:           spec.Length,
+		bucket:           spec.Bucket,
+		ipv6PrefixLength: spec.IPv6PrefixLength,
+		queryAllowlist:   spec.QueryAllowlist,
+	}
+	if r.name == "" {
+		r.name = defaultName + ":" + action
+	}
+	if r.bucket == 0 {
+		r.bucket = 10
+	}
+	return r, nil
+}
+
+// dataRule returns the first data rule matching segments, or nil
+func (p *Policy) dataRule(segments []string) *rule {
+	for _, r := range p.data {
+		if matchSegments(r.segments, segments) {
+			return r
+		}
+	}
+	return nil
+}
+
+// matchSegments matches a data path against the segments of a rule, ** matches zero or more segments
+func matchSegments(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	// the pattern was validated when compiled
+	if matched, _ := path.Match(pattern[0], segments[0]); !matched {
+		return false
+	}
+	return matchSegments(pattern[1:], segments[1:])
+}
+
+func hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:12]
+}
@@ -0,0 +1,101 @@
+package scrubber
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	policy, err := Parse([]byte(`
+attributes:
+  - attribute: userid
+    action: hash
+  - attribute: OriginIP
+    action: generalize
+data:
+  - path: user.email
+    action: redact
+  - name: short-query
+    path: "**.query"
+    action: truncate
+    length: 8
+defaultDataAction: keep
+`))
+	require.NoError(t, err)
+	assert.Len(t, policy.attributes, 2)
+	assert.Contains(t, policy.attributes, "originip")
+	require.Len(t, policy.data, 2)
+	assert.Equal(t, "data.user.email:redact", policy.data[0].name)
+	assert.Equal(t, "short-query", policy.data[1].name)
+	assert.Nil(t, policy.defaultAction)
+	assert.Len(t, policy.Version(), 12)
+}
+
+func TestParse_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"malformed yaml":      "attributes: [",
+		"unknown attribute":   "attributes: [{attribute: email, action: hash}]",
+		"duplicate attribute": "attributes: [{attribute: userid, action: hash}, {attribute: userid, action: drop}]",
+		"unknown action":      "attributes: [{attribute: userid, action: encrypt}]",
+		"truncate w/o length": "data: [{path: query, action: truncate}]",
+		"missing path":        "data: [{action: drop}]",
+		"invalid glob":        "data: [{path: 'a.[', action: drop}]",
+		"negative bucket":     "data: [{path: a, action: generalize, bucket: -1}]",
+		"unknown default":     "defaultDataAction: shred",
+		"truncate as default": "defaultDataAction: truncate",
+		"unknown detector":    "detectors: [{detector: ssn, action: redact}]",
+		"duplicate detector":  "detectors: [{detector: email, action: redact}, {detector: email, action: hash}]",
+		"detector action":     "detectors: [{detector: email, action: truncate, length: 3}]",
+	}
+
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Parse([]byte(content))
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestLoadOrDefault(t *testing.T) {
+	policy, err := LoadOrDefault(filepath.Join(t.TempDir(), "missing.yaml"))
+	require.NoError(t, err)
+	assert.Equal(t, "none", policy.Version())
+	assert.Contains(t, policy.attributes, "userid")
+
+	filePath := filepath.Join(t.TempDir(), "scrub.yaml")
+	require.NoError(t, os.WriteFile(filePath, []byte("attributes: [{attribute: host, action: drop}]"), 0o600))
+	policy, err = LoadOrDefault(filePath)
+	require.NoError(t, err)
+	assert.NotContains(t, policy.attributes, "userid")
+	assert.Contains(t, policy.attributes, "host")
+
+	require.NoError(t, os.WriteFile(filePath, []byte("attributes: ["), 0o600))
+	_, err = LoadOrDefault(filePath)
+	require.Error(t, err)
+}
+
+func TestMatchSegments(t *testing.T) {
+	tests := []struct {
+		pattern  []string
+		segments []string
+		expected bool
+	}{
+		{[]string{"user", "email"}, []string{"user", "email"}, true},
+		{[]string{"user", "email"}, []string{"user"}, false},
+		{[]string{"user", "*"}, []string{"user", "email"}, true},
+		{[]string{"*token*"}, []string{"accessToken"}, false},
+		{[]string{"*Token*"}, []string{"accessToken"}, true},
+		{[]string{"**", "email"}, []string{"email"}, true},
+		{[]string{"**", "email"}, []string{"a", "b", "email"}, true},
+		{[]string{"**", "email"}, []string{"a", "email", "b"}, false},
+		{[]string{"a", "**"}, []string{"a", "b", "c"}, true},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, matchSegments(test.pattern, test.segments), "%v %v", test.pattern, test.segments)
+	}
+}
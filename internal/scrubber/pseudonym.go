@@ -0,0 +1,56 @@
+package scrubber
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// ephemeralKeyIDPrefix marks the id of a key generated at startup, its pseudonyms change with every restart
+const ephemeralKeyIDPrefix = "ephemeral-"
+
+// Key is the secret pseudonyms are derived from with HMAC-SHA256.
+// Its ID is written next to the pseudonyms, so consumers can tell when a rotation changed them.
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// NewKey creates a Key, deriving its ID from a fingerprint of the secret when id is empty
+func NewKey(id string, secret []byte) Key {
+	if id == "" {
+		id = fingerprint(secret)
+	}
+	return Key{ID: id, Secret: secret}
+}
+
+// EphemeralKey creates a random Key for when no key is configured
+func EphemeralKey() Key {
+	secret := make([]byte, 32)
+	_, _ = rand.Read(secret) // never returns an error
+	return Key{ID: ephemeralKeyIDPrefix + fingerprint(secret), Secret: secret}
+}
+
+// Ephemeral reports whether the key was generated by EphemeralKey
+func (k Key) Ephemeral() bool {
+	return strings.HasPrefix(k.ID, ephemeralKeyIDPrefix)
+}
+
+// Vault keeps the values behind pseudonyms, making them reversible
+type Vault interface {
+	Store(token, value string) error
+}
+
+// pseudonym returns the HMAC-SHA256 hex digest of value
+func (k Key) pseudonym(value string) string {
+	mac := hmac.New(sha256.New, k.Secret)
+	mac.Write([]byte(value)) //revive:disable:unhandled-error
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func fingerprint(secret []byte) string {
+	sum := sha256.Sum256(secret)
+	return hex.EncodeToString(sum[:])[:8]
+}
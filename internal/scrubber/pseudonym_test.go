@@ -0,0 +1,30 @@
+package scrubber
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewKey(t *testing.T) {
+	assert.Equal(t, "2025-08", NewKey("2025-08", []byte("secret")).ID)
+
+	derived := NewKey("", []byte("secret"))
+	assert.Len(t, derived.ID, 8)
+	assert.Equal(t, derived.ID, NewKey("", []byte("secret")).ID)
+	assert.NotEqual(t, derived.ID, NewKey("", []byte("rotated")).ID)
+	assert.False(t, derived.Ephemeral())
+}
+
+func TestEphemeralKey(t *testing.T) {
+	first, second := EphemeralKey(), EphemeralKey()
+	assert.True(t, first.Ephemeral())
+	assert.NotEqual(t, first.ID, second.ID)
+	assert.NotEqual(t, first.pseudonym("user-789"), second.pseudonym("user-789"))
+}
+
+func TestKey_Pseudonym(t *testing.T) {
+	// RFC 4231 test case 2
+	key := NewKey("test", []byte("Jefe"))
+	assert.Equal(t, "5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843", key.pseudonym("what do ya want for nothing?"))
+}
@@ -1,28 +1,187 @@
 package scrubber
 
 import (
-	"github.com/qlik-trial/go-service-kit/v29/messaging/events"
+	"slices"
+
 	"github.com/qlik-trial/usage-telemetry-publisher/internal/model"
 )
 
-// ScrubEvent removes sensitive information from a CloudEvent and returns a ScrubbedEvent.
-func ScrubEvent(event events.CloudEvent) model.ScrubbedEvent {
-	return model.ScrubbedEvent{
-		Id:                 event.Id,
-		SpecVersion:        event.SpecVersion,
-		TenantId:           event.TenantID,
-		Source:             event.Source,
-		UserId:             event.UserID,
-		SessionId:          event.SessionID,
-		Type:               event.Type,
-		Time:               event.Time,
-		Host:               event.Host,
-		OriginIp:           event.OriginIP,
-		OwnerId:            event.OwnerID,
-		TopLevelResourceId: event.TopLevelResourceID,
-		SpaceId:            event.SpaceID,
-		ClientId:           event.ClientID,
-		Reason:             event.Reason,
-		Data:               event.Data.(map[string]any),
+// DataValueKey is the key data that is not a JSON object is scrubbed and published under, arrays, strings, numbers
+// and booleans are matched by data rules on the path DataValueKey and flattened into dimension.data.value.
+const DataValueKey = "value"
+
+// ExtensionsKey is the first path segment of extension attributes, data rules match the extension name at
+// ExtensionsKey.<name> and the values inside it like data values
+const ExtensionsKey = "extensions"
+
+// ScrubEvent removes sensitive information from a CloudEvent according to policy and returns a ScrubbedEvent,
+// along with the names of the rules that fired. Extension attributes are scrubbed like data values at ExtensionsKey.
+// The ScrubbedEvent carries the version of the policy and, when values were pseudonymized, the key ID.
+func ScrubEvent(event model.CloudEvent, policy *Policy) (model.ScrubbedEvent, []string) {
+	s := &scrub{policy: policy, keys: eventKeys{pseudonym: policy.key, vault: policy.vault}}
+	if policy.tenantSecret != nil {
+		s.keys.tenantSalt = tenantSalt(policy.tenantSecret, event.TenantId)
+	}
+	scrubbed := model.ScrubbedEvent{
+		Id:                 s.attribute("id", event.Id),
+		SpecVersion:        s.attribute("specversion", event.SpecVersion),
+		TenantId:           s.attribute("tenantid", event.TenantId),
+		Source:             s.attribute("source", event.Source),
+		UserId:             s.attribute("userid", event.UserId),
+		SessionId:          s.attribute("sessionid", event.SessionId),
+		Type:               s.attribute("type", event.EventType),
+		Time:               s.attribute("time", event.Time),
+		Subject:            s.attribute("subject", event.Subject),
+		Host:               s.attribute("host", event.Host),
+		OriginIp:           s.attribute("originip", event.OriginIp),
+		OwnerId:            s.attribute("ownerid", event.OwnerId),
+		TopLevelResourceId: s.attribute("toplevelresourceid", event.TopLevelResourceId),
+		SpaceId:            s.attribute("spaceid", event.SpaceId),
+		ClientId:           s.attribute("clientid", event.ClientId),
+		Reason:             s.attribute("reason", event.Reason),
+		DataSchema:         s.attribute("dataschema", event.DataSchema),
+		ScrubPolicyVersion: policy.version,
+	}
+	switch data := event.Data.(type) {
+	case nil:
+		// events without data have no data dimensions
+	case map[string]any:
+		scrubbed.Data = s.object(nil, data)
+	default:
+		scrubbed.Data = s.object(nil, map[string]any{DataValueKey: data})
+	}
+	if len(event.Extensions) > 0 {
+		scrubbed.Extensions = make(map[string]any, len(event.Extensions))
+		for name, value := range event.Extensions {
+			if v, keep := s.value([]string{ExtensionsKey, name}, value); keep {
+				scrubbed.Extensions[name] = v
+			}
+		}
+	}
+	if s.pseudonymized && policy.key != nil {
+		scrubbed.PseudonymKeyId = policy.key.ID
+	}
+
+	for _, name := range s.fired {
+		rulesFiredCounter.WithLabelValues(name).Inc()
+	}
+	return scrubbed, s.fired
+}
+
+// scrub applies a policy to a single event, collecting the rules that fired
+type scrub struct {
+	policy        *Policy
+	keys          eventKeys
+	fired         []string
+	pseudonymized bool
+}
+
+func (s *scrub) fire(r *rule) {
+	if r.action == ActionPseudonymize {
+		s.pseudonymized = true
+	}
+	if !slices.Contains(s.fired, r.name) {
+		s.fired = append(s.fired, r.name)
+	}
+}
+
+// attribute applies the rule of an envelope attribute, empty attributes are left as they are
+func (s *scrub) attribute(name, value string) string {
+	r, ok := s.policy.attributes[name]
+	if !ok || value == "" {
+		return value
+	}
+	s.fire(r)
+	scrubbed, keep := apply(r, value, s.keys)
+	if !keep {
+		return ""
+	}
+	str, _ := scrubbed.(string)
+	return str
+}
+
+// object scrubs the values of an object into a new map, the input is never modified
+func (s *scrub) object(segments []string, object map[string]any) map[string]any {
+	scrubbed := make(map[string]any, len(object))
+	for key, value := range object {
+		if v, keep := s.value(append(segments[:len(segments):len(segments)], key), value); keep {
+			scrubbed[key] = v
+		}
+	}
+	return scrubbed
+}
+
+// value scrubs the value at segments, returning false if it is dropped.
+// A rule matching an object or array applies to it as a whole, unless it keeps it, then its children are scrubbed.
+// Array elements share the path of their array.
+func (s *scrub) value(segments []string, value any) (any, bool) {
+	r := s.policy.dataRule(segments)
+	if r != nil {
+		s.fire(r)
+		if r.action != ActionKeep {
+			return apply(r, value, s.keys)
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		return s.object(segments, v), true
+	case []any:
+		scrubbed := make([]any, 0, len(v))
+		for _, element := range v {
+			if e, keep := s.value(segments, element); keep {
+				scrubbed = append(scrubbed, e)
+			}
+		}
+		return scrubbed, true
+	default:
+		if r != nil {
+			return v, true
+		}
+		if str, ok := v.(string); ok {
+			v = s.scanString(segments, str)
+		}
+		if s.policy.defaultAction != nil {
+			s.fire(s.policy.defaultAction)
+			return apply(s.policy.defaultAction, v, s.keys)
+		}
+		return v, true
+	}
+}
+
+// scanString runs the URL scan, the detectors and the IP scan of the policy over a data string no rule matches.
+// URLs are sanitized first, so personal data in their query strings and paths is dropped rather than replaced.
+func (s *scrub) scanString(segments []string, str string) string {
+	key := ""
+	if len(segments) > 0 {
+		key = segments[len(segments)-1]
+	}
+	if s.policy.urlScan != nil {
+		if sanitized, found := sanitizeEmbeddedURLs(str, s.policy.urlScan.queryAllowlist); found {
+			s.fire(s.policy.urlScan)
+			str = sanitized
+		}
+	}
+	for _, d := range s.policy.detections {
+		replaced, hits := d.detector.replace(key, str, func(match string) string {
+			scrubbed, _ := apply(d.rule, match, s.keys)
+			if scrubbed == RedactedValue {
+				return redactedAs(d.detector.name)
+			}
+			return stringValue(scrubbed)
+		})
+		if hits > 0 {
+			s.fire(d.rule)
+			detectorHitsCounter.WithLabelValues(d.detector.name).Add(float64(hits))
+			str = replaced
+		}
+	}
+
+	if s.policy.ipScan != nil {
+		if generalized, found := generalizeEmbeddedIPs(str, s.policy.ipScan.ipv6PrefixLength); found {
+			s.fire(s.policy.ipScan)
+			str = generalized
+		}
 	}
+	return str
 }
@@ -3,7 +3,9 @@ package scrubber
 import (
 	"testing"
 
-	"github.com/qlik-trial/go-service-kit/v29/messaging/events"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/model"
 	"github.com/stretchr/testify/require"
 )
 
@@ -18,42 +20,42 @@ func TestScrubEvent(t *testing.T) {
 		},
 	}
 
-	inputEvent := events.CloudEvent{
+	inputEvent := model.CloudEvent{
 		Id:                 "test-id-123",
 		SpecVersion:        "1.0",
-		TenantID:           "tenant-456",
-		UserID:             "user-789",
-		SessionID:          "session-abc",
+		TenantId:           "tenant-456",
+		UserId:             "user-789",
+		SessionId:          "session-abc",
 		Source:             "qlik.com/myapp",
-		Type:               "user.interaction",
+		EventType:          "user.interaction",
 		Time:               "2025-08-21T10:00:00Z",
 		Host:               "app.qlik.com",
-		OriginIP:           "192.168.1.1",
-		OwnerID:            "owner-def",
-		TopLevelResourceID: "resource-ghi",
-		SpaceID:            "space-jkl",
-		ClientID:           "client-mno",
+		OriginIp:           "192.168.1.1",
+		OwnerId:            "owner-def",
+		TopLevelResourceId: "resource-ghi",
+		SpaceId:            "space-jkl",
+		ClientId:           "client-mno",
 		Reason:             "user_action",
 		Data:               testData,
 	}
 
 	// Call ScrubEvent
-	result := ScrubEvent(inputEvent)
+	result, _ := ScrubEvent(inputEvent, KeepAll())
 
 	require.Equal(t, inputEvent.Id, result.Id)
 	require.Equal(t, inputEvent.SpecVersion, result.SpecVersion)
-	require.Equal(t, inputEvent.TenantID, result.TenantId)
-	require.Equal(t, inputEvent.UserID, result.UserId)
-	require.Equal(t, inputEvent.SessionID, result.SessionId)
+	require.Equal(t, inputEvent.TenantId, result.TenantId)
+	require.Equal(t, inputEvent.UserId, result.UserId)
+	require.Equal(t, inputEvent.SessionId, result.SessionId)
 	require.Equal(t, inputEvent.Source, result.Source)
-	require.Equal(t, inputEvent.Type, result.Type)
+	require.Equal(t, inputEvent.EventType, result.Type)
 	require.Equal(t, inputEvent.Time, result.Time)
 	require.Equal(t, inputEvent.Host, result.Host)
-	require.Equal(t, inputEvent.OriginIP, result.OriginIp)
-	require.Equal(t, inputEvent.OwnerID, result.OwnerId)
-	require.Equal(t, inputEvent.TopLevelResourceID, result.TopLevelResourceId)
-	require.Equal(t, inputEvent.SpaceID, result.SpaceId)
-	require.Equal(t, inputEvent.ClientID, result.ClientId)
+	require.Equal(t, inputEvent.OriginIp, result.OriginIp)
+	require.Equal(t, inputEvent.OwnerId, result.OwnerId)
+	require.Equal(t, inputEvent.TopLevelResourceId, result.TopLevelResourceId)
+	require.Equal(t, inputEvent.SpaceId, result.SpaceId)
+	require.Equal(t, inputEvent.ClientId, result.ClientId)
 	require.Equal(t, inputEvent.Reason, result.Reason)
 
 	expectedData := inputEvent.Data.(map[string]any)
@@ -73,13 +75,13 @@ func TestScrubEvent(t *testing.T) {
 
 func TestScrubEvent_EmptyFields(t *testing.T) {
 	// Test with minimal CloudEvent (empty strings)
-	inputEvent := events.CloudEvent{
+	inputEvent := model.CloudEvent{
 		Id:          "minimal-id",
 		SpecVersion: "1.0",
 		Data:        map[string]any{},
 	}
 
-	result := ScrubEvent(inputEvent)
+	result, _ := ScrubEvent(inputEvent, KeepAll())
 
 	// Verify that empty fields are preserved as empty
 	require.Equal(t, "minimal-id", result.Id)
@@ -90,7 +92,7 @@ func TestScrubEvent_EmptyFields(t *testing.T) {
 
 func TestScrubEvent_NilData(t *testing.T) {
 	// Test behavior when Data is nil or not a map
-	inputEvent := events.CloudEvent{
+	inputEvent := model.CloudEvent{
 		Id:          "test-id",
 		SpecVersion: "1.0",
 		Data:        nil,
@@ -103,7 +105,7 @@ func TestScrubEvent_NilData(t *testing.T) {
 		}
 	}()
 
-	result := ScrubEvent(inputEvent)
+	result, _ := ScrubEvent(inputEvent, KeepAll())
 
 	// If we reach here, the function handled nil gracefully
 	require.Nil(t, result.Data)
@@ -128,13 +130,13 @@ func TestScrubEvent_ComplexData(t *testing.T) {
 		},
 	}
 
-	inputEvent := events.CloudEvent{
+	inputEvent := model.CloudEvent{
 		Id:       "complex-data-test",
-		TenantID: "tenant-123",
+		TenantId: "tenant-123",
 		Data:     complexData,
 	}
 
-	result := ScrubEvent(inputEvent)
+	result, _ := ScrubEvent(inputEvent, KeepAll())
 
 	// Verify complex data structure is preserved
 	require.Equal(t, "value1", result.Data["simple_string"])
@@ -150,3 +152,413 @@ func TestScrubEvent_ComplexData(t *testing.T) {
 
 	require.Equal(t, "deep_value", level2["level3"])
 }
+
+func TestScrubEvent_NonObjectData(t *testing.T) {
+	policy, err := Parse([]byte(`
+data:
+  - path: value.secret
+    action: drop
+detectors:
+  - detector: email
+    action: redact
+`))
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		data     any
+		expected map[string]any
+	}{
+		"array":   {[]any{map[string]any{"id": 1.0, "secret": "s1"}, "jane@example.com"}, map[string]any{DataValueKey: []any{map[string]any{"id": 1.0}, "[REDACTED:email]"}}},
+		"string":  {"contact jane@example.com", map[string]any{DataValueKey: "contact [REDACTED:email]"}},
+		"number":  {42.0, map[string]any{DataValueKey: 42.0}},
+		"boolean": {true, map[string]any{DataValueKey: true}},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			result, _ := ScrubEvent(model.CloudEvent{Id: "test-id", Data: test.data}, policy)
+			assert.Equal(t, test.expected, result.Data)
+		})
+	}
+}
+
+func TestScrubEvent_Extensions(t *testing.T) {
+	policy, err := Parse([]byte(`
+attributes:
+  - attribute: subject
+    action: hash
+detectors:
+  - detector: email
+    action: redact
+`))
+	require.NoError(t, err)
+	inputEvent := model.CloudEvent{
+		Id:         "test-id",
+		Subject:    "sheet-1",
+		DataSchema: "https://qlik.com/schemas/sheet.json",
+		Extensions: map[string]any{
+			"requester": "jane@example.com",
+			"sequence":  7.0,
+			"actor":     map[string]any{"contact": "john@example.com", "ids": []any{"jane@example.com"}},
+		},
+	}
+
+	result, fired := ScrubEvent(inputEvent, policy)
+
+	assert.Equal(t, hashValue("sheet-1"), result.Subject)
+	assert.Equal(t, "https://qlik.com/schemas/sheet.json", result.DataSchema)
+	assert.Equal(t, map[string]any{
+		"requester": "[REDACTED:email]",
+		"sequence":  7.0,
+		"actor":     map[string]any{"contact": "[REDACTED:email]", "ids": []any{"[REDACTED:email]"}},
+	}, result.Extensions, "nested extension values are scanned")
+	assert.Equal(t, "jane@example.com", inputEvent.Extensions["requester"], "the input is not modified")
+	assert.ElementsMatch(t, []string{"attributes.subject:hash", "detectors.email:redact"}, fired)
+
+	policy, err = Parse([]byte(`
+data:
+  - path: extensions.actor
+    action: drop
+  - path: extensions.sequence
+    action: generalize
+`))
+	require.NoError(t, err)
+	result, fired = ScrubEvent(inputEvent, policy)
+	assert.Equal(t, map[string]any{"requester": "jane@example.com", "sequence": 0.0}, result.Extensions, "data rules address extensions")
+	assert.ElementsMatch(t, []string{"data.extensions.actor:drop", "data.extensions.sequence:generalize"}, fired)
+
+	policy, err = Parse([]byte("defaultDataAction: drop"))
+	require.NoError(t, err)
+	result, _ = ScrubEvent(inputEvent, policy)
+	assert.Equal(t, map[string]any{"actor": map[string]any{"ids": []any{}}}, result.Extensions, "only containers are left, like in data")
+}
+
+func TestScrubEvent_Policy(t *testing.T) {
+	policy, err := Parse([]byte(`
+attributes:
+  - attribute: userid
+    action: hash
+  - attribute: sessionid
+    action: drop
+  - attribute: originip
+    action: generalize
+  - attribute: host
+    action: redact
+  - attribute: ownerid
+    action: hash
+data:
+  - path: user.email
+    action: redact
+  - path: "**.query"
+    action: truncate
+    length: 3
+  - path: items.secret
+    action: drop
+  - path: duration
+    action: generalize
+    bucket: 100
+`))
+	require.NoError(t, err)
+
+	data := map[string]any{
+		"user":     map[string]any{"email": "jane@example.com", "name": "Jane"},
+		"search":   map[string]any{"query": "revenue by region"},
+		"items":    []any{map[string]any{"id": 1, "secret": "s1"}, map[string]any{"id": 2, "secret": "s2"}},
+		"duration": 1234.0,
+	}
+	inputEvent := model.CloudEvent{
+		Id:        "test-id",
+		TenantId:  "tenant-456",
+		UserId:    "user-789",
+		SessionId: "session-abc",
+		OriginIp:  "192.168.1.17",
+		Host:      "app.qlik.com",
+		Data:      data,
+	}
+
+	result, fired := ScrubEvent(inputEvent, policy)
+
+	assert.Equal(t, hashValue("user-789"), result.UserId)
+	assert.Empty(t, result.SessionId)
+	assert.Equal(t, "192.168.1.0", result.OriginIp)
+	assert.Equal(t, RedactedValue, result.Host)
+	assert.Empty(t, result.OwnerId, "empty attributes are not scrubbed")
+	assert.Equal(t, "tenant-456", result.TenantId)
+	assert.Equal(t, map[string]any{
+		"user":     map[string]any{"email": RedactedValue, "name": "Jane"},
+		"search":   map[string]any{"query": "rev"},
+		"items":    []any{map[string]any{"id": 1}, map[string]any{"id": 2}},
+		"duration": float64(1200),
+	}, result.Data)
+	assert.Equal(t, "jane@example.com", data["user"].(map[string]any)["email"], "the input is not modified")
+	assert.ElementsMatch(t, []string{
+		"attributes.sessionid:drop", "attributes.userid:hash", "attributes.host:redact", "attributes.originip:generalize",
+		"data.user.email:redact", "data.**.query:truncate", "data.items.secret:drop", "data.duration:generalize",
+	}, fired)
+}
+
+func TestScrubEvent_DefaultDataAction(t *testing.T) {
+	policy, err := Parse([]byte(`
+data:
+  - path: action
+    action: keep
+  - path: metadata
+    action: keep
+  - path: metadata.page
+    action: keep
+defaultDataAction: drop
+`))
+	require.NoError(t, err)
+	inputEvent := model.CloudEvent{
+		Id: "test-id",
+		Data: map[string]any{
+			"action":   "button_click",
+			"element":  "submit",
+			"metadata": map[string]any{"page": "dashboard", "section": "header"},
+			"tags":     []any{"a", "b"},
+		},
+	}
+
+	result, fired := ScrubEvent(inputEvent, policy)
+
+	assert.Equal(t, map[string]any{
+		"action":   "button_click",
+		"metadata": map[string]any{"page": "dashboard"},
+		"tags":     []any{},
+	}, result.Data)
+	assert.Contains(t, fired, "data.default:drop")
+}
+
+func TestScrubEvent_DefaultPolicy(t *testing.T) {
+	inputEvent := model.CloudEvent{
+		Id:        "test-id",
+		UserId:    "user-789",
+		SessionId: "session-abc",
+		OwnerId:   "owner-def",
+		OriginIp:  "10.1.2.3",
+		Source:    "qlik.com/myapp",
+		Data:      map[string]any{"action": "button_click"},
+	}
+	key := NewKey("2025-08", []byte("secret"))
+
+	result, _ := ScrubEvent(inputEvent, Default().WithKey(key))
+
+	assert.Equal(t, key.pseudonym("user-789"), result.UserId)
+	assert.Equal(t, key.pseudonym("session-abc"), result.SessionId)
+	assert.Equal(t, key.pseudonym("owner-def"), result.OwnerId)
+	assert.Equal(t, "2025-08", result.PseudonymKeyId)
+	assert.Equal(t, "10.1.2.0", result.OriginIp)
+	assert.Equal(t, "qlik.com/myapp", result.Source)
+	assert.Equal(t, inputEvent.Data, result.Data)
+}
+
+func TestScrubEvent_Pseudonymize(t *testing.T) {
+	inputEvent := model.CloudEvent{Id: "test-id", UserId: "user-789", SessionId: "session-abc"}
+
+	t.Run("pseudonyms are stable for a key", func(t *testing.T) {
+		first, _ := ScrubEvent(inputEvent, Default().WithKey(NewKey("k1", []byte("secret"))))
+		second, _ := ScrubEvent(inputEvent, Default().WithKey(NewKey("k1", []byte("secret"))))
+		assert.Equal(t, first.UserId, second.UserId)
+		assert.NotEqual(t, first.UserId, first.SessionId)
+	})
+
+	t.Run("rotating the key changes pseudonyms and key id", func(t *testing.T) {
+		before, _ := ScrubEvent(inputEvent, Default().WithKey(NewKey("k1", []byte("secret"))))
+		after, _ := ScrubEvent(inputEvent, Default().WithKey(NewKey("k2", []byte("rotated"))))
+		assert.NotEqual(t, before.UserId, after.UserId)
+		assert.Equal(t, "k1", before.PseudonymKeyId)
+		assert.Equal(t, "k2", after.PseudonymKeyId)
+	})
+
+	t.Run("values are redacted without a key", func(t *testing.T) {
+		result, _ := ScrubEvent(inputEvent, Default())
+		assert.Equal(t, RedactedValue, result.UserId)
+		assert.Empty(t, result.PseudonymKeyId)
+	})
+
+	t.Run("values behind pseudonyms are stored in the vault", func(t *testing.T) {
+		vault := fakeVault{}
+		result, _ := ScrubEvent(inputEvent, Default().WithKey(NewKey("k1", []byte("secret"))).WithVault(vault))
+		assert.Equal(t, "user-789", vault[result.UserId])
+		assert.Equal(t, "session-abc", vault[result.SessionId])
+	})
+
+	t.Run("key id is only set when a value was pseudonymized", func(t *testing.T) {
+		result, _ := ScrubEvent(model.CloudEvent{Id: "test-id"}, Default().WithKey(NewKey("k1", []byte("secret"))))
+		assert.Empty(t, result.PseudonymKeyId)
+	})
+}
+
+type fakeVault map[string]string
+
+func (v fakeVault) Store(token, value string) error {
+	v[token] = value
+	return nil
+}
+
+func TestScrubEvent_ScanDataForIPs(t *testing.T) {
+	policy, err := Parse([]byte(`
+attributes:
+  - attribute: originip
+    action: generalize
+    ipv6PrefixLength: 32
+data:
+  - path: build
+    action: keep
+scanDataForIPs: true
+ipv6PrefixLength: 64
+`))
+	require.NoError(t, err)
+	inputEvent := model.CloudEvent{
+		Id:       "test-id",
+		OriginIp: "2001:db8:85a3:8d3:1319:8a2e:370:7348",
+		Data: map[string]any{
+			"forwardedFor": "203.0.113.195, 10.0.0.1",
+			"message":      "request from 2001:db8:85a3:8d3:1319:8a2e:370:7348 rejected",
+			"nested":       map[string]any{"peers": []any{"192.168.1.17", "::ffff:192.168.1.18"}},
+			"build":        "1.2.3.4",
+			"count":        3,
+		},
+	}
+
+	result, fired := ScrubEvent(inputEvent, policy)
+
+	assert.Equal(t, "2001:db8::", result.OriginIp)
+	assert.Equal(t, map[string]any{
+		"forwardedFor": "203.0.113.0, 10.0.0.0",
+		"message":      "request from 2001:db8:85a3:8d3:: rejected",
+		"nested":       map[string]any{"peers": []any{"192.168.1.0", "192.168.1.0"}},
+		"build":        "1.2.3.4",
+		"count":        3,
+	}, result.Data)
+	assert.Contains(t, fired, "data.ip-scan:generalize")
+}
+
+func TestScrubEvent_Detectors(t *testing.T) {
+	policy, err := Parse([]byte(`
+data:
+  - path: contact
+    action: keep
+detectors:
+  - detector: email
+    action: redact
+  - detector: credit_card
+    action: hash
+  - detector: jwt
+    action: redact
+  - detector: name
+    action: pseudonymize
+`))
+	require.NoError(t, err)
+	key := NewKey("k1", []byte("secret"))
+	inputEvent := model.CloudEvent{
+		Id: "test-id",
+		Data: map[string]any{
+			"message": "jane@example.com paid with 4111 1111 1111 1111",
+			"session": map[string]any{"tokens": []any{testJWT}},
+			"owner":   map[string]any{"fullName": "Jane Doe"},
+			"contact": "john@example.com",
+			"phone":   "+1 555-123-4567",
+		},
+	}
+
+	result, fired := ScrubEvent(inputEvent, policy.WithKey(key))
+
+	assert.Equal(t, map[string]any{
+		"message": "[REDACTED:email] paid with " + hashValue("4111 1111 1111 1111"),
+		"session": map[string]any{"tokens": []any{"[REDACTED:jwt]"}},
+		"owner":   map[string]any{"fullName": key.pseudonym("Jane Doe")},
+		"contact": "john@example.com",
+		"phone":   "+1 555-123-4567",
+	}, result.Data)
+	assert.Equal(t, "k1", result.PseudonymKeyId)
+	assert.ElementsMatch(t, []string{
+		"data.contact:keep", "detectors.email:redact", "detectors.credit_card:hash",
+		"detectors.jwt:redact", "detectors.name:pseudonymize",
+	}, fired)
+}
+
+func TestScrubEvent_SanitizeURLs(t *testing.T) {
+	policy, err := Parse([]byte(`
+attributes:
+  - attribute: source
+    action: sanitize_url
+data:
+  - path: referrer
+    action: sanitize_url
+    queryAllowlist: [utm_source]
+  - path: title
+    action: keep
+scanDataForURLs: true
+urlQueryAllowlist: [page]
+`))
+	require.NoError(t, err)
+	inputEvent := model.CloudEvent{
+		Id:     "test-id",
+		Source: "https://tenant.qlikcloud.com/sense/app/42#sheet",
+		Data: map[string]any{
+			"pageUrl":  "https://tenant.qlikcloud.com/hub/users/jane@example.com?page=2&search=jane",
+			"referrer": "https://www.example.com/?utm_source=mail&page=3",
+			"title":    "https://tenant.qlikcloud.com/sense/app/42",
+			"action":   "open",
+		},
+	}
+
+	result, fired := ScrubEvent(inputEvent, policy)
+
+	assert.Equal(t, "https://tenant.qlikcloud.com/sense/app/{id}", result.Source)
+	assert.Equal(t, map[string]any{
+		"pageUrl":  "https://tenant.qlikcloud.com/hub/users/{email}?page=2",
+		"referrer": "https://www.example.com/?utm_source=mail",
+		"title":    "https://tenant.qlikcloud.com/sense/app/42",
+		"action":   "open",
+	}, result.Data)
+	assert.ElementsMatch(t, []string{
+		"attributes.source:sanitize_url", "data.referrer:sanitize_url", "data.title:keep", "data.url-scan:sanitize_url",
+	}, fired)
+}
+
+func TestScrubEvent_TenantHash(t *testing.T) {
+	policy, err := Parse([]byte(`
+attributes:
+  - attribute: spaceid
+    action: tenant_hash
+  - attribute: toplevelresourceid
+    action: tenant_hash
+data:
+  - path: appId
+    action: tenant_hash
+`))
+	require.NoError(t, err)
+	policy = policy.WithTenantSecret([]byte("master"))
+	event := func(tenantID string) model.CloudEvent {
+		return model.CloudEvent{
+			Id:                 "test-id",
+			TenantId:           tenantID,
+			SpaceId:            "space-1",
+			TopLevelResourceId: "app-1",
+			ClientId:           "client-1",
+			Data:               map[string]any{"appId": "app-1"},
+		}
+	}
+
+	first, fired := ScrubEvent(event("tenant-a"), policy)
+	second, _ := ScrubEvent(event("tenant-a"), policy)
+	other, _ := ScrubEvent(event("tenant-b"), policy)
+
+	salt := tenantSalt([]byte("master"), "tenant-a")
+	assert.Equal(t, tenantHash(salt, "space-1"), first.SpaceId)
+	assert.Equal(t, tenantHash(salt, "app-1"), first.TopLevelResourceId)
+	assert.Equal(t, first.TopLevelResourceId, first.Data["appId"], "ids are joinable within a tenant")
+	assert.Equal(t, "client-1", first.ClientId, "fields without a rule are kept")
+	assert.Equal(t, first, second)
+	assert.NotEqual(t, first.SpaceId, other.SpaceId, "ids are not correlatable across tenants")
+	assert.Contains(t, fired, "attributes.spaceid:tenant_hash")
+
+	t.Run("values are redacted without a tenant secret", func(t *testing.T) {
+		withoutSecret, err := Parse([]byte("attributes: [{attribute: spaceid, action: tenant_hash}]"))
+		require.NoError(t, err)
+		result, _ := ScrubEvent(event("tenant-a"), withoutSecret)
+		assert.Equal(t, RedactedValue, result.SpaceId)
+	})
+}
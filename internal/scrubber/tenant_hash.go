@@ -0,0 +1,23 @@
+package scrubber
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// tenantSalt derives the salt of a tenant from the master secret with HMAC-SHA256, so the same secret
+// always yields the same salt for a tenant while the salts of different tenants are unrelated
+func tenantSalt(secret []byte, tenantID string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(tenantID)) //revive:disable:unhandled-error
+	return mac.Sum(nil)
+}
+
+// tenantHash returns the HMAC-SHA256 hex digest of value under the salt of its tenant.
+// Equal values of one tenant hash to the same digest, equal values of different tenants do not.
+func tenantHash(salt []byte, value string) string {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write([]byte(value)) //revive:disable:unhandled-error
+	return hex.EncodeToString(mac.Sum(nil))
+}
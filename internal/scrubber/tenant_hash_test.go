@@ -0,0 +1,25 @@
+package scrubber
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTenantSalt(t *testing.T) {
+	secret := []byte("master")
+
+	assert.Equal(t, tenantSalt(secret, "tenant-a"), tenantSalt(secret, "tenant-a"))
+	assert.NotEqual(t, tenantSalt(secret, "tenant-a"), tenantSalt(secret, "tenant-b"))
+	assert.NotEqual(t, tenantSalt(secret, "tenant-a"), tenantSalt([]byte("rotated"), "tenant-a"))
+}
+
+func TestTenantHash(t *testing.T) {
+	saltA := tenantSalt([]byte("master"), "tenant-a")
+	saltB := tenantSalt([]byte("master"), "tenant-b")
+
+	assert.Equal(t, tenantHash(saltA, "space-1"), tenantHash(saltA, "space-1"))
+	assert.NotEqual(t, tenantHash(saltA, "space-1"), tenantHash(saltA, "space-2"))
+	assert.NotEqual(t, tenantHash(saltA, "space-1"), tenantHash(saltB, "space-1"))
+	assert.Len(t, tenantHash(saltA, "space-1"), 64)
+}
@@ -0,0 +1,77 @@
+package scrubber
+
+import (
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Placeholders replacing the path segments of sanitized URLs
+const (
+	idPlaceholder    = "{id}"
+	emailPlaceholder = "{email}"
+)
+
+var (
+	// idSegment matches path segments holding an id: numbers, UUIDs and long hex ids
+	idSegment = regexp.MustCompile(`^(?:\d+|[0-9A-Fa-f]{8}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{12}|[0-9A-Fa-f]{16,})$`)
+	// urlCandidate matches the absolute http(s) URLs of a string, sanitizeURL decides whether they are URLs
+	urlCandidate = regexp.MustCompile(`\bhttps?://[^\s"'<>]+`)
+)
+
+// sanitizeURL strips the userinfo and fragment of an absolute URL or an absolute path, drops the query parameters
+// that are not in allowlist and replaces the path segments holding ids or emails with placeholders.
+// The sanitized URL is still a valid URL, its remaining query parameters are sorted by name.
+// It returns false if raw is not a URL.
+func sanitizeURL(raw string, allowlist []string) (string, bool) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Opaque != "" || (u.Host == "" && !strings.HasPrefix(u.Path, "/")) {
+		return "", false
+	}
+
+	segments := strings.Split(u.EscapedPath(), "/")
+	for i, segment := range segments {
+		decoded, err := url.PathUnescape(segment)
+		if err != nil {
+			decoded = segment
+		}
+		switch {
+		case strings.Contains(decoded, "@"):
+			segments[i] = emailPlaceholder
+		case idSegment.MatchString(decoded):
+			segments[i] = idPlaceholder
+		}
+	}
+
+	query := url.Values{}
+	for name, values := range u.Query() {
+		if slices.Contains(allowlist, name) {
+			query[name] = values
+		}
+	}
+
+	// the placeholders are written as they are, url.URL would escape their braces
+	origin := url.URL{Scheme: u.Scheme, Host: u.Host}
+	sanitized := origin.String() + strings.Join(segments, "/")
+	if len(query) > 0 {
+		sanitized += "?" + query.Encode()
+	}
+	return sanitized, true
+}
+
+// sanitizeEmbeddedURLs sanitizes the http(s) URLs found anywhere in s, returning false if it contains none
+func sanitizeEmbeddedURLs(s string, allowlist []string) (string, bool) {
+	found := false
+	sanitized := urlCandidate.ReplaceAllStringFunc(s, func(token string) string {
+		// sentence punctuation is not part of the URL
+		candidate := strings.TrimRight(token, ".,;:!?)")
+		sanitizedURL, ok := sanitizeURL(candidate, allowlist)
+		if !ok {
+			return token
+		}
+		found = true
+		return sanitizedURL + token[len(candidate):]
+	})
+	return sanitized, found
+}
@@ -0,0 +1,13 @@
+package secrets
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// secretsFoundCounter counts the secrets redacted from events. Every increment is a high severity leak to follow up on.
+var secretsFoundCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "usage_telemetry_publisher",
+	Name:      "secrets_found_total",
+	Help:      "High severity: number of secrets found and redacted in events, by kind",
+}, []string{"kind"})
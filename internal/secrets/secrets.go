@@ -0,0 +1,239 @@
+package secrets
+
+import (
+	"encoding/base64"
+	"maps"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/model"
+)
+
+// RedactedValue replaces values containing a secret
+const RedactedValue = "[REDACTED:secret]"
+
+// Kinds of secrets the Scanner finds
+const (
+	KindPrivateKey     = "private_key"
+	KindAWSAccessKey   = "aws_access_key"
+	KindGitHubToken    = "github_token"
+	KindSlackToken     = "slack_token"
+	KindStripeKey      = "stripe_key"
+	KindGoogleAPIKey   = "google_api_key"
+	KindJWT            = "jwt"
+	KindURLCredentials = "url_credentials"
+	KindAssignment     = "credential_assignment"
+	KindSecretField    = "secret_field"
+	KindHighEntropy    = "high_entropy"
+)
+
+const (
+	defaultMinTokenLength = 32
+	defaultMinEntropy     = 4.3
+)
+
+// Options configures a Scanner
+type Options struct {
+	// MinTokenLength is the length from which tokens are checked for their entropy, it defaults to 32
+	MinTokenLength int
+	// MinEntropy is the Shannon entropy in bits per character from which a token is a secret, it defaults to 4.3
+	MinEntropy float64
+}
+
+// Finding is a secret found by the Scanner. It never holds the secret itself.
+type Finding struct {
+	// Field is the attribute name or dot separated data path of the redacted value
+	Field string
+	Kind  string
+}
+
+// Scanner finds credentials in the free-form fields of scrubbed events: the source, subject, host, reason, the data strings
+// and the extension attributes.
+// Secrets are found by their known prefixes and formats, by the key they are stored under
+// and by the Shannon entropy of long tokens mixing upper case letters, lower case letters and digits.
+type Scanner struct {
+	opts Options
+}
+
+type pattern struct {
+	kind    string
+	pattern *regexp.Regexp
+	valid   func(match string) bool
+}
+
+// patterns are checked in this order, the first one matching names the kind of secret
+var patterns = []pattern{
+	{kind: KindPrivateKey, pattern: regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----`)},
+	{kind: KindAWSAccessKey, pattern: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{kind: KindGitHubToken, pattern: regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`)},
+	{kind: KindSlackToken, pattern: regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}`)},
+	{kind: KindStripeKey, pattern: regexp.MustCompile(`\b[rs]k_live_[0-9A-Za-z]{24,}\b`)},
+	{kind: KindGoogleAPIKey, pattern: regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`)},
+	// Qlik Cloud API keys are JWTs
+	{kind: KindJWT, pattern: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`), valid: isJWT},
+	{kind: KindURLCredentials, pattern: regexp.MustCompile(`\b[A-Za-z][A-Za-z0-9+.-]*://[^/\s:@]+:[^/\s@]+@`)},
+	{kind: KindAssignment, pattern: regexp.MustCompile(`(?i)\b(?:password|passwd|pwd|secret|client_?secret|api_?key|access_?token|auth_?token)\s*[=:]\s*[^\s&,;]+`)},
+}
+
+// secretKey matches the data keys whose values are secrets
+var secretKey = regexp.MustCompile(`(?i)^(?:password|passwd|pwd|secret|client_?secret|api_?key|access_?token|refresh_?token|auth_?token|private_?key|credentials?)$`)
+
+// tokenSeparator splits strings into the tokens checked for their entropy.
+// Dots and slashes separate tokens, so dotted identifiers and URL paths are checked segment by segment.
+var tokenSeparator = regexp.MustCompile(`[^A-Za-z0-9+=_-]+`)
+
+// New creates a Scanner, using the default of every option that is not set
+func New(opts Options) *Scanner {
+	if opts.MinTokenLength <= 0 {
+		opts.MinTokenLength = defaultMinTokenLength
+	}
+	if opts.MinEntropy <= 0 {
+		opts.MinEntropy = defaultMinEntropy
+	}
+	return &Scanner{opts: opts}
+}
+
+// Scan replaces the values of event containing a secret with RedactedValue and returns what was found.
+// Data containers holding a secret are copied, so data shared with other events is never modified.
+func (s *Scanner) Scan(event *model.ScrubbedEvent) []Finding {
+	var findings []Finding
+	for _, field := range []struct {
+		name  string
+		value *string
+	}{
+		{"source", &event.Source},
+		{"subject", &event.Subject},
+		{"host", &event.Host},
+		{"reason", &event.Reason},
+	} {
+		if kind := s.find("", *field.value); kind != "" {
+			*field.value = RedactedValue
+			findings = append(findings, Finding{Field: field.name, Kind: kind})
+		}
+	}
+
+	if event.Data != nil {
+		if data, found := s.value("data", "", event.Data, &findings); found {
+			event.Data, _ = data.(map[string]any)
+		}
+	}
+	if event.Extensions != nil {
+		if extensions, found := s.value("extensions", "", event.Extensions, &findings); found {
+			event.Extensions, _ = extensions.(map[string]any)
+		}
+	}
+
+	for _, finding := range findings {
+		secretsFoundCounter.WithLabelValues(finding.Kind).Inc()
+	}
+	return findings
+}
+
+// value scans a data value stored under key at path, returning a redacted copy if it contains a secret
+func (s *Scanner) value(path, key string, value any, findings *[]Finding) (any, bool) {
+	switch v := value.(type) {
+	case string:
+		if kind := s.find(key, v); kind != "" {
+			*findings = append(*findings, Finding{Field: path, Kind: kind})
+			return RedactedValue, true
+		}
+		return v, false
+	case map[string]any:
+		var scanned map[string]any
+		for k, element := range v {
+			if e, found := s.value(path+"."+k, k, element, findings); found {
+				if scanned == nil {
+					scanned = maps.Clone(v)
+				}
+				scanned[k] = e
+			}
+		}
+		if scanned == nil {
+			return v, false
+		}
+		return scanned, true
+	case []any:
+		var scanned []any
+		for i, element := range v {
+			// array elements share the path of their array, as in scrub policy paths
+			if e, found := s.value(path, key, element, findings); found {
+				if scanned == nil {
+					scanned = slices.Clone(v)
+				}
+				scanned[i] = e
+			}
+		}
+		if scanned == nil {
+			return v, false
+		}
+		return scanned, true
+	default:
+		return v, false
+	}
+}
+
+// find returns the kind of the first secret found in value stored under key, or ""
+func (s *Scanner) find(key, value string) string {
+	if value == "" || strings.HasPrefix(value, "[REDACTED") {
+		return ""
+	}
+	if secretKey.MatchString(key) {
+		return KindSecretField
+	}
+	for _, p := range patterns {
+		for _, match := range p.pattern.FindAllString(value, -1) {
+			if p.valid == nil || p.valid(match) {
+				return p.kind
+			}
+		}
+	}
+	for _, token := range tokenSeparator.Split(value, -1) {
+		if len(token) >= s.opts.MinTokenLength && hasMixedClasses(token) && entropy(token) >= s.opts.MinEntropy {
+			return KindHighEntropy
+		}
+	}
+	return ""
+}
+
+// entropy returns the Shannon entropy of s in bits per character
+func entropy(s string) float64 {
+	counts := map[rune]int{}
+	n := 0
+	for _, c := range s {
+		counts[c]++
+		n++
+	}
+	var bits float64
+	for _, count := range counts {
+		p := float64(count) / float64(n)
+		bits -= p * math.Log2(p)
+	}
+	return bits
+}
+
+// hasMixedClasses checks that a token has upper case letters, lower case letters and digits,
+// which keeps hex digests, such as pseudonyms, and words from being taken for secrets
+func hasMixedClasses(token string) bool {
+	var upper, lower, digit bool
+	for _, c := range token {
+		switch {
+		case unicode.IsUpper(c):
+			upper = true
+		case unicode.IsLower(c):
+			lower = true
+		case unicode.IsDigit(c):
+			digit = true
+		}
+	}
+	return upper && lower && digit
+}
+
+// isJWT checks that the header of a JWT candidate is base64url encoded JSON
+func isJWT(match string) bool {
+	header, _, _ := strings.Cut(match, ".")
+	decoded, err := base64.RawURLEncoding.DecodeString(header)
+	return err == nil && strings.HasPrefix(strings.TrimSpace(string(decoded)), "{")
+}
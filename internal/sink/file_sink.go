@@ -0,0 +1,463 @@
+package sink
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/formatter"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/model"
+)
+
+const (
+	filePrefix    = "events-"
+	gzipExtension = ".gz"
+	tempExtension = ".tmp"
+	// stagedExtension marks the temp files events of encodings that are not concatenable are staged in
+	stagedExtension = ".staged"
+	// minAgeCheckInterval bounds how often idle files are checked for their age
+	minAgeCheckInterval = 10 * time.Millisecond
+)
+
+// FileSinkOptions configures a FileSink
+type FileSinkOptions struct {
+	// Directory finalized files are written to
+	Directory string
+	// MaxBytes rotates the current file once it reaches this size, 0 disables size based rotation.
+	// For encodings that are not concatenable it bounds the size of the staged events.
+	MaxBytes int64
+	// MaxAge rotates the current file once it has been open this long, 0 disables age based rotation
+	MaxAge time.Duration
+	// Compression gzips files when they are finalized
+	Compression bool
+	// Encoding encodes the events written to files, it defaults to newline delimited JSON with formatter.DefaultOptions
+	Encoding formatter.Encoding
+}
+
+// FileSink writes events to rolling files in the format of its encoding.
+// The current file is a hidden temp file, which is renamed to its final name when it is rotated,
+// so readers of the directory only ever see complete files.
+// Events of encodings that are not concatenable are staged as JSON lines in a hidden file
+// and encoded into a single file when it is rotated.
+type FileSink struct {
+	opts FileSinkOptions
+
+	mu       sync.Mutex
+	file     *os.File
+	baseName string
+	size     int64
+	openedAt time.Time
+	err      error
+
+	stop    chan struct{}
+	done    chan struct{}
+	closing sync.Once
+	now     func() time.Time
+}
+
+// NewFileSink creates a FileSink, finalizing any temp files left behind by a previous run
+func NewFileSink(opts FileSinkOptions) (*FileSink, error) {
+	if err := os.MkdirAll(opts.Directory, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create sink directory: %w", err)
+	}
+	if opts.Encoding == nil {
+		opts.Encoding = formatter.NDJSON(formatter.DefaultOptions())
+	}
+
+	s := &FileSink{
+		opts: opts,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+		now:  time.Now,
+	}
+	if err := s.recover(); err != nil {
+		return nil, err
+	}
+
+	go s.rotateOnAge()
+	return s, nil
+}
+
+// Write appends the events to the current file, or stages them when batches of the encoding
+// cannot be appended to each other, rotating the file once it exceeds MaxBytes.
+// It returns a *RejectedError for events that could not be encoded.
+func (s *FileSink) Write(_ context.Context, events []*model.ScrubbedEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		if err := s.open(); err != nil {
+			s.err = err
+			return err
+		}
+	}
+
+	var rejected []*formatter.RecordError
+	var err error
+	out := &countingWriter{w: s.file}
+	if s.opts.Encoding.Concatenable() {
+		rejected, err = s.opts.Encoding.Encode(out, events)
+	} else {
+		rejected, err = stage(out, events)
+	}
+	if err != nil {
+		// cut the torn batch off, so the next write does not continue a partial line
+		s.err = errors.Join(fmt.Errorf("failed to write to %s: %w", s.file.Name(), err), s.truncate())
+		return s.err
+	}
+	s.size += out.n
+
+	// the events are accepted at this point, a failed rotation is retried and surfaced by Healthy
+	if s.opts.MaxBytes > 0 && s.size >= s.opts.MaxBytes {
+		s.err = s.rotate()
+		return rejectedError(rejected)
+	}
+	s.err = nil
+	return rejectedError(rejected)
+}
+
+// Flush syncs the current file to disk
+func (s *FileSink) Flush(_ context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	return s.file.Sync()
+}
+
+// Close stops age based rotation and finalizes the current file
+func (s *FileSink) Close() error {
+	s.closing.Do(func() { close(s.stop) })
+	<-s.done
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rotate()
+}
+
+// Healthy returns the last write or rotation error, or an error if the directory is no longer accessible
+func (s *FileSink) Healthy() error {
+	s.mu.Lock()
+	err := s.err
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(s.opts.Directory); err != nil {
+		return fmt.Errorf("sink directory is not accessible: %w", err)
+	}
+	return nil
+}
+
+func (s *FileSink) rotateOnAge() {
+	defer close(s.done)
+	if s.opts.MaxAge <= 0 {
+		<-s.stop
+		return
+	}
+
+	ticker := time.NewTicker(max(s.opts.MaxAge/4, minAgeCheckInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			if s.file != nil && s.now().Sub(s.openedAt) >= s.opts.MaxAge {
+				s.err = s.rotate()
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+func (s *FileSink) open() error {
+	s.baseName = filePrefix + s.now().UTC().Format("20060102T150405.000000000Z") + s.opts.Encoding.FileExtension()
+	path := s.tempPath(s.baseName)
+	if !s.opts.Encoding.Concatenable() {
+		path = s.stagedPath(s.baseName)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to create sink file: %w", err)
+	}
+	s.file = file
+	s.size = 0
+	s.openedAt = s.now()
+	return nil
+}
+
+// truncate cuts the current file back to the end of the last complete write
+func (s *FileSink) truncate() error {
+	if err := s.file.Truncate(s.size); err != nil {
+		return fmt.Errorf("failed to truncate %s: %w", s.file.Name(), err)
+	}
+	if _, err := s.file.Seek(s.size, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to truncate %s: %w", s.file.Name(), err)
+	}
+	return nil
+}
+
+// rotate finalizes the current file, the next Write opens a new one. A file nothing was written to is removed.
+func (s *FileSink) rotate() error {
+	if s.file == nil {
+		return nil
+	}
+
+	path := s.file.Name()
+	syncErr := s.file.Sync()
+	closeErr := s.file.Close()
+	s.file = nil
+	if err := errors.Join(syncErr, closeErr); err != nil {
+		return fmt.Errorf("failed to close sink file: %w", err)
+	}
+	if s.size == 0 {
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove empty sink file: %w", err)
+		}
+		return nil
+	}
+	if !s.opts.Encoding.Concatenable() {
+		return s.encodeStaged(s.baseName)
+	}
+	return s.finalize(s.baseName)
+}
+
+// encodeStaged encodes the events staged for baseName into its temp file and finalizes it.
+// The staged file is removed before the temp file is finalized, so recover finalizes a temp file
+// only once all of its events are in it and encodes the staged events again otherwise.
+func (s *FileSink) encodeStaged(baseName string) error {
+	stagedPath := s.stagedPath(baseName)
+	events, err := readStaged(stagedPath)
+	if err != nil {
+		return err
+	}
+	if len(events) == 0 {
+		// only a torn line was staged
+		if err := os.Remove(stagedPath); err != nil {
+			return fmt.Errorf("failed to remove staged sink file: %w", err)
+		}
+		return syncDir(s.opts.Directory)
+	}
+
+	tempPath := s.tempPath(baseName)
+	if err := s.encodeFile(tempPath, events); err != nil {
+		// discard the partial file, the staged events are encoded again by the next recover
+		return errors.Join(err, os.Remove(tempPath))
+	}
+	if err := os.Remove(stagedPath); err != nil {
+		return fmt.Errorf("failed to remove staged sink file: %w", err)
+	}
+	return s.finalize(baseName)
+}
+
+// encodeFile writes events to a new file at path in the format of the encoding
+func (s *FileSink) encodeFile(path string, events []*model.ScrubbedEvent) (err error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to create sink file: %w", err)
+	}
+	defer func() {
+		if closeErr := file.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("failed to close sink file: %w", closeErr)
+		}
+	}()
+
+	// staged events were encoded as JSON once already, so none of them are rejected
+	if _, err := s.opts.Encoding.Encode(file, events); err != nil {
+		return fmt.Errorf("failed to write to %s: %w", path, err)
+	}
+	return file.Sync()
+}
+
+// finalize moves the temp file of baseName to its final name, compressing it if configured
+func (s *FileSink) finalize(baseName string) error {
+	tempPath := s.tempPath(baseName)
+	finalPath := filepath.Join(s.opts.Directory, baseName)
+
+	if s.opts.Compression {
+		compressedTempPath := s.tempPath(baseName + gzipExtension)
+		if err := compressFile(tempPath, compressedTempPath); err != nil {
+			return err
+		}
+		if err := os.Rename(compressedTempPath, finalPath+gzipExtension); err != nil {
+			return fmt.Errorf("failed to finalize sink file: %w", err)
+		}
+		if err := os.Remove(tempPath); err != nil {
+			return fmt.Errorf("failed to remove sink temp file: %w", err)
+		}
+	} else if err := os.Rename(tempPath, finalPath); err != nil {
+		return fmt.Errorf("failed to finalize sink file: %w", err)
+	}
+
+	return syncDir(s.opts.Directory)
+}
+
+// recover finalizes temp files left behind by a previous run, encodes their staged events
+// and removes partially compressed and partially encoded files
+func (s *FileSink) recover() error {
+	entries, err := os.ReadDir(s.opts.Directory)
+	if err != nil {
+		return fmt.Errorf("failed to read sink directory: %w", err)
+	}
+
+	staged := make(map[string]bool)
+	for _, entry := range entries {
+		if name := entry.Name(); strings.HasPrefix(name, "."+filePrefix) && strings.HasSuffix(name, stagedExtension) {
+			staged[strings.TrimSuffix(strings.TrimPrefix(name, "."), stagedExtension)] = true
+		}
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, "."+filePrefix) {
+			continue
+		}
+
+		switch {
+		case strings.HasSuffix(name, stagedExtension):
+			baseName := strings.TrimSuffix(strings.TrimPrefix(name, "."), stagedExtension)
+			if isEmpty(entry) {
+				err = os.Remove(filepath.Join(s.opts.Directory, name))
+			} else {
+				err = s.encodeStaged(baseName)
+			}
+		case strings.HasSuffix(name, tempExtension):
+			baseName := strings.TrimSuffix(strings.TrimPrefix(name, "."), tempExtension)
+			switch {
+			case staged[baseName]:
+				// partially encoded from staged events, which encodeStaged encodes into it again
+			case strings.HasSuffix(baseName, gzipExtension), isEmpty(entry):
+				err = os.Remove(filepath.Join(s.opts.Directory, name))
+			default:
+				err = s.finalize(baseName)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to recover sink file %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (s *FileSink) tempPath(baseName string) string {
+	return filepath.Join(s.opts.Directory, "."+baseName+tempExtension)
+}
+
+func (s *FileSink) stagedPath(baseName string) string {
+	return filepath.Join(s.opts.Directory, "."+baseName+stagedExtension)
+}
+
+// stage writes the events as JSON lines, rejecting events that cannot be encoded as JSON
+func stage(w io.Writer, events []*model.ScrubbedEvent) ([]*formatter.RecordError, error) {
+	var buf bytes.Buffer
+	var rejected []*formatter.RecordError
+	for _, event := range events {
+		b, err := json.Marshal(event)
+		if err != nil {
+			rejected = append(rejected, &formatter.RecordError{Event: event, Err: err})
+			continue
+		}
+		buf.Write(b)
+		buf.WriteByte('\n')
+	}
+	_, err := w.Write(buf.Bytes())
+	return rejected, err
+}
+
+// readStaged reads the events staged in the file at path, ignoring a torn last line
+func readStaged(path string) ([]*model.ScrubbedEvent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open staged sink file: %w", err)
+	}
+	defer f.Close() //revive:disable:unhandled-error
+
+	var events []*model.ScrubbedEvent
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			return events, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read staged sink file: %w", err)
+		}
+		var event model.ScrubbedEvent
+		if err := json.Unmarshal(line, &event); err != nil {
+			return nil, fmt.Errorf("failed to decode staged event: %w", err)
+		}
+		events = append(events, &event)
+	}
+}
+
+func compressFile(srcPath, dstPath string) (err error) {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return fmt.Errorf("failed to open sink file for compression: %w", err)
+	}
+	defer src.Close() //revive:disable:unhandled-error
+
+	dst, err := os.OpenFile(dstPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to create compressed sink file: %w", err)
+	}
+	defer func() {
+		if closeErr := dst.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("failed to close compressed sink file: %w", closeErr)
+		}
+	}()
+
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		return fmt.Errorf("failed to compress sink file: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to compress sink file: %w", err)
+	}
+	return dst.Sync()
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close() //revive:disable:unhandled-error
+	return d.Sync()
+}
+
+func isEmpty(entry os.DirEntry) bool {
+	info, err := entry.Info()
+	return err == nil && info.Size() == 0
+}
+
+// countingWriter counts the bytes written to the current file
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,252 @@
+package sink
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/formatter"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testEvents(ids ...string) []*model.ScrubbedEvent {
+	events := make([]*model.ScrubbedEvent, 0, len(ids))
+	for _, id := range ids {
+		events = append(events, &model.ScrubbedEvent{
+			Id:       id,
+			Type:     "com.qlik.v1.some_event",
+			Time:     "2023-10-01T12:00:00Z",
+			TenantId: "tenant_123",
+			Data:     map[string]any{"foo": "bar"},
+		})
+	}
+	return events
+}
+
+func finalizedFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), ".") {
+			names = append(names, entry.Name())
+		}
+	}
+	return names
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, gzipExtension) {
+		gz, err := gzip.NewReader(f)
+		require.NoError(t, err)
+		r = gz
+	}
+	b, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(b)
+}
+
+func TestFileSink_RotatesBySize(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewFileSink(FileSinkOptions{Directory: dir, MaxBytes: 1})
+	require.NoError(t, err)
+
+	require.NoError(t, s.Write(context.Background(), testEvents("1")))
+	require.NoError(t, s.Write(context.Background(), testEvents("2", "3")))
+	require.NoError(t, s.Close())
+	require.NoError(t, s.Close(), "closing again is a no-op")
+
+	files := finalizedFiles(t, dir)
+	require.Len(t, files, 2)
+	assert.Equal(t, 1, strings.Count(readFile(t, filepath.Join(dir, files[0])), "\n"))
+	assert.Equal(t, 2, strings.Count(readFile(t, filepath.Join(dir, files[1])), "\n"))
+	assert.Contains(t, readFile(t, filepath.Join(dir, files[0])), `"idempotencyKey":"1"`)
+}
+
+func csvEncoding(t *testing.T) formatter.Encoding {
+	t.Helper()
+	encoding, err := formatter.NewEncoding(formatter.EncodingOptions{Format: formatter.FormatCSV, Flatten: formatter.DefaultOptions()})
+	require.NoError(t, err)
+	return encoding
+}
+
+func TestFileSink_CSVEncodesStagedEvents(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewFileSink(FileSinkOptions{Directory: dir, Encoding: csvEncoding(t)})
+	require.NoError(t, err)
+
+	events := testEvents("1", "2", "3", "4")
+	events[3].Data = map[string]any{"ch": make(chan int)}
+	require.NoError(t, s.Write(context.Background(), events[:1]))
+	var rejected *RejectedError
+	require.ErrorAs(t, s.Write(context.Background(), events[1:]), &rejected)
+	require.Len(t, rejected.Rejected, 1)
+	assert.Same(t, events[3], rejected.Rejected[0].Event)
+	assert.Empty(t, finalizedFiles(t, dir), "staged events must not be visible before the file is rotated")
+	require.NoError(t, s.Close())
+
+	files := finalizedFiles(t, dir)
+	require.Len(t, files, 1)
+	assert.True(t, strings.HasSuffix(files[0], ".csv"))
+	assert.Equal(t, "customerId,dimension.data.foo,eventName,idempotencyKey,timestamp\r\n"+
+		"tenant_123,bar,com.qlik.v1.some_event,1,2023-10-01T12:00:00Z\r\n"+
+		"tenant_123,bar,com.qlik.v1.some_event,2,2023-10-01T12:00:00Z\r\n"+
+		"tenant_123,bar,com.qlik.v1.some_event,3,2023-10-01T12:00:00Z\r\n",
+		readFile(t, filepath.Join(dir, files[0])))
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "the staged file is removed")
+}
+
+func TestFileSink_CSVRotatesBySize(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewFileSink(FileSinkOptions{Directory: dir, Encoding: csvEncoding(t), MaxBytes: 1})
+	require.NoError(t, err)
+
+	require.NoError(t, s.Write(context.Background(), testEvents("1")))
+	require.NoError(t, s.Write(context.Background(), testEvents("2", "3")))
+	require.NoError(t, s.Close())
+
+	files := finalizedFiles(t, dir)
+	require.Len(t, files, 2)
+	for i, rows := range []int{2, 3} {
+		content := readFile(t, filepath.Join(dir, files[i]))
+		assert.Equal(t, rows, strings.Count(content, "\r\n"))
+		assert.True(t, strings.HasPrefix(content, "customerId,dimension.data.foo,eventName,idempotencyKey,timestamp\r\n"))
+	}
+}
+
+func TestFileSink_RecoversStagedFiles(t *testing.T) {
+	dir := t.TempDir()
+	staged := `{"Id":"1","TenantId":"tenant_123","Type":"com.qlik.v1.some_event","Data":{"n":5}}` + "\n" + `{"Id":"2","Ten`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".events-1.csv.staged"), []byte(staged), 0o600))
+	// a file that was partially encoded from staged events before a crash
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".events-1.csv.tmp"), []byte("customerId"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".events-2.csv.staged"), nil, 0o600))
+
+	s, err := NewFileSink(FileSinkOptions{Directory: dir, Encoding: csvEncoding(t)})
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "events-1.csv", entries[0].Name())
+	assert.Equal(t, "customerId,dimension.data.n,eventName,idempotencyKey,timestamp\r\ntenant_123,5,com.qlik.v1.some_event,1,\r\n",
+		readFile(t, filepath.Join(dir, entries[0].Name())))
+}
+
+func TestFileSink_RejectsEvents(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewFileSink(FileSinkOptions{Directory: dir})
+	require.NoError(t, err)
+
+	events := testEvents("1", "2")
+	events[1].Data = map[string]any{"ch": make(chan int)}
+	var rejected *RejectedError
+	require.ErrorAs(t, s.Write(context.Background(), events), &rejected)
+	require.Len(t, rejected.Rejected, 1)
+	assert.Same(t, events[1], rejected.Rejected[0].Event)
+
+	// a batch that is rejected entirely leaves no file behind
+	require.ErrorAs(t, s.Write(context.Background(), events[1:]), &rejected)
+	require.NoError(t, s.Close())
+
+	files := finalizedFiles(t, dir)
+	require.Len(t, files, 1)
+	assert.Equal(t, 1, strings.Count(readFile(t, filepath.Join(dir, files[0])), "\n"))
+}
+
+func TestFileSink_RotatesByAge(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewFileSink(FileSinkOptions{Directory: dir, MaxAge: 50 * time.Millisecond})
+	require.NoError(t, err)
+	defer s.Close()
+
+	require.NoError(t, s.Write(context.Background(), testEvents("1")))
+	assert.Empty(t, finalizedFiles(t, dir), "current file must not be visible before it is finalized")
+
+	require.Eventually(t, func() bool {
+		return len(finalizedFiles(t, dir)) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestFileSink_Compression(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewFileSink(FileSinkOptions{Directory: dir, Compression: true})
+	require.NoError(t, err)
+
+	require.NoError(t, s.Write(context.Background(), testEvents("1", "2")))
+	require.NoError(t, s.Flush(context.Background()))
+	require.NoError(t, s.Healthy())
+	require.NoError(t, s.Close())
+
+	files := finalizedFiles(t, dir)
+	require.Len(t, files, 1)
+	assert.True(t, strings.HasSuffix(files[0], ".ndjson"+gzipExtension))
+	content := readFile(t, filepath.Join(dir, files[0]))
+	assert.Equal(t, 2, strings.Count(content, "\n"))
+	assert.Contains(t, content, `"idempotencyKey":"2"`)
+}
+
+func TestFileSink_RecoversTempFiles(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".events-1.ndjson.tmp"), []byte("{}\n"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".events-2.ndjson.tmp"), nil, 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".events-1.ndjson.gz.tmp"), []byte("partial"), 0o600))
+
+	s, err := NewFileSink(FileSinkOptions{Directory: dir, Compression: true})
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "events-1.ndjson.gz", entries[0].Name())
+	assert.Equal(t, "{}\n", readFile(t, filepath.Join(dir, entries[0].Name())))
+}
+
+func TestFileSink_TruncatesTornWrites(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewFileSink(FileSinkOptions{Directory: dir})
+	require.NoError(t, err)
+
+	require.NoError(t, s.Write(context.Background(), testEvents("1")))
+	_, err = s.file.WriteString(`{"idempotencyKey":"torn`)
+	require.NoError(t, err)
+	require.NoError(t, s.truncate())
+	require.NoError(t, s.Write(context.Background(), testEvents("2")))
+	require.NoError(t, s.Close())
+
+	files := finalizedFiles(t, dir)
+	require.Len(t, files, 1)
+	content := readFile(t, filepath.Join(dir, files[0]))
+	assert.Equal(t, 2, strings.Count(content, "\n"))
+	assert.NotContains(t, content, "torn")
+}
+
+func TestFileSink_Healthy(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "events")
+	s, err := NewFileSink(FileSinkOptions{Directory: dir})
+	require.NoError(t, err)
+	defer s.Close()
+	require.NoError(t, s.Healthy())
+
+	require.NoError(t, os.RemoveAll(dir))
+	require.Error(t, s.Healthy())
+	require.Error(t, s.Write(context.Background(), testEvents("1")))
+}
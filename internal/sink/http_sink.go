@@ -0,0 +1,337 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/formatter"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/model"
+)
+
+// ErrPermanent is wrapped by errors for events the destination rejected and will keep rejecting.
+// Callers must not retry these events and should route them to a dead-letter path.
+var ErrPermanent = errors.New("permanent sink failure")
+
+// errClosed is returned by Write once the sink is closed
+var errClosed = errors.New("sink is closed")
+
+// HTTPSinkOptions configures an HTTPSink
+type HTTPSinkOptions struct {
+	// URL batches are POSTed to
+	URL string
+	// BatchSize sends the pending batch once it holds this many events
+	BatchSize int
+	// Linger sends the pending batch once its first event has waited this long
+	Linger time.Duration
+	// MaxRetries is the number of times a batch is retried after a retryable failure
+	MaxRetries int
+	// InitialBackoff is the backoff before the first retry, it doubles with every retry
+	InitialBackoff time.Duration
+	// MaxBackoff caps the backoff between retries, including the Retry-After of webhook responses
+	MaxBackoff time.Duration
+	// Timeout of a single request
+	Timeout time.Duration
+	// Encoding encodes the body of each request, it defaults to newline delimited JSON with formatter.DefaultOptions
+	Encoding formatter.Encoding
+	// Compression compresses request bodies with formatter.CompressionGzip or formatter.CompressionZstd,
+	// they are sent uncompressed by default
+	Compression string
+}
+
+// HTTPSink POSTs batches of events in the format of its encoding to a webhook.
+// 5xx, 408 and 429 responses and transport errors are retried with exponential backoff and jitter,
+// honoring Retry-After. Other 4xx responses fail the batch with ErrPermanent.
+// Write blocks until the batch containing its events has been delivered or has failed.
+type HTTPSink struct {
+	opts   HTTPSinkOptions
+	client *http.Client
+
+	writes  chan *pendingWrite
+	flushes chan chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+	closing sync.Once
+
+	mu  sync.Mutex
+	err error
+}
+
+type pendingWrite struct {
+	events []*model.ScrubbedEvent
+	result chan error
+}
+
+// NewHTTPSink creates an HTTPSink and starts its batching loop
+func NewHTTPSink(opts HTTPSinkOptions) (*HTTPSink, error) {
+	if opts.URL == "" {
+		return nil, errors.New("http sink url is required")
+	}
+	opts.BatchSize = max(opts.BatchSize, 1)
+	if !slices.Contains([]string{"", formatter.CompressionNone, formatter.CompressionGzip, formatter.CompressionZstd}, opts.Compression) {
+		return nil, fmt.Errorf("unknown http sink compression %q", opts.Compression)
+	}
+	if opts.Encoding == nil {
+		opts.Encoding = formatter.NDJSON(formatter.DefaultOptions())
+	}
+
+	s := &HTTPSink{
+		opts:    opts,
+		client:  &http.Client{Timeout: opts.Timeout},
+		writes:  make(chan *pendingWrite),
+		flushes: make(chan chan struct{}),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+// Write adds the events to the pending batch and waits until the batch has been sent
+func (s *HTTPSink) Write(ctx context.Context, events []*model.ScrubbedEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	w := &pendingWrite{events: events, result: make(chan error, 1)}
+	select {
+	case s.writes <- w:
+	case <-s.stop:
+		return errClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case err := <-w.result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Flush sends the pending batch without waiting for it to fill up
+func (s *HTTPSink) Flush(ctx context.Context) error {
+	flushed := make(chan struct{})
+	select {
+	case s.flushes <- flushed:
+	case <-s.stop:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close sends the pending batch and stops the batching loop
+func (s *HTTPSink) Close() error {
+	s.closing.Do(func() { close(s.stop) })
+	<-s.done
+	return nil
+}
+
+// Healthy returns the error of the last batch if it could not be delivered
+func (s *HTTPSink) Healthy() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *HTTPSink) run() {
+	defer close(s.done)
+
+	var batch []*pendingWrite
+	size := 0
+	linger := time.NewTimer(s.opts.Linger)
+	linger.Stop()
+	defer linger.Stop()
+
+	send := func() {
+		linger.Stop()
+		if len(batch) > 0 {
+			s.sendBatch(batch)
+		}
+		batch, size = nil, 0
+	}
+
+	for {
+		select {
+		case w := <-s.writes:
+			if len(batch) == 0 {
+				linger.Reset(s.opts.Linger)
+			}
+			batch = append(batch, w)
+			size += len(w.events)
+			if size >= s.opts.BatchSize {
+				send()
+			}
+		case <-linger.C:
+			send()
+		case flushed := <-s.flushes:
+			send()
+			close(flushed)
+		case <-s.stop:
+			send()
+			return
+		}
+	}
+}
+
+func (s *HTTPSink) sendBatch(batch []*pendingWrite) {
+	var events []*model.ScrubbedEvent
+	for _, w := range batch {
+		events = append(events, w.events...)
+	}
+
+	rejected, err := s.post(events)
+	s.mu.Lock()
+	if errors.Is(err, ErrPermanent) {
+		// the destination is reachable, only this batch is bad
+		s.err = nil
+	} else {
+		s.err = err
+	}
+	s.mu.Unlock()
+
+	rejectedByEvent := make(map[*model.ScrubbedEvent]*formatter.RecordError, len(rejected))
+	for _, recordErr := range rejected {
+		rejectedByEvent[recordErr.Event] = recordErr
+	}
+	for _, w := range batch {
+		if err != nil {
+			w.result <- err
+			continue
+		}
+		var writeRejected []*formatter.RecordError
+		for _, event := range w.events {
+			if recordErr, ok := rejectedByEvent[event]; ok {
+				writeRejected = append(writeRejected, recordErr)
+			}
+		}
+		w.result <- rejectedError(writeRejected)
+	}
+}
+
+// post encodes the events and sends the ones that could be encoded, retrying retryable failures
+func (s *HTTPSink) post(events []*model.ScrubbedEvent) ([]*formatter.RecordError, error) {
+	var body bytes.Buffer
+	var out io.Writer = &body
+	compressor, err := formatter.Compress(&body, s.opts.Compression)
+	if err != nil {
+		return nil, err
+	}
+	if compressor != nil {
+		out = compressor
+	}
+	rejected, err := s.opts.Encoding.Encode(out, events)
+	if compressor != nil {
+		// the compressor returns to its pool on close, so it is closed when encoding failed too
+		err = errors.Join(err, compressor.Close())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode events: %w", err)
+	}
+	if len(rejected) == len(events) {
+		return rejected, nil
+	}
+
+	for attempt := 0; ; attempt++ {
+		var retryAfter time.Duration
+		retryAfter, err = s.postOnce(body.Bytes())
+		if err == nil || errors.Is(err, ErrPermanent) {
+			return rejected, err
+		}
+		if attempt >= s.opts.MaxRetries {
+			return nil, fmt.Errorf("giving up after %d retries: %w", attempt, err)
+		}
+
+		select {
+		case <-time.After(s.retryWait(attempt, retryAfter)):
+		case <-s.stop:
+			// still deliver on shutdown, but do not keep waiting out long backoffs
+			if attempt > 0 {
+				return nil, fmt.Errorf("sink closed while retrying: %w", err)
+			}
+		}
+	}
+}
+
+// postOnce sends a single request, returning the Retry-After duration of a retryable response
+func (s *HTTPSink) postOnce(body []byte) (time.Duration, error) {
+	req, err := http.NewRequest(http.MethodPost, s.opts.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("%w: failed to create request: %w", ErrPermanent, err)
+	}
+	req.Header.Set("Content-Type", s.opts.Encoding.ContentType())
+	if s.opts.Compression != "" && s.opts.Compression != formatter.CompressionNone {
+		req.Header.Set("Content-Encoding", s.opts.Compression)
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		httpRequestsCounter.WithLabelValues("error").Inc()
+		return 0, fmt.Errorf("failed to post batch: %w", err)
+	}
+	defer res.Body.Close()               //revive:disable:unhandled-error
+	_, _ = io.Copy(io.Discard, res.Body) // drain so the connection can be reused
+	httpRequestsCounter.WithLabelValues(strconv.Itoa(res.StatusCode)).Inc()
+
+	switch {
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		return 0, nil
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode == http.StatusRequestTimeout || res.StatusCode >= 500:
+		return parseRetryAfter(res.Header.Get("Retry-After")), fmt.Errorf("webhook responded with status %d", res.StatusCode)
+	default:
+		return 0, fmt.Errorf("%w: webhook responded with status %d", ErrPermanent, res.StatusCode)
+	}
+}
+
+// retryWait returns how long to wait before retrying attempt. A Retry-After of the webhook is honored up to
+// MaxBackoff, so a single response cannot stall every write queued behind the batch.
+func (s *HTTPSink) retryWait(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter <= 0 {
+		return s.backoff(attempt)
+	}
+	return min(retryAfter, s.opts.MaxBackoff)
+}
+
+// backoff returns the exponential backoff for attempt with equal jitter
+func (s *HTTPSink) backoff(attempt int) time.Duration {
+	backoff := s.opts.InitialBackoff << attempt
+	if backoff <= 0 || backoff > s.opts.MaxBackoff {
+		backoff = s.opts.MaxBackoff
+	}
+	half := backoff / 2
+	if half <= 0 {
+		return backoff
+	}
+	return half + rand.N(half)
+}
+
+// parseRetryAfter parses a Retry-After header in either delay-seconds or HTTP-date form
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0)
+	}
+	return 0
+}
@@ -0,0 +1,277 @@
+package sink
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/formatter"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type webhook struct {
+	mu       sync.Mutex
+	batches  [][]string
+	requests atomic.Int32
+	respond  func(n int32, w http.ResponseWriter) bool
+}
+
+func (h *webhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n := h.requests.Add(1)
+	if h.respond != nil && !h.respond(n, w) {
+		return
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(r.Body)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	h.mu.Lock()
+	h.batches = append(h.batches, lines)
+	h.mu.Unlock()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func newTestHTTPSink(t *testing.T, h http.Handler, opts HTTPSinkOptions) *HTTPSink {
+	t.Helper()
+	server := httptest.NewServer(h)
+	t.Cleanup(server.Close)
+
+	opts.URL = server.URL
+	s, err := NewHTTPSink(opts)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+func TestHTTPSink_BatchesBySize(t *testing.T) {
+	h := &webhook{}
+	s := newTestHTTPSink(t, h, HTTPSinkOptions{BatchSize: 3, Linger: time.Hour})
+
+	var wg sync.WaitGroup
+	for _, id := range []string{"1", "2", "3"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, s.Write(context.Background(), testEvents(id)))
+		}()
+	}
+	wg.Wait()
+
+	require.Len(t, h.batches, 1)
+	assert.Len(t, h.batches[0], 3)
+}
+
+func TestHTTPSink_BatchesByLinger(t *testing.T) {
+	h := &webhook{}
+	s := newTestHTTPSink(t, h, HTTPSinkOptions{BatchSize: 100, Linger: 20 * time.Millisecond})
+
+	require.NoError(t, s.Write(context.Background(), testEvents("1", "2")))
+	require.Len(t, h.batches, 1)
+	assert.Len(t, h.batches[0], 2)
+	assert.Contains(t, h.batches[0][0], `"idempotencyKey":"1"`)
+}
+
+func TestHTTPSink_CSV(t *testing.T) {
+	var contentType, body string
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+		w.WriteHeader(http.StatusAccepted)
+	})
+	encoding, err := formatter.NewEncoding(formatter.EncodingOptions{Format: formatter.FormatCSV, Flatten: formatter.DefaultOptions()})
+	require.NoError(t, err)
+	s := newTestHTTPSink(t, h, HTTPSinkOptions{BatchSize: 2, Linger: time.Hour, Encoding: encoding})
+
+	require.NoError(t, s.Write(context.Background(), testEvents("1", "2")))
+	assert.Equal(t, "text/csv; charset=utf-8; header=present", contentType)
+	assert.Equal(t, "customerId,dimension.data.foo,eventName,idempotencyKey,timestamp\r\n"+
+		"tenant_123,bar,com.qlik.v1.some_event,1,2023-10-01T12:00:00Z\r\n"+
+		"tenant_123,bar,com.qlik.v1.some_event,2,2023-10-01T12:00:00Z\r\n", body)
+}
+
+func TestHTTPSink_Compression(t *testing.T) {
+	var contentEncoding string
+	var lines []string
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentEncoding = r.Header.Get("Content-Encoding")
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		scanner := bufio.NewScanner(gz)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+	s := newTestHTTPSink(t, h, HTTPSinkOptions{BatchSize: 2, Linger: time.Hour, Compression: formatter.CompressionGzip})
+
+	require.NoError(t, s.Write(context.Background(), testEvents("1", "2")))
+	assert.Equal(t, "gzip", contentEncoding)
+	assert.Len(t, lines, 2)
+
+	_, err := NewHTTPSink(HTTPSinkOptions{URL: "http://localhost:8080/events", Compression: "lz4"})
+	require.Error(t, err)
+}
+
+// failingEncoding writes part of a payload and fails, keeping the writer it was given
+type failingEncoding struct {
+	formatter.Encoding
+	w io.Writer
+}
+
+func (e *failingEncoding) Encode(w io.Writer, _ []*model.ScrubbedEvent) ([]*formatter.RecordError, error) {
+	e.w = w
+	_, _ = w.Write([]byte("partial"))
+	return nil, errors.New("encoding failed")
+}
+
+func TestHTTPSink_ClosesCompressorWhenEncodingFails(t *testing.T) {
+	h := &webhook{}
+	encoding := &failingEncoding{Encoding: formatter.NDJSON(formatter.DefaultOptions())}
+	s := newTestHTTPSink(t, h, HTTPSinkOptions{BatchSize: 2, Linger: time.Hour, Compression: formatter.CompressionZstd, Encoding: encoding})
+
+	require.Error(t, s.Write(context.Background(), testEvents("1", "2")))
+	assert.Zero(t, h.requests.Load())
+	// the compressor was closed and returned to its pool
+	_, err := encoding.w.Write([]byte("record"))
+	require.Error(t, err)
+}
+
+func TestHTTPSink_RejectsEvents(t *testing.T) {
+	h := &webhook{}
+	s := newTestHTTPSink(t, h, HTTPSinkOptions{BatchSize: 1, Linger: time.Hour})
+
+	events := testEvents("1", "2")
+	events[0].Data = map[string]any{"ratio": math.Inf(1)}
+	var rejected *RejectedError
+	require.ErrorAs(t, s.Write(context.Background(), events), &rejected)
+	require.Len(t, rejected.Rejected, 1)
+	assert.Same(t, events[0], rejected.Rejected[0].Event)
+	require.Len(t, h.batches, 1)
+	assert.Len(t, h.batches[0], 1)
+
+	// a batch that is rejected entirely is not sent
+	require.ErrorAs(t, s.Write(context.Background(), events[:1]), &rejected)
+	require.ErrorAs(t, s.Write(context.Background(), events[:1]), &rejected)
+	assert.Equal(t, int32(1), h.requests.Load())
+}
+
+func TestHTTPSink_RetriesRetryableResponses(t *testing.T) {
+	h := &webhook{respond: func(n int32, w http.ResponseWriter) bool {
+		switch n {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return false
+		case 2:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return false
+		}
+		return true
+	}}
+	s := newTestHTTPSink(t, h, HTTPSinkOptions{BatchSize: 1, MaxRetries: 3, InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond})
+
+	require.NoError(t, s.Write(context.Background(), testEvents("1")))
+	assert.Equal(t, int32(3), h.requests.Load())
+	require.Len(t, h.batches, 1)
+	require.NoError(t, s.Healthy())
+}
+
+func TestHTTPSink_GivesUpAfterMaxRetries(t *testing.T) {
+	h := &webhook{respond: func(_ int32, w http.ResponseWriter) bool {
+		w.WriteHeader(http.StatusBadGateway)
+		return false
+	}}
+	s := newTestHTTPSink(t, h, HTTPSinkOptions{BatchSize: 1, MaxRetries: 2, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond})
+
+	err := s.Write(context.Background(), testEvents("1"))
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrPermanent)
+	assert.Equal(t, int32(3), h.requests.Load())
+	require.Error(t, s.Healthy())
+}
+
+func TestHTTPSink_ClientErrorIsPermanent(t *testing.T) {
+	h := &webhook{respond: func(_ int32, w http.ResponseWriter) bool {
+		w.WriteHeader(http.StatusBadRequest)
+		return false
+	}}
+	s := newTestHTTPSink(t, h, HTTPSinkOptions{BatchSize: 1, MaxRetries: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond})
+
+	err := s.Write(context.Background(), testEvents("1"))
+	require.ErrorIs(t, err, ErrPermanent)
+	assert.Equal(t, int32(1), h.requests.Load())
+	require.NoError(t, s.Healthy())
+}
+
+func TestHTTPSink_FlushAndClose(t *testing.T) {
+	h := &webhook{}
+	s := newTestHTTPSink(t, h, HTTPSinkOptions{BatchSize: 100, Linger: time.Hour})
+
+	result := make(chan error)
+	go func() { result <- s.Write(context.Background(), testEvents("1")) }()
+	require.Eventually(t, func() bool {
+		require.NoError(t, s.Flush(context.Background()))
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		return len(h.batches) == 1
+	}, time.Second, 10*time.Millisecond)
+	require.NoError(t, <-result)
+
+	require.NoError(t, s.Close())
+	require.Error(t, s.Write(context.Background(), testEvents("2")))
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	assert.Equal(t, time.Duration(0), parseRetryAfter(""))
+	assert.Equal(t, 2*time.Second, parseRetryAfter("2"))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon"))
+	d := parseRetryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	assert.InDelta(t, float64(time.Minute), float64(d), float64(2*time.Second))
+}
+
+func TestHTTPSink_CapsRetryAfter(t *testing.T) {
+	h := &webhook{respond: func(n int32, w http.ResponseWriter) bool {
+		if n == 1 {
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return false
+		}
+		return true
+	}}
+	s := newTestHTTPSink(t, h, HTTPSinkOptions{BatchSize: 1, MaxRetries: 1, InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	require.NoError(t, s.Write(ctx, testEvents("1")))
+	assert.Equal(t, int32(2), h.requests.Load())
+
+	assert.Equal(t, 5*time.Millisecond, s.retryWait(0, time.Hour))
+	assert.Equal(t, time.Millisecond, s.retryWait(0, time.Millisecond))
+}
+
+func TestHTTPSink_Backoff(t *testing.T) {
+	s := &HTTPSink{opts: HTTPSinkOptions{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}}
+	for attempt := range 10 {
+		d := s.backoff(attempt)
+		assert.LessOrEqual(t, d, time.Second)
+		assert.GreaterOrEqual(t, d, 50*time.Millisecond)
+	}
+	assert.Less(t, s.backoff(0), 100*time.Millisecond+time.Nanosecond)
+}
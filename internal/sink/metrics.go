@@ -0,0 +1,13 @@
+package sink
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// httpRequestsCounter counts webhook requests sent by the HTTP sink, by response status code
+var httpRequestsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "usage_telemetry_publisher",
+	Name:      "sink_http_requests_total",
+	Help:      "Number of webhook requests sent by the HTTP sink, by response status code",
+}, []string{"code"})
@@ -0,0 +1,102 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/qlik-trial/usage-telemetry-publisher/cmd/config"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/avro"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/formatter"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/model"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/parquet"
+)
+
+const (
+	// TypeStdout writes formatted events to standard output
+	TypeStdout = "stdout"
+	// TypeFile writes formatted events to rolling files in a local directory
+	TypeFile = "file"
+	// TypeHTTP posts batches of formatted events to a webhook
+	TypeHTTP = "http"
+)
+
+// RejectedError is returned by Write when some events could not be encoded, the other events were accepted.
+// It wraps ErrPermanent: writing the rejected events again fails the same way, so callers should dead-letter them.
+type RejectedError struct {
+	Rejected []*formatter.RecordError
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("%v: %d events could not be encoded, first: %v", ErrPermanent, len(e.Rejected), e.Rejected[0])
+}
+
+func (e *RejectedError) Unwrap() error {
+	return ErrPermanent
+}
+
+// rejectedError returns a *RejectedError for the rejected events, nil if there are none
+func rejectedError(rejected []*formatter.RecordError) error {
+	if len(rejected) == 0 {
+		return nil
+	}
+	return &RejectedError{Rejected: rejected}
+}
+
+// Sink is the destination scrubbed events are published to.
+// Write must only return nil once the events have been accepted by the destination.
+type Sink interface {
+	// Write publishes a batch of events
+	Write(ctx context.Context, events []*model.ScrubbedEvent) error
+	// Flush makes events accepted by Write durable at the destination
+	Flush(ctx context.Context) error
+	// Close flushes and releases the resources held by the sink
+	Close() error
+	// Healthy returns an error if the sink cannot currently accept events
+	Healthy() error
+}
+
+// New creates the Sink configured by cfg, avro files are written with the schema versions of avroSchemas
+func New(cfg *config.Spec, avroSchemas *avro.Registry) (Sink, error) {
+	format := formatter.Options{Arrays: cfg.FlattenArrayStrategy, MaxDepth: cfg.FlattenMaxDepth, MaxKeys: cfg.FlattenMaxKeys}
+	if err := format.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid flatten options: %w", err)
+	}
+	parquetOpts := parquet.Options{Compression: cfg.ParquetCompression}
+
+	switch cfg.SinkType {
+	case TypeStdout:
+		return NewWriterSink(os.Stdout, format), nil
+	case TypeFile:
+		encoding, err := formatter.NewEncoding(formatter.EncodingOptions{Format: cfg.FileSinkFormat, Flatten: format, Parquet: parquetOpts, AvroSchemas: avroSchemas})
+		if err != nil {
+			return nil, fmt.Errorf("invalid file sink format: %w", err)
+		}
+		return NewFileSink(FileSinkOptions{
+			Directory:   cfg.FileSinkDirectory,
+			MaxBytes:    cfg.FileSinkMaxSizeBytes,
+			MaxAge:      time.Duration(cfg.FileSinkMaxAgeSeconds) * time.Second,
+			Compression: cfg.FileSinkCompressionEnabled,
+			Encoding:    encoding,
+		})
+	case TypeHTTP:
+		encoding, err := formatter.NewEncoding(formatter.EncodingOptions{Format: cfg.HTTPSinkFormat, Flatten: format, Parquet: parquetOpts, AvroSchemas: avroSchemas})
+		if err != nil {
+			return nil, fmt.Errorf("invalid http sink format: %w", err)
+		}
+		return NewHTTPSink(HTTPSinkOptions{
+			URL:            cfg.HTTPSinkURL,
+			BatchSize:      cfg.HTTPSinkBatchSize,
+			Linger:         time.Duration(cfg.HTTPSinkLingerMilliseconds) * time.Millisecond,
+			MaxRetries:     cfg.HTTPSinkMaxRetries,
+			InitialBackoff: time.Duration(cfg.HTTPSinkInitialBackoffMilliseconds) * time.Millisecond,
+			MaxBackoff:     time.Duration(cfg.HTTPSinkMaxBackoffMilliseconds) * time.Millisecond,
+			Timeout:        time.Duration(cfg.HTTPSinkTimeoutMilliseconds) * time.Millisecond,
+			Encoding:       encoding,
+			Compression:    cfg.HTTPSinkCompression,
+		})
+	default:
+		return nil, fmt.Errorf("unknown sink type %q", cfg.SinkType)
+	}
+}
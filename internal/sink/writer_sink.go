@@ -0,0 +1,53 @@
+package sink
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/formatter"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/model"
+)
+
+// WriterSink writes events as newline delimited JSON to an io.Writer
+type WriterSink struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format formatter.Options
+}
+
+// NewWriterSink creates a WriterSink writing the records format flattens the events into to w
+func NewWriterSink(w io.Writer, format formatter.Options) *WriterSink {
+	return &WriterSink{w: w, format: format}
+}
+
+// Write streams the records of the events to the underlying writer, returning a *RejectedError for events that
+// could not be encoded
+func (s *WriterSink) Write(_ context.Context, events []*model.ScrubbedEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rejected, err := formatter.NDJSON(s.format).Encode(s.w, events)
+	if err != nil {
+		return err
+	}
+	return rejectedError(rejected)
+}
+
+// Flush is a no-op, events are written straight through to the underlying writer
+func (s *WriterSink) Flush(_ context.Context) error {
+	return nil
+}
+
+// Close is a no-op, the underlying writer is owned by the caller
+func (s *WriterSink) Close() error {
+	return nil
+}
+
+// Healthy always returns nil
+func (s *WriterSink) Healthy() error {
+	return nil
+}
@@ -0,0 +1,92 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/qlik-trial/usage-telemetry-publisher/cmd/config"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/formatter"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriterSink(t *testing.T) {
+	buf := &bytes.Buffer{}
+	s := NewWriterSink(buf, formatter.DefaultOptions())
+
+	err := s.Write(context.Background(), []*model.ScrubbedEvent{
+		{Id: "1", Type: "com.qlik.v1.some_event", Time: "2023-10-01T12:00:00Z", TenantId: "tenant_123", Data: map[string]any{"foo": "bar"}},
+		{Id: "2", Type: "com.qlik.v1.some_event", Time: "2023-10-01T12:00:01Z", TenantId: "tenant_123", Data: map[string]any{"foo": "baz"}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, `{"customerId":"tenant_123","dimension.data.foo":"bar","eventName":"com.qlik.v1.some_event","idempotencyKey":"1","timestamp":"2023-10-01T12:00:00Z"}
+{"customerId":"tenant_123","dimension.data.foo":"baz","eventName":"com.qlik.v1.some_event","idempotencyKey":"2","timestamp":"2023-10-01T12:00:01Z"}
+`, buf.String())
+
+	buf.Reset()
+	require.NoError(t, s.Write(context.Background(), nil))
+	require.Empty(t, buf.String())
+}
+
+func TestWriterSink_RejectsEvents(t *testing.T) {
+	buf := &bytes.Buffer{}
+	s := NewWriterSink(buf, formatter.DefaultOptions())
+
+	events := testEvents("1", "2")
+	events[0].Data = map[string]any{"ratio": math.NaN()}
+	err := s.Write(context.Background(), events)
+
+	var rejected *RejectedError
+	require.ErrorAs(t, err, &rejected)
+	require.ErrorIs(t, err, ErrPermanent)
+	require.Len(t, rejected.Rejected, 1)
+	require.Same(t, events[0], rejected.Rejected[0].Event)
+	require.Equal(t, 1, strings.Count(buf.String(), "\n"))
+	require.Contains(t, buf.String(), `"idempotencyKey":"2"`)
+}
+
+func TestNew(t *testing.T) {
+	s, err := New(&config.Spec{SinkType: TypeStdout}, nil)
+	require.NoError(t, err)
+	require.IsType(t, &WriterSink{}, s)
+
+	s, err = New(&config.Spec{SinkType: TypeFile, FileSinkDirectory: t.TempDir()}, nil)
+	require.NoError(t, err)
+	require.IsType(t, &FileSink{}, s)
+	require.NoError(t, s.Close())
+
+	s, err = New(&config.Spec{SinkType: TypeHTTP, HTTPSinkURL: "http://localhost:8080/events"}, nil)
+	require.NoError(t, err)
+	require.IsType(t, &HTTPSink{}, s)
+	require.NoError(t, s.Close())
+
+	_, err = New(&config.Spec{SinkType: TypeHTTP}, nil)
+	require.Error(t, err)
+
+	_, err = New(&config.Spec{SinkType: "unknown"}, nil)
+	require.Error(t, err)
+
+	_, err = New(&config.Spec{SinkType: TypeStdout, FlattenArrayStrategy: "rows"}, nil)
+	require.Error(t, err)
+
+	s, err = New(&config.Spec{SinkType: TypeFile, FileSinkDirectory: t.TempDir(), FileSinkFormat: formatter.FormatCSV}, nil)
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+
+	s, err = New(&config.Spec{SinkType: TypeFile, FileSinkDirectory: t.TempDir(), FileSinkFormat: formatter.FormatParquet}, nil)
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+
+	s, err = New(&config.Spec{SinkType: TypeHTTP, HTTPSinkURL: "http://localhost:8080/events", HTTPSinkFormat: formatter.FormatAvro}, nil)
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+
+	_, err = New(&config.Spec{SinkType: TypeFile, FileSinkDirectory: t.TempDir(), FileSinkFormat: formatter.FormatParquet, ParquetCompression: "lz4"}, nil)
+	require.Error(t, err)
+
+	_, err = New(&config.Spec{SinkType: TypeHTTP, HTTPSinkURL: "http://localhost:8080/events", HTTPSinkFormat: "xml"}, nil)
+	require.Error(t, err)
+}
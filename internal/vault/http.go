@@ -0,0 +1,84 @@
+package vault
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/qlik-trial/go-service-kit/v29/operation"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/auth"
+)
+
+// maxRequestBytes bounds the size of a detokenize request body
+const maxRequestBytes = 4096
+
+type detokenizeRequest struct {
+	Token string `json:"token"`
+}
+
+type detokenizeResponse struct {
+	Token string `json:"token"`
+	Value string `json:"value"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// RegisterRoutes adds the endpoint reversing tokens to router, behind middleware authorizing the caller.
+// The token is taken from the request body, so it does not end up in access logs.
+func RegisterRoutes(router *mux.Router, vault *Vault, middleware mux.MiddlewareFunc) {
+	router.Methods(http.MethodPost).Path("/detokenize").Name("detokenize").Handler(middleware(detokenizeHandler(vault)))
+}
+
+// detokenizeHandler returns the value behind a token. Every call is audit logged with the caller and the token,
+// never with the value.
+func detokenizeHandler(vault *Vault) http.HandlerFunc {
+	label := "vault/detokenizeHandler"
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req detokenizeRequest
+		audit := func(result string, err error) {
+			detokenizeRequestsCounter.WithLabelValues(result).Inc()
+			subject, tenantID := "", ""
+			if claims, ok := auth.ClaimsFromContext(r.Context()); ok {
+				subject, tenantID = claims.Subject, claims.TenantID
+			}
+			operation.Logger(r.Context()).Info(
+				"label", label,
+				"message", "detokenize request",
+				"audit", true,
+				"subject", subject,
+				"subjectTenantId", tenantID,
+				"token", req.Token,
+				"result", result,
+				"error", err)
+		}
+
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes)).Decode(&req); err != nil || req.Token == "" {
+			audit("bad_request", err)
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: "request body must hold a token"})
+			return
+		}
+
+		value, err := vault.Lookup(req.Token)
+		if errors.Is(err, ErrNotFound) {
+			audit("not_found", nil)
+			writeJSON(w, http.StatusNotFound, errorResponse{Error: err.Error()})
+			return
+		}
+		if err != nil {
+			audit("error", err)
+			writeJSON(w, http.StatusInternalServerError, errorResponse{Error: "failed to detokenize"})
+			return
+		}
+		audit("success", nil)
+		writeJSON(w, http.StatusOK, detokenizeResponse{Token: req.Token, Value: value})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
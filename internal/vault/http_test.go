@@ -0,0 +1,50 @@
+package vault
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoutes(t *testing.T) {
+	v, err := Open(filepath.Join(t.TempDir(), "tokens"), []byte("secret"))
+	require.NoError(t, err)
+	defer v.Close()
+	require.NoError(t, v.Store("token-1", "user-1"))
+
+	authorized := true
+	middleware := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !authorized {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	router := mux.NewRouter()
+	RegisterRoutes(router, v, middleware)
+
+	serve := func(body string) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, httptest.NewRequest(http.MethodPost, "/detokenize", strings.NewReader(body)))
+		return res
+	}
+
+	res := serve(`{"token":"token-1"}`)
+	require.Equal(t, http.StatusOK, res.Code)
+	assert.JSONEq(t, `{"token":"token-1","value":"user-1"}`, res.Body.String())
+
+	assert.Equal(t, http.StatusNotFound, serve(`{"token":"unknown"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, serve(`{}`).Code)
+	assert.Equal(t, http.StatusBadRequest, serve(`{`).Code)
+
+	authorized = false
+	assert.Equal(t, http.StatusForbidden, serve(`{"token":"token-1"}`).Code)
+}
@@ -0,0 +1,22 @@
+package vault
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// tokensStoredCounter counts the tokens written to the vault
+	tokensStoredCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "usage_telemetry_publisher",
+		Name:      "vault_tokens_stored_total",
+		Help:      "Number of tokens written to the tokenization vault",
+	})
+
+	// detokenizeRequestsCounter counts the detokenize requests by their result
+	detokenizeRequestsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "usage_telemetry_publisher",
+		Name:      "detokenize_requests_total",
+		Help:      "Number of detokenize requests, by result",
+	}, []string{"result"})
+)
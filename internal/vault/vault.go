@@ -0,0 +1,231 @@
+package vault
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ErrNotFound is returned by Lookup for a token the vault does not hold
+var ErrNotFound = errors.New("token not found")
+
+// record is a line of the vault file, the value is sealed with AES-GCM bound to its token
+type record struct {
+	Token string `json:"token"`
+	Value []byte `json:"value"`
+}
+
+// Vault keeps the values behind tokens in an append-only file, encrypting every value with AES-256-GCM.
+// Memory only holds an index of the records in the file by a hash of their token, values are read from the file
+// and decrypted by Lookup. The file has a single writer, so a vault only holds the tokens of the process writing it.
+type Vault struct {
+	aead cipher.AEAD
+
+	mu      sync.Mutex
+	file    *os.File
+	size    int64
+	entries map[tokenKey]entry
+
+	// syncMu serializes syncs, synced is the size of the file known to be on disk
+	syncMu sync.Mutex
+	synced int64
+}
+
+// tokenKey indexes a token by a hash, so the index does not grow with the length of tokens
+type tokenKey [16]byte
+
+// entry locates the record of a token in the vault file
+type entry struct {
+	offset int64
+	length int32
+}
+
+func keyOf(token string) tokenKey {
+	sum := sha256.Sum256([]byte(token))
+	return tokenKey(sum[:16])
+}
+
+// Open opens the vault file at path, creating it if it does not exist. The encryption key is derived from secret,
+// opening a vault with another secret succeeds but its values can no longer be looked up.
+func Open(path string, secret []byte) (*Vault, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("vault secret is required")
+	}
+	key := sha256.Sum256(secret)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to create vault cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create vault cipher: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create vault directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open vault file: %w", err)
+	}
+
+	v := &Vault{aead: aead, file: file, entries: map[tokenKey]entry{}}
+	if err := v.load(); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	v.synced = v.size
+	return v, nil
+}
+
+// Store keeps value behind token, tokens already in the vault are not written again.
+// It returns once the record of token is synced to disk, since a token whose value is lost cannot be reversed anymore.
+func (v *Vault) Store(token, value string) error {
+	end, err := v.append(token, value)
+	if err != nil {
+		return err
+	}
+	return v.syncTo(end)
+}
+
+// append writes the record of token unless the vault holds it, returning the end of the record in the file
+func (v *Vault) append(token, value string) (int64, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	key := keyOf(token)
+	if e, ok := v.entries[key]; ok {
+		return e.offset + int64(e.length), nil
+	}
+
+	nonce := make([]byte, v.aead.NonceSize())
+	_, _ = rand.Read(nonce) // never returns an error
+	sealed := v.aead.Seal(nonce, nonce, []byte(value), []byte(token))
+	line, err := json.Marshal(record{Token: token, Value: sealed})
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode vault record: %w", err)
+	}
+	line = append(line, '\n')
+	if _, err := v.file.Write(line); err != nil {
+		// cut the torn record off, a later record appended to it would make the file unreadable
+		if truncErr := v.file.Truncate(v.size); truncErr != nil {
+			err = errors.Join(err, truncErr)
+		}
+		return 0, fmt.Errorf("failed to write vault record: %w", err)
+	}
+
+	v.entries[key] = entry{offset: v.size, length: int32(len(line))}
+	v.size += int64(len(line))
+	tokensStoredCounter.Inc()
+	return v.size, nil
+}
+
+// syncTo syncs the vault file unless a sync of concurrent Stores already covered its first end bytes,
+// so concurrent Stores share a sync and none holds the vault lock while syncing
+func (v *Vault) syncTo(end int64) error {
+	v.syncMu.Lock()
+	defer v.syncMu.Unlock()
+	if v.synced >= end {
+		return nil
+	}
+
+	v.mu.Lock()
+	size := v.size
+	v.mu.Unlock()
+	if err := v.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync vault file: %w", err)
+	}
+	v.synced = size
+	return nil
+}
+
+// Lookup returns the value behind token
+func (v *Vault) Lookup(token string) (string, error) {
+	v.mu.Lock()
+	e, ok := v.entries[keyOf(token)]
+	v.mu.Unlock()
+	if !ok {
+		return "", ErrNotFound
+	}
+
+	line := make([]byte, e.length)
+	if _, err := v.file.ReadAt(line, e.offset); err != nil {
+		return "", fmt.Errorf("failed to read vault record: %w", err)
+	}
+	var r record
+	if err := json.Unmarshal(line, &r); err != nil {
+		return "", fmt.Errorf("failed to decode vault record: %w", err)
+	}
+	if r.Token != token {
+		// another token with the same hash
+		return "", ErrNotFound
+	}
+
+	sealed := r.Value
+	nonceSize := v.aead.NonceSize()
+	value, err := v.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], []byte(token))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt value of token: %w", err)
+	}
+	return string(value), nil
+}
+
+// Len returns the number of tokens in the vault
+func (v *Vault) Len() int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return len(v.entries)
+}
+
+// Close closes the vault file
+func (v *Vault) Close() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.file.Close()
+}
+
+// load reads the records of the vault file. A malformed last line is the remainder of an interrupted write,
+// it is cut off so the next record starts on a line of its own.
+func (v *Vault) load() error {
+	if _, err := v.file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to read vault file: %w", err)
+	}
+
+	reader := bufio.NewReader(v.file)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) && len(line) == 0 {
+			return nil
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("failed to read vault file: %w", err)
+		}
+
+		var r record
+		jsonErr := json.Unmarshal(line, &r)
+		if jsonErr != nil || r.Token == "" || len(r.Value) < v.aead.NonceSize() || !bytes.HasSuffix(line, []byte("\n")) {
+			if _, peekErr := reader.Peek(1); !errors.Is(peekErr, io.EOF) {
+				return fmt.Errorf("vault file is corrupt at offset %d", offset)
+			}
+			if err := v.file.Truncate(offset); err != nil {
+				return fmt.Errorf("failed to repair vault file: %w", err)
+			}
+			v.size = offset
+			return nil
+		}
+		v.entries[keyOf(r.Token)] = entry{offset: offset, length: int32(len(line))}
+		offset += int64(len(line))
+		v.size = offset
+	}
+}
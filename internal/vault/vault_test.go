@@ -0,0 +1,140 @@
+package vault
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVault(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vault", "tokens")
+	v, err := Open(path, []byte("secret"))
+	require.NoError(t, err)
+
+	require.NoError(t, v.Store("token-1", "user-1"))
+	require.NoError(t, v.Store("token-1", "user-1"))
+	require.NoError(t, v.Store("token-2", "user-2"))
+	assert.Equal(t, 2, v.Len())
+
+	value, err := v.Lookup("token-1")
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", value)
+	_, err = v.Lookup("unknown")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(content), "user-1", "values are encrypted")
+	require.NoError(t, v.Close())
+
+	t.Run("reopened vault holds the tokens", func(t *testing.T) {
+		reopened, err := Open(path, []byte("secret"))
+		require.NoError(t, err)
+		defer reopened.Close()
+		value, err := reopened.Lookup("token-2")
+		require.NoError(t, err)
+		assert.Equal(t, "user-2", value)
+	})
+
+	t.Run("values cannot be decrypted with another secret", func(t *testing.T) {
+		other, err := Open(path, []byte("other"))
+		require.NoError(t, err)
+		defer other.Close()
+		_, err = other.Lookup("token-1")
+		require.Error(t, err)
+		require.NotErrorIs(t, err, ErrNotFound)
+	})
+}
+
+func TestVault_Recovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens")
+	v, err := Open(path, []byte("secret"))
+	require.NoError(t, err)
+	require.NoError(t, v.Store("token-1", "user-1"))
+	require.NoError(t, v.Close())
+
+	t.Run("interrupted write is cut off", func(t *testing.T) {
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+		require.NoError(t, err)
+		_, err = file.WriteString(`{"token":"token-2","va`)
+		require.NoError(t, err)
+		require.NoError(t, file.Close())
+
+		v, err := Open(path, []byte("secret"))
+		require.NoError(t, err)
+		assert.Equal(t, 1, v.Len())
+		require.NoError(t, v.Store("token-3", "user-3"))
+		require.NoError(t, v.Close())
+
+		v, err = Open(path, []byte("secret"))
+		require.NoError(t, err)
+		defer v.Close()
+		assert.Equal(t, 2, v.Len())
+	})
+
+	t.Run("record without its newline is cut off", func(t *testing.T) {
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(path, content[:len(content)-1], 0o600))
+
+		v, err := Open(path, []byte("secret"))
+		require.NoError(t, err)
+		assert.Equal(t, 1, v.Len())
+		require.NoError(t, v.Store("token-4", "user-4"))
+		require.NoError(t, v.Close())
+
+		v, err = Open(path, []byte("secret"))
+		require.NoError(t, err)
+		defer v.Close()
+		value, err := v.Lookup("token-4")
+		require.NoError(t, err)
+		assert.Equal(t, "user-4", value)
+	})
+
+	t.Run("corrupt record fails", func(t *testing.T) {
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(path, append([]byte("garbage\n"), content...), 0o600))
+
+		_, err = Open(path, []byte("secret"))
+		require.Error(t, err)
+	})
+}
+
+func TestVault_ConcurrentStores(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens")
+	v, err := Open(path, []byte("secret"))
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, v.Store(fmt.Sprintf("token-%d", i%25), fmt.Sprintf("user-%d", i%25)))
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 25, v.Len())
+	assert.Equal(t, v.size, v.synced, "every stored record is synced")
+	require.NoError(t, v.Close())
+
+	reopened, err := Open(path, []byte("secret"))
+	require.NoError(t, err)
+	defer reopened.Close()
+	for i := range 25 {
+		value, err := reopened.Lookup(fmt.Sprintf("token-%d", i))
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("user-%d", i), value)
+	}
+}
+
+func TestOpen_RequiresSecret(t *testing.T) {
+	_, err := Open(filepath.Join(t.TempDir(), "tokens"), nil)
+	require.Error(t, err)
+}
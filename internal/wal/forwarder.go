@@ -0,0 +1,152 @@
+package wal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/qlik-trial/go-service-kit/v29/operation"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/deadletter"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/formatter"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/model"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/sink"
+)
+
+const (
+	// idleInterval is how often an idle forwarder polls the log in case a notification was missed
+	idleInterval = 5 * time.Second
+)
+
+// ForwarderOptions configures a Forwarder
+type ForwarderOptions struct {
+	// BatchSize is the maximum number of events written to the sink at once
+	BatchSize int
+	// InitialBackoff is the wait before retrying a failed write, it doubles with every failure
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between retries
+	MaxBackoff time.Duration
+	// DeadLetters receives the events the sink permanently rejected, they are only dropped when nil
+	DeadLetters *deadletter.Queue
+}
+
+// Forwarder drains a Log to a sink, committing the log once the sink accepted and flushed a batch.
+// Batches failing with a retryable error are retried until they succeed, so a sink outage only grows the log.
+type Forwarder struct {
+	log    *Log
+	output sink.Sink
+	opts   ForwarderOptions
+}
+
+// NewForwarder creates a Forwarder draining log to output
+func NewForwarder(log *Log, output sink.Sink, opts ForwarderOptions) *Forwarder {
+	opts.BatchSize = max(opts.BatchSize, 1)
+	return &Forwarder{log: log, output: output, opts: opts}
+}
+
+// Start forwards events until ctx is done
+func (f *Forwarder) Start(ctx context.Context) error {
+	label := "wal/Forwarder/Start"
+	pos := f.log.Committed()
+	failures := 0
+
+	for {
+		events, next, err := f.log.Read(pos, f.opts.BatchSize)
+		if err == nil && len(events) > 0 {
+			err = f.output.Write(ctx, events)
+			var rejected *sink.RejectedError
+			if errors.As(err, &rejected) {
+				// the other events of the batch were accepted
+				operation.Logger(ctx).Error("label", label, "message", "sink could not encode events, dead-lettering them", "error", err, "events", len(rejected.Rejected))
+				droppedEventsCounter.Add(float64(len(rejected.Rejected)))
+				forwardedEventsCounter.Add(float64(len(events) - len(rejected.Rejected)))
+				f.deadLetterRejected(ctx, rejected.Rejected)
+				err = nil
+			} else if errors.Is(err, sink.ErrPermanent) {
+				operation.Logger(ctx).Error("label", label, "message", "sink permanently rejected events, dead-lettering them", "error", err, "events", len(events))
+				droppedEventsCounter.Add(float64(len(events)))
+				f.deadLetter(ctx, events, err)
+				err = nil
+			} else if err == nil {
+				forwardedEventsCounter.Add(float64(len(events)))
+			}
+		}
+		if err == nil && len(events) > 0 {
+			// the events must be durable at the sink before the log forgets them
+			if err = f.output.Flush(ctx); err != nil {
+				err = fmt.Errorf("failed to flush sink: %w", err)
+			}
+		}
+		if err == nil && next != pos {
+			err = f.log.Commit(next)
+		}
+
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			failures++
+			operation.Logger(ctx).Warn("label", label, "message", "failed to forward events, retrying", "error", err, "failures", failures)
+			if !wait(ctx, f.backoff(failures), nil) {
+				return nil
+			}
+			continue
+		}
+		failures = 0
+
+		if next == pos {
+			if !wait(ctx, idleInterval, f.log.Notify()) {
+				return nil
+			}
+			continue
+		}
+		pos = next
+	}
+}
+
+// deadLetter hands events to the dead letter queue, failures are logged since the events cannot be retried anyway
+func (f *Forwarder) deadLetter(ctx context.Context, events []*model.ScrubbedEvent, cause error) {
+	label := "wal/Forwarder/deadLetter"
+	if f.opts.DeadLetters == nil {
+		return
+	}
+	for _, event := range events {
+		if err := f.opts.DeadLetters.Reject(ctx, deadletter.ReasonSinkPermanentFailure, cause, event); err != nil {
+			operation.Logger(ctx).Error("label", label, "message", "failed to dead-letter event", "error", err, "eventId", event.Id)
+		}
+	}
+}
+
+// deadLetterRejected hands the events the sink could not encode to the dead letter queue
+func (f *Forwarder) deadLetterRejected(ctx context.Context, rejected []*formatter.RecordError) {
+	label := "wal/Forwarder/deadLetterRejected"
+	if f.opts.DeadLetters == nil {
+		return
+	}
+	for _, recordErr := range rejected {
+		if err := f.opts.DeadLetters.Reject(ctx, deadletter.ReasonEncodingError, recordErr.Err, recordErr.Event); err != nil {
+			operation.Logger(ctx).Error("label", label, "message", "failed to dead-letter event", "error", err, "eventId", recordErr.Event.Id)
+		}
+	}
+}
+
+func (f *Forwarder) backoff(failures int) time.Duration {
+	backoff := f.opts.InitialBackoff << (failures - 1)
+	if backoff <= 0 || backoff > f.opts.MaxBackoff {
+		return f.opts.MaxBackoff
+	}
+	return backoff
+}
+
+// wait blocks for d or until notify receives, returning false if ctx is done first
+func wait(ctx context.Context, d time.Duration, notify <-chan struct{}) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+	case <-notify:
+	}
+	return true
+}
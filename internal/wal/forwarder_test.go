@@ -0,0 +1,213 @@
+package wal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/deadletter"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/formatter"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/model"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/sink"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type flakySink struct {
+	mu       sync.Mutex
+	events   []string
+	failures int
+	err      error
+}
+
+func (s *flakySink) Write(_ context.Context, events []*model.ScrubbedEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		return s.err
+	}
+	s.events = append(s.events, ids(events)...)
+	return nil
+}
+
+func (s *flakySink) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.events...)
+}
+
+func (s *flakySink) Flush(_ context.Context) error { return nil }
+
+func (s *flakySink) Close() error { return nil }
+
+func (s *flakySink) Healthy() error { return nil }
+
+func startForwarder(t *testing.T, f *Forwarder) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- f.Start(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		require.NoError(t, <-done)
+	})
+}
+
+func TestForwarder_DrainsLog(t *testing.T) {
+	l := openLog(t, t.TempDir(), Options{SegmentMaxBytes: 300})
+	output := &flakySink{failures: 2, err: errors.New("sink unavailable")}
+	startForwarder(t, NewForwarder(l, output, ForwarderOptions{BatchSize: 2, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}))
+
+	var expected []string
+	for i := range 5 {
+		id := fmt.Sprint(i)
+		expected = append(expected, id)
+		require.NoError(t, l.Write(context.Background(), testEvents(id)))
+	}
+
+	require.Eventually(t, func() bool {
+		return len(output.received()) == len(expected)
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, expected, output.received())
+	require.Eventually(t, func() bool {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return len(l.segments) == 1
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestForwarder_DropsPermanentlyRejectedEvents(t *testing.T) {
+	l := openLog(t, t.TempDir(), Options{})
+	output := &flakySink{failures: 1, err: fmt.Errorf("%w: bad request", sink.ErrPermanent)}
+	startForwarder(t, NewForwarder(l, output, ForwarderOptions{BatchSize: 1, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}))
+
+	require.NoError(t, l.Write(context.Background(), testEvents("1")))
+	require.NoError(t, l.Write(context.Background(), testEvents("2")))
+
+	require.Eventually(t, func() bool {
+		return len(output.received()) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"2"}, output.received())
+}
+
+func TestForwarder_DeadLettersPermanentlyRejectedEvents(t *testing.T) {
+	l := openLog(t, t.TempDir(), Options{})
+	store, err := deadletter.NewFileStore(t.TempDir())
+	require.NoError(t, err)
+	output := &flakySink{failures: 1, err: fmt.Errorf("%w: bad request", sink.ErrPermanent)}
+	startForwarder(t, NewForwarder(l, output, ForwarderOptions{
+		BatchSize:      1,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+		DeadLetters:    deadletter.NewQueue(store, output),
+	}))
+
+	require.NoError(t, l.Write(context.Background(), testEvents("1")))
+
+	var letters []*deadletter.Letter
+	require.Eventually(t, func() bool {
+		letters, err = store.List(context.Background(), 0)
+		return err == nil && len(letters) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, deadletter.ReasonSinkPermanentFailure, letters[0].Reason)
+	assert.Equal(t, "1", letters[0].Event.Id)
+	assert.Contains(t, letters[0].Detail, "bad request")
+}
+
+// rejectingSink accepts all events but the one with id reject, which it cannot encode
+type rejectingSink struct {
+	flakySink
+	reject string
+}
+
+func (s *rejectingSink) Write(ctx context.Context, events []*model.ScrubbedEvent) error {
+	var accepted []*model.ScrubbedEvent
+	var rejected []*formatter.RecordError
+	for _, event := range events {
+		if event.Id == s.reject {
+			rejected = append(rejected, &formatter.RecordError{Event: event, Err: errors.New("unsupported value NaN")})
+			continue
+		}
+		accepted = append(accepted, event)
+	}
+	if err := s.flakySink.Write(ctx, accepted); err != nil {
+		return err
+	}
+	return &sink.RejectedError{Rejected: rejected}
+}
+
+func TestForwarder_DeadLettersRejectedEvents(t *testing.T) {
+	l := openLog(t, t.TempDir(), Options{})
+	store, err := deadletter.NewFileStore(t.TempDir())
+	require.NoError(t, err)
+	output := &rejectingSink{reject: "2"}
+	startForwarder(t, NewForwarder(l, output, ForwarderOptions{
+		BatchSize:      3,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+		DeadLetters:    deadletter.NewQueue(store, output),
+	}))
+
+	require.NoError(t, l.Write(context.Background(), testEvents("1", "2", "3")))
+
+	var letters []*deadletter.Letter
+	require.Eventually(t, func() bool {
+		letters, err = store.List(context.Background(), 0)
+		return err == nil && len(letters) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, deadletter.ReasonEncodingError, letters[0].Reason)
+	assert.Equal(t, "2", letters[0].Event.Id)
+	assert.Contains(t, letters[0].Detail, "NaN")
+	assert.Equal(t, []string{"1", "3"}, output.received(), "only the rejected event is dead-lettered")
+}
+
+// flushingSink records the committed position of the log at every flush, failing the first flushes
+type flushingSink struct {
+	flakySink
+	log           *Log
+	flushFailures int
+	committed     []Position
+}
+
+func (s *flushingSink) Flush(_ context.Context) error {
+	committed := s.log.Committed()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.committed = append(s.committed, committed)
+	if s.flushFailures > 0 {
+		s.flushFailures--
+		return errors.New("disk unavailable")
+	}
+	return nil
+}
+
+func (s *flushingSink) flushes() []Position {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Position(nil), s.committed...)
+}
+
+func TestForwarder_FlushesBeforeCommit(t *testing.T) {
+	l := openLog(t, t.TempDir(), Options{})
+	require.NoError(t, l.Write(context.Background(), testEvents("1")))
+	require.NoError(t, l.Write(context.Background(), testEvents("2")))
+	start := l.Committed()
+	_, first, err := l.Read(start, 1)
+	require.NoError(t, err)
+	_, second, err := l.Read(first, 1)
+	require.NoError(t, err)
+
+	output := &flushingSink{log: l, flushFailures: 1}
+	startForwarder(t, NewForwarder(l, output, ForwarderOptions{BatchSize: 1, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}))
+
+	require.Eventually(t, func() bool {
+		return l.Committed() == second
+	}, 2*time.Second, 10*time.Millisecond)
+	// the batch of the failed flush is not committed, but written and flushed again
+	assert.Equal(t, []Position{start, start, first}, output.flushes())
+	assert.Equal(t, []string{"1", "1", "2"}, output.received())
+}
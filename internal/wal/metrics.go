@@ -0,0 +1,36 @@
+package wal
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// sizeGauge exposes the size of the segments on disk
+	sizeGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "usage_telemetry_publisher",
+		Name:      "intermediate_storage_size_bytes",
+		Help:      "Size of the intermediate storage segments on disk",
+	})
+
+	// forwardedEventsCounter counts events forwarded from intermediate storage to the sink
+	forwardedEventsCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "usage_telemetry_publisher",
+		Name:      "intermediate_storage_forwarded_events_total",
+		Help:      "Number of events forwarded from intermediate storage to the sink",
+	})
+
+	// droppedEventsCounter counts events the sink permanently rejected while forwarding
+	droppedEventsCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "usage_telemetry_publisher",
+		Name:      "intermediate_storage_dropped_events_total",
+		Help:      "Number of events dropped from intermediate storage because the sink permanently rejected them",
+	})
+
+	// corruptRecordsCounter counts torn or corrupt records found in the segments
+	corruptRecordsCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "usage_telemetry_publisher",
+		Name:      "intermediate_storage_corrupt_records_total",
+		Help:      "Number of torn or corrupt records skipped in intermediate storage",
+	})
+)
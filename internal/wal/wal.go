@@ -0,0 +1,556 @@
+package wal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qlik-trial/go-service-kit/v29/operation"
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/model"
+)
+
+const (
+	// SyncAlways fsyncs the active segment on every append
+	SyncAlways = "always"
+	// SyncInterval fsyncs the active segment periodically
+	SyncInterval = "interval"
+	// SyncNever leaves flushing the active segment to the operating system
+	SyncNever = "never"
+
+	segmentExtension = ".wal"
+	checkpointFile   = "checkpoint"
+	// recordHeaderSize is the size of the length and checksum preceding every record
+	recordHeaderSize = 8
+)
+
+var (
+	// ErrFull is returned by Write when the log has reached its maximum size
+	ErrFull = errors.New("intermediate storage is full")
+	// errClosed is returned by Write once the log is closed or its active segment could not be created
+	errClosed = errors.New("intermediate storage is closed")
+
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// Options configures a Log
+type Options struct {
+	// Directory segments and the checkpoint are stored in
+	Directory string
+	// SegmentMaxBytes seals the active segment and starts a new one once it reaches this size
+	SegmentMaxBytes int64
+	// MaxBytes rejects appends once the segments on disk reach this size, 0 disables the limit
+	MaxBytes int64
+	// SyncPolicy is one of SyncAlways, SyncInterval or SyncNever
+	SyncPolicy string
+	// SyncInterval is the fsync interval of the SyncInterval policy
+	SyncInterval time.Duration
+}
+
+// Position addresses a record in the log
+type Position struct {
+	Segment uint64 `json:"segment"`
+	Offset  int64  `json:"offset"`
+}
+
+// Log is a segmented write-ahead log of scrubbed events on local disk.
+// Every record is stored with its length and a CRC-32C checksum. On Open a torn write at the end of the
+// last segment is truncated, and Read skips the rest of a segment holding a corrupt record.
+// Log implements sink.Sink so it can stand in for the sink in the event handler, while a Forwarder drains it.
+type Log struct {
+	opts Options
+
+	mu         sync.Mutex
+	segments   []uint64
+	active     *os.File
+	activeSize int64
+	totalSize  int64
+	committed  Position
+	dirty      bool
+	err        error
+
+	notify  chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+	closing sync.Once
+}
+
+// Open opens the log in opts.Directory, recovering the segments and checkpoint of a previous run
+func Open(opts Options) (*Log, error) {
+	switch opts.SyncPolicy {
+	case SyncAlways, SyncInterval, SyncNever:
+	default:
+		return nil, fmt.Errorf("unknown sync policy %q", opts.SyncPolicy)
+	}
+	if err := os.MkdirAll(opts.Directory, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create intermediate storage directory: %w", err)
+	}
+
+	l := &Log{
+		opts:   opts,
+		notify: make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if err := l.recover(); err != nil {
+		return nil, err
+	}
+
+	go l.syncPeriodically()
+	return l, nil
+}
+
+// Write appends the events to the log, satisfying sink.Sink.
+// The events are durable according to the sync policy once it returns.
+func (l *Log) Write(_ context.Context, events []*model.ScrubbedEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	for _, event := range events {
+		if err := encodeRecord(&buf, event); err != nil {
+			return err
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.active == nil {
+		return errClosed
+	}
+	if l.opts.MaxBytes > 0 && l.totalSize+int64(buf.Len()) > l.opts.MaxBytes {
+		return ErrFull
+	}
+	if l.opts.SegmentMaxBytes > 0 && l.activeSize > 0 && l.activeSize+int64(buf.Len()) > l.opts.SegmentMaxBytes {
+		if err := l.roll(); err != nil {
+			l.err = err
+			return err
+		}
+	}
+
+	n, err := l.active.Write(buf.Bytes())
+	if err != nil {
+		// later appends would follow the torn records and be dropped with them when the segment is recovered
+		l.err = errors.Join(fmt.Errorf("failed to append to intermediate storage: %w", err), l.truncate())
+		return l.err
+	}
+	l.activeSize += int64(n)
+	l.totalSize += int64(n)
+	if l.opts.SyncPolicy == SyncAlways {
+		if err := l.active.Sync(); err != nil {
+			l.err = fmt.Errorf("failed to sync intermediate storage: %w", err)
+			return l.err
+		}
+	} else {
+		l.dirty = true
+	}
+	l.err = nil
+	sizeGauge.Set(float64(l.totalSize))
+
+	select {
+	case l.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Flush fsyncs the active segment
+func (l *Log) Flush(_ context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.sync()
+}
+
+// Close fsyncs and closes the active segment
+func (l *Log) Close() error {
+	l.closing.Do(func() { close(l.stop) })
+	<-l.done
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.active == nil {
+		return nil
+	}
+	err := errors.Join(l.sync(), l.active.Close())
+	l.active = nil
+	return err
+}
+
+// Healthy returns an error if the log is full or the last append failed
+func (l *Log) Healthy() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err != nil {
+		return l.err
+	}
+	if l.opts.MaxBytes > 0 && l.totalSize >= l.opts.MaxBytes {
+		return ErrFull
+	}
+	return nil
+}
+
+// Notify returns a channel that receives a value after events have been appended
+func (l *Log) Notify() <-chan struct{} {
+	return l.notify
+}
+
+// Committed returns the position up to which events have been forwarded
+func (l *Log) Committed() Position {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.committed
+}
+
+// Commit records that every event before pos has been forwarded and removes segments that are no longer needed
+func (l *Log) Commit(pos Position) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := writeCheckpoint(l.opts.Directory, pos); err != nil {
+		return err
+	}
+	l.committed = pos
+
+	for len(l.segments) > 1 && l.segments[0] < pos.Segment {
+		path := l.segmentPath(l.segments[0])
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("failed to stat forwarded segment: %w", err)
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove forwarded segment: %w", err)
+		}
+		l.totalSize -= info.Size()
+		l.segments = l.segments[1:]
+	}
+	sizeGauge.Set(float64(l.totalSize))
+	return nil
+}
+
+// Read returns up to maxRecords events stored at or after from, together with the position following them.
+// Reads do not cross segments, a returned position in a later segment means the previous one is exhausted.
+func (l *Log) Read(from Position, maxRecords int) ([]*model.ScrubbedEvent, Position, error) {
+	l.mu.Lock()
+	segments := slices.Clone(l.segments)
+	activeSize := l.activeSize
+	l.mu.Unlock()
+
+	idx, found := slices.BinarySearch(segments, from.Segment)
+	if !found {
+		if idx >= len(segments) {
+			return nil, from, fmt.Errorf("position %v is beyond the end of intermediate storage", from)
+		}
+		// the segment was removed, continue with the next one
+		from = Position{Segment: segments[idx]}
+	}
+	last := idx == len(segments)-1
+
+	f, err := os.Open(l.segmentPath(from.Segment))
+	if err != nil {
+		return nil, from, fmt.Errorf("failed to open segment: %w", err)
+	}
+	defer f.Close() //revive:disable:unhandled-error
+
+	end := activeSize
+	if !last {
+		info, err := f.Stat()
+		if err != nil {
+			return nil, from, fmt.Errorf("failed to stat segment: %w", err)
+		}
+		end = info.Size()
+	}
+	if _, err := f.Seek(from.Offset, io.SeekStart); err != nil {
+		return nil, from, fmt.Errorf("failed to seek segment: %w", err)
+	}
+
+	r := bufio.NewReader(io.LimitReader(f, end-from.Offset))
+	pos := from
+	var events []*model.ScrubbedEvent
+	for len(events) < maxRecords && pos.Offset < end {
+		event, n, err := decodeRecord(r, end-pos.Offset)
+		if err != nil {
+			if last {
+				return events, pos, fmt.Errorf("failed to read active segment: %w", err)
+			}
+			corruptRecordsCounter.Inc()
+			return events, Position{Segment: segments[idx+1]}, nil
+		}
+		events = append(events, event)
+		pos.Offset += n
+	}
+
+	if pos.Offset >= end && !last {
+		pos = Position{Segment: segments[idx+1]}
+	}
+	return events, pos, nil
+}
+
+// recover loads the checkpoint and segments, truncating a torn write at the end of the last segment
+func (l *Log) recover() error {
+	entries, err := os.ReadDir(l.opts.Directory)
+	if err != nil {
+		return fmt.Errorf("failed to read intermediate storage directory: %w", err)
+	}
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), segmentExtension)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		segment, err := strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return fmt.Errorf("failed to stat segment: %w", err)
+		}
+		l.segments = append(l.segments, segment)
+		l.totalSize += info.Size()
+	}
+	slices.Sort(l.segments)
+
+	if l.committed, err = readCheckpoint(l.opts.Directory); err != nil {
+		return err
+	}
+
+	if len(l.segments) == 0 {
+		l.segments = []uint64{max(l.committed.Segment, 1)}
+		if l.committed.Segment != l.segments[0] {
+			l.committed = Position{Segment: l.segments[0]}
+		}
+	}
+	if l.committed.Segment < l.segments[0] {
+		l.committed = Position{Segment: l.segments[0]}
+	}
+
+	last := l.segments[len(l.segments)-1]
+	f, err := os.OpenFile(l.segmentPath(last), os.O_CREATE|os.O_RDWR, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to open active segment: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return errors.Join(fmt.Errorf("failed to stat active segment: %w", err), f.Close())
+	}
+	size, err := validLength(f, info.Size())
+	if err != nil {
+		return errors.Join(err, f.Close())
+	}
+	if size < info.Size() {
+		corruptRecordsCounter.Inc()
+		if err := f.Truncate(size); err != nil {
+			return errors.Join(fmt.Errorf("failed to truncate active segment: %w", err), f.Close())
+		}
+		l.totalSize -= info.Size() - size
+	}
+	if _, err := f.Seek(size, io.SeekStart); err != nil {
+		return errors.Join(fmt.Errorf("failed to seek active segment: %w", err), f.Close())
+	}
+	if l.committed.Segment == last && l.committed.Offset > size {
+		l.committed.Offset = size
+	}
+
+	l.active = f
+	l.activeSize = size
+	sizeGauge.Set(float64(l.totalSize))
+	return nil
+}
+
+// roll seals the active segment and starts a new one.
+// The active segment stays open until the new one is created, so a failed roll is retried by the next Write.
+func (l *Log) roll() error {
+	if err := l.sync(); err != nil {
+		return err
+	}
+
+	next := l.segments[len(l.segments)-1] + 1
+	f, err := os.OpenFile(l.segmentPath(next), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to create segment: %w", err)
+	}
+	sealed := l.active
+	l.active = f
+	l.activeSize = 0
+	l.segments = append(l.segments, next)
+	if err := sealed.Close(); err != nil {
+		return fmt.Errorf("failed to close segment: %w", err)
+	}
+	return syncDir(l.opts.Directory)
+}
+
+// truncate cuts the active segment back to its last complete record
+func (l *Log) truncate() error {
+	if err := l.active.Truncate(l.activeSize); err != nil {
+		return fmt.Errorf("failed to truncate active segment: %w", err)
+	}
+	if _, err := l.active.Seek(l.activeSize, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek active segment: %w", err)
+	}
+	return nil
+}
+
+func (l *Log) sync() error {
+	if !l.dirty || l.active == nil {
+		return nil
+	}
+	if err := l.active.Sync(); err != nil {
+		return fmt.Errorf("failed to sync intermediate storage: %w", err)
+	}
+	l.dirty = false
+	return nil
+}
+
+func (l *Log) syncPeriodically() {
+	defer close(l.done)
+	if l.opts.SyncPolicy != SyncInterval || l.opts.SyncInterval <= 0 {
+		<-l.stop
+		return
+	}
+
+	ticker := time.NewTicker(l.opts.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			l.mu.Lock()
+			if err := l.sync(); err != nil {
+				l.err = err
+			}
+			l.mu.Unlock()
+		}
+	}
+}
+
+func (l *Log) segmentPath(segment uint64) string {
+	return filepath.Join(l.opts.Directory, fmt.Sprintf("%020d%s", segment, segmentExtension))
+}
+
+// encodeRecord writes event as a length and checksum prefixed JSON record
+func encodeRecord(w io.Writer, event *model.ScrubbedEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event %s: %w", event.Id, err)
+	}
+
+	var header [recordHeaderSize]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(header[4:], crc32.Checksum(payload, crcTable))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err = w.Write(payload)
+	return err
+}
+
+// decodeRecord reads a record written by encodeRecord from the remaining bytes of a segment,
+// returning the event and the size of the record
+func decodeRecord(r io.Reader, remaining int64) (*model.ScrubbedEvent, int64, error) {
+	var header [recordHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, 0, fmt.Errorf("failed to read record header: %w", err)
+	}
+	length := binary.BigEndian.Uint32(header[:4])
+	if int64(length) > remaining-recordHeaderSize {
+		// a corrupt header must not allocate more than the segment can hold
+		return nil, 0, fmt.Errorf("record length %d exceeds the remaining %d bytes of the segment", length, remaining)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, 0, fmt.Errorf("failed to read record: %w", err)
+	}
+	if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(header[4:]) {
+		return nil, 0, errors.New("record checksum mismatch")
+	}
+
+	var event model.ScrubbedEvent
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	if err := decoder.Decode(&event); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode record: %w", err)
+	}
+	return &event, int64(recordHeaderSize) + int64(length), nil
+}
+
+// validLength returns the length of the prefix of f holding complete records with valid checksums
+func validLength(f *os.File, fileSize int64) (int64, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("failed to seek active segment: %w", err)
+	}
+	r := bufio.NewReader(f)
+	var size int64
+	for {
+		_, n, err := decodeRecord(r, fileSize-size)
+		if err != nil {
+			return size, nil
+		}
+		size += n
+	}
+}
+
+// readCheckpoint returns the committed position, a missing or unparsable checkpoint replays from the oldest segment
+func readCheckpoint(dir string) (Position, error) {
+	var pos Position
+	data, err := os.ReadFile(filepath.Join(dir, checkpointFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return pos, nil
+	}
+	if err != nil {
+		return pos, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+	if err := json.Unmarshal(data, &pos); err != nil {
+		// replayed events are dropped by dedup, refusing to start would need the file to be removed by hand
+		operation.Logger(context.Background()).Warn("label", "wal/readCheckpoint", "message", "ignoring malformed checkpoint, replaying from the oldest segment", "error", err)
+		return Position{}, nil
+	}
+	return pos, nil
+}
+
+// writeCheckpoint atomically replaces the checkpoint with pos
+func writeCheckpoint(dir string, pos Position) error {
+	data, err := json.Marshal(pos)
+	if err != nil {
+		return err
+	}
+	tempPath := filepath.Join(dir, "."+checkpointFile+".tmp")
+	f, err := os.OpenFile(tempPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	_, writeErr := f.Write(data)
+	syncErr := f.Sync()
+	if err := errors.Join(writeErr, syncErr, f.Close()); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	if err := os.Rename(tempPath, filepath.Join(dir, checkpointFile)); err != nil {
+		return fmt.Errorf("failed to replace checkpoint: %w", err)
+	}
+	if err := syncDir(dir); err != nil {
+		return fmt.Errorf("failed to sync checkpoint directory: %w", err)
+	}
+	return nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close() //revive:disable:unhandled-error
+	return d.Sync()
+}
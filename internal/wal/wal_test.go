@@ -0,0 +1,239 @@
+package wal
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/qlik-trial/usage-telemetry-publisher/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testEvents(ids ...string) []*model.ScrubbedEvent {
+	events := make([]*model.ScrubbedEvent, 0, len(ids))
+	for _, id := range ids {
+		events = append(events, &model.ScrubbedEvent{
+			Id:       id,
+			Type:     "com.qlik.v1.some_event",
+			Time:     "2023-10-01T12:00:00Z",
+			TenantId: "tenant_123",
+			Data:     map[string]any{"foo": "bar", "count": 3},
+		})
+	}
+	return events
+}
+
+func ids(events []*model.ScrubbedEvent) []string {
+	var result []string
+	for _, event := range events {
+		result = append(result, event.Id)
+	}
+	return result
+}
+
+func openLog(t *testing.T, dir string, opts Options) *Log {
+	t.Helper()
+	opts.Directory = dir
+	if opts.SyncPolicy == "" {
+		opts.SyncPolicy = SyncAlways
+	}
+	l, err := Open(opts)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+	return l
+}
+
+// readAll reads every event after from, following segments
+func readAll(t *testing.T, l *Log, from Position) ([]string, Position) {
+	t.Helper()
+	var result []string
+	for {
+		events, next, err := l.Read(from, 2)
+		require.NoError(t, err)
+		result = append(result, ids(events)...)
+		if next == from {
+			return result, next
+		}
+		from = next
+	}
+}
+
+func TestLog_WriteReadCommit(t *testing.T) {
+	dir := t.TempDir()
+	l := openLog(t, dir, Options{SegmentMaxBytes: 300})
+
+	for i := range 5 {
+		require.NoError(t, l.Write(context.Background(), testEvents(fmt.Sprint(i))))
+	}
+	require.Greater(t, len(l.segments), 1, "writes must roll into new segments")
+
+	events, pos := readAll(t, l, l.Committed())
+	assert.Equal(t, []string{"0", "1", "2", "3", "4"}, events)
+
+	first, _, err := l.Read(l.Committed(), 1)
+	require.NoError(t, err)
+	assert.Equal(t, "bar", first[0].Data["foo"])
+	assert.Equal(t, "3", fmt.Sprint(first[0].Data["count"]))
+
+	segments := len(l.segments)
+	require.NoError(t, l.Commit(pos))
+	assert.Len(t, l.segments, 1, "forwarded segments must be removed")
+	assert.Less(t, len(l.segments), segments)
+
+	require.NoError(t, l.Write(context.Background(), testEvents("5")))
+	events, _ = readAll(t, l, l.Committed())
+	assert.Equal(t, []string{"5"}, events)
+}
+
+func TestLog_RecoversAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	l := openLog(t, dir, Options{})
+	require.NoError(t, l.Write(context.Background(), testEvents("1", "2", "3")))
+
+	events, pos, err := l.Read(l.Committed(), 1)
+	require.NoError(t, err)
+	require.Equal(t, []string{"1"}, ids(events))
+	require.NoError(t, l.Commit(pos))
+	require.NoError(t, l.Close())
+
+	reopened := openLog(t, dir, Options{})
+	assert.Equal(t, pos, reopened.Committed())
+	remaining, _ := readAll(t, reopened, reopened.Committed())
+	assert.Equal(t, []string{"2", "3"}, remaining)
+}
+
+func TestLog_ReplaysAfterMalformedCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	l := openLog(t, dir, Options{})
+	require.NoError(t, l.Write(context.Background(), testEvents("1", "2")))
+	events, pos, err := l.Read(l.Committed(), 1)
+	require.NoError(t, err)
+	require.Equal(t, []string{"1"}, ids(events))
+	require.NoError(t, l.Commit(pos))
+	require.NoError(t, l.Close())
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, checkpointFile), []byte(`{"Segment":`), 0o600))
+	reopened := openLog(t, dir, Options{})
+	remaining, _ := readAll(t, reopened, reopened.Committed())
+	assert.Equal(t, []string{"1", "2"}, remaining, "a torn checkpoint replays from the oldest segment")
+}
+
+func TestLog_TruncatesTornWrite(t *testing.T) {
+	dir := t.TempDir()
+	l := openLog(t, dir, Options{})
+	require.NoError(t, l.Write(context.Background(), testEvents("1", "2")))
+	require.NoError(t, l.Close())
+
+	segmentPath := l.segmentPath(l.segments[0])
+	info, err := os.Stat(segmentPath)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(segmentPath, info.Size()-3))
+
+	reopened := openLog(t, dir, Options{})
+	events, _ := readAll(t, reopened, reopened.Committed())
+	assert.Equal(t, []string{"1"}, events)
+
+	require.NoError(t, reopened.Write(context.Background(), testEvents("3")))
+	events, _ = readAll(t, reopened, reopened.Committed())
+	assert.Equal(t, []string{"1", "3"}, events)
+}
+
+func TestLog_TruncatesFailedAppend(t *testing.T) {
+	dir := t.TempDir()
+	l := openLog(t, dir, Options{})
+	require.NoError(t, l.Write(context.Background(), testEvents("1")))
+
+	// the torn bytes of an append that failed halfway
+	_, err := l.active.Write([]byte{0, 0, 0, 42, 1, 2})
+	require.NoError(t, err)
+	require.NoError(t, l.truncate())
+	require.NoError(t, l.Write(context.Background(), testEvents("2")))
+	require.NoError(t, l.Close())
+
+	reopened := openLog(t, dir, Options{})
+	events, _ := readAll(t, reopened, reopened.Committed())
+	assert.Equal(t, []string{"1", "2"}, events)
+}
+
+func TestLog_RetriesFailedRoll(t *testing.T) {
+	l := openLog(t, t.TempDir(), Options{SegmentMaxBytes: 1})
+	require.NoError(t, l.Write(context.Background(), testEvents("1")))
+
+	// a directory in place of the next segment makes creating it fail
+	blocked := l.segmentPath(l.segments[0] + 1)
+	require.NoError(t, os.Mkdir(blocked, 0o750))
+	require.Error(t, l.Write(context.Background(), testEvents("2")))
+	require.Error(t, l.Healthy())
+
+	require.NoError(t, os.Remove(blocked))
+	require.NoError(t, l.Write(context.Background(), testEvents("2")))
+	require.NoError(t, l.Healthy())
+	events, _ := readAll(t, l, l.Committed())
+	assert.Equal(t, []string{"1", "2"}, events)
+}
+
+func TestDecodeRecord_RejectsOversizedLength(t *testing.T) {
+	var record bytes.Buffer
+	require.NoError(t, encodeRecord(&record, testEvents("1")[0]))
+	size := int64(record.Len())
+
+	_, n, err := decodeRecord(bytes.NewReader(record.Bytes()), size)
+	require.NoError(t, err)
+	assert.Equal(t, size, n)
+	_, _, err = decodeRecord(bytes.NewReader(record.Bytes()), size-1)
+	require.ErrorContains(t, err, "exceeds the remaining", "the record would extend past the end of the segment")
+
+	corrupt := bytes.Clone(record.Bytes())
+	corrupt[0] = 0xff
+	_, _, err = decodeRecord(bytes.NewReader(corrupt), size)
+	require.ErrorContains(t, err, "exceeds the remaining")
+}
+
+func TestLog_SkipsCorruptSegment(t *testing.T) {
+	dir := t.TempDir()
+	l := openLog(t, dir, Options{SegmentMaxBytes: 1})
+	require.NoError(t, l.Write(context.Background(), testEvents("1", "2")))
+	require.NoError(t, l.Write(context.Background(), testEvents("3")))
+	require.Len(t, l.segments, 2)
+
+	// flip a byte in the payload of the last record of the sealed segment
+	segmentPath := l.segmentPath(l.segments[0])
+	data, err := os.ReadFile(segmentPath)
+	require.NoError(t, err)
+	data[len(data)-2] ^= 0xff
+	require.NoError(t, os.WriteFile(segmentPath, data, 0o600))
+
+	events, _ := readAll(t, l, l.Committed())
+	assert.Equal(t, []string{"1", "3"}, events)
+}
+
+func TestLog_Full(t *testing.T) {
+	var record bytes.Buffer
+	require.NoError(t, encodeRecord(&record, testEvents("1")[0]))
+	l := openLog(t, t.TempDir(), Options{MaxBytes: int64(record.Len() * 3 / 2)})
+	require.NoError(t, l.Write(context.Background(), testEvents("1")))
+	require.ErrorIs(t, l.Write(context.Background(), testEvents("2")), ErrFull)
+	require.NoError(t, l.Healthy())
+
+	_, pos := readAll(t, l, l.Committed())
+	require.NoError(t, l.Commit(pos))
+	require.ErrorIs(t, l.Write(context.Background(), testEvents("2")), ErrFull, "the active segment is only reclaimed once sealed")
+}
+
+func TestOpen_InvalidSyncPolicy(t *testing.T) {
+	_, err := Open(Options{Directory: t.TempDir(), SyncPolicy: "sometimes"})
+	require.Error(t, err)
+}
+
+func TestLog_SyncInterval(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "wal")
+	l := openLog(t, dir, Options{SyncPolicy: SyncInterval, SyncInterval: 1})
+	require.NoError(t, l.Write(context.Background(), testEvents("1")))
+	require.NoError(t, l.Flush(context.Background()))
+	require.NoError(t, l.Close())
+	require.Error(t, l.Write(context.Background(), testEvents("2")))
+}